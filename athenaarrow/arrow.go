@@ -0,0 +1,402 @@
+// Package athenaarrow reads results of go-athena queries as Apache Arrow record batches.
+//
+// It works on top of database/sql, so every result mode of go-athena can be used.
+// Values are converted from the Go types returned by the driver to the Arrow type
+// of the column, see ArrowTypeOf.
+package athenaarrow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// DefaultBatchSize is the number of rows in a record batch when batchSize is not positive.
+const DefaultBatchSize = 1024
+
+// Query runs the query on db and returns a reader of its result as record batches of
+// batchSize rows. mem defaults to memory.DefaultAllocator when nil.
+//
+// The reader holds the underlying *sql.Rows until it is released.
+func Query(ctx context.Context, db *sql.DB, query string, mem memory.Allocator, batchSize int) (array.RecordReader, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewRecordReader(rows, mem, batchSize)
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewRecordReader returns a reader of rows as record batches of batchSize rows.
+// The reader closes rows when it is released.
+func NewRecordReader(rows *sql.Rows, mem memory.Allocator, batchSize int) (array.RecordReader, error) {
+	if mem == nil {
+		mem = memory.DefaultAllocator
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]arrow.Field, len(columnTypes))
+	for i, ct := range columnTypes {
+		fields[i] = arrow.Field{Name: ct.Name(), Type: ArrowTypeOf(ct.DatabaseTypeName(), ct.ScanType()), Nullable: true}
+	}
+	return &recordReader{
+		refs:      1,
+		rows:      rows,
+		schema:    arrow.NewSchema(fields, nil),
+		mem:       mem,
+		batchSize: batchSize,
+	}, nil
+}
+
+// ArrowType returns the Arrow type used for the Athena type.
+// Types without a natural counterpart, e.g. array, map and row, are represented as string.
+func ArrowType(athenaType string) arrow.DataType {
+	if strings.HasPrefix(athenaType, "decimal") {
+		athenaType = "decimal"
+	}
+	switch athenaType {
+	case "boolean":
+		return arrow.FixedWidthTypes.Boolean
+	case "tinyint":
+		return arrow.PrimitiveTypes.Int8
+	case "smallint":
+		return arrow.PrimitiveTypes.Int16
+	case "integer", "int":
+		return arrow.PrimitiveTypes.Int32
+	case "bigint":
+		return arrow.PrimitiveTypes.Int64
+	case "float", "real":
+		return arrow.PrimitiveTypes.Float32
+	case "double", "decimal":
+		return arrow.PrimitiveTypes.Float64
+	case "date":
+		return arrow.FixedWidthTypes.Date32
+	case "timestamp", "timestamp with time zone":
+		return arrow.FixedWidthTypes.Timestamp_ms
+	case "time":
+		return arrow.FixedWidthTypes.Time32ms
+	case "interval day to second":
+		return arrow.FixedWidthTypes.Duration_ms
+	case "varbinary", "binary":
+		return arrow.BinaryTypes.Binary
+	default:
+		return arrow.BinaryTypes.String
+	}
+}
+
+var (
+	scanTypeBool     = reflect.TypeOf(false)
+	scanTypeInt64    = reflect.TypeOf(int64(0))
+	scanTypeFloat64  = reflect.TypeOf(float64(0))
+	scanTypeString   = reflect.TypeOf("")
+	scanTypeBytes    = reflect.TypeOf([]byte(nil))
+	scanTypeTime     = reflect.TypeOf(time.Time{})
+	scanTypeDuration = reflect.TypeOf(time.Duration(0))
+)
+
+// ArrowTypeOf returns the Arrow type of a column by its Athena type and the Go type of its values,
+// the ScanType of sql.ColumnType, which depends on the options of the driver, e.g. a decimal read
+// as string by DecimalAsString and a tinyint read as bool by TinyintAsBool.
+// It's ArrowType when the values have the Go type of it, and otherwise the Arrow type of the Go type.
+// Columns of values of any type, e.g. by UnconvertibleValueModeRawString, are represented as string.
+func ArrowTypeOf(athenaType string, scanType reflect.Type) arrow.DataType {
+	t := ArrowType(athenaType)
+	if scanType == nil || scanType == goType(t) {
+		return t
+	}
+	switch scanType {
+	case scanTypeBool:
+		return arrow.FixedWidthTypes.Boolean
+	case scanTypeInt64:
+		return arrow.PrimitiveTypes.Int64
+	case scanTypeFloat64:
+		return arrow.PrimitiveTypes.Float64
+	case scanTypeBytes:
+		return arrow.BinaryTypes.Binary
+	case scanTypeTime:
+		return arrow.FixedWidthTypes.Timestamp_ms
+	case scanTypeDuration:
+		return arrow.FixedWidthTypes.Duration_ms
+	default:
+		return arrow.BinaryTypes.String
+	}
+}
+
+// goType returns the Go type of the values appended to an Arrow type returned by ArrowType.
+func goType(t arrow.DataType) reflect.Type {
+	switch t.ID() {
+	case arrow.BOOL:
+		return scanTypeBool
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64:
+		return scanTypeInt64
+	case arrow.FLOAT32, arrow.FLOAT64:
+		return scanTypeFloat64
+	case arrow.DATE32, arrow.TIMESTAMP, arrow.TIME32:
+		return scanTypeTime
+	case arrow.DURATION:
+		return scanTypeDuration
+	case arrow.BINARY:
+		return scanTypeBytes
+	default:
+		return scanTypeString
+	}
+}
+
+type recordReader struct {
+	refs      int64
+	rows      *sql.Rows
+	schema    *arrow.Schema
+	mem       memory.Allocator
+	batchSize int
+	cur       arrow.RecordBatch
+	err       error
+	done      bool
+}
+
+func (r *recordReader) Retain() {
+	atomic.AddInt64(&r.refs, 1)
+}
+
+func (r *recordReader) Release() {
+	if atomic.AddInt64(&r.refs, -1) != 0 {
+		return
+	}
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	r.rows.Close()
+}
+
+func (r *recordReader) Schema() *arrow.Schema {
+	return r.schema
+}
+
+func (r *recordReader) Next() bool {
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	if r.done {
+		return false
+	}
+
+	b := array.NewRecordBuilder(r.mem, r.schema)
+	defer b.Release()
+
+	values := make([]interface{}, len(r.schema.Fields()))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	n := 0
+	for n < r.batchSize {
+		if !r.rows.Next() {
+			r.done = true
+			if err := r.rows.Err(); err != nil {
+				r.err = err
+				return false
+			}
+			break
+		}
+		if err := r.rows.Scan(dest...); err != nil {
+			r.err, r.done = err, true
+			return false
+		}
+		for i, v := range values {
+			if err := appendValue(b.Field(i), v); err != nil {
+				r.err, r.done = fmt.Errorf("column %s: %w", r.schema.Field(i).Name, err), true
+				return false
+			}
+		}
+		n++
+	}
+	if n == 0 {
+		return false
+	}
+	r.cur = b.NewRecordBatch()
+	return true
+}
+
+func (r *recordReader) RecordBatch() arrow.RecordBatch {
+	return r.cur
+}
+
+// Record returns the current record batch.
+//
+// Deprecated: use RecordBatch.
+func (r *recordReader) Record() arrow.Record {
+	return r.cur
+}
+
+func (r *recordReader) Err() error {
+	return r.err
+}
+
+func appendValue(b array.Builder, v interface{}) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+	switch b := b.(type) {
+	case *array.BooleanBuilder:
+		val, ok := v.(bool)
+		if !ok {
+			return unexpectedValue(b.Type(), v)
+		}
+		b.Append(val)
+	case *array.Int8Builder:
+		val, ok := toInt64(v)
+		if !ok {
+			return unexpectedValue(b.Type(), v)
+		}
+		b.Append(int8(val))
+	case *array.Int16Builder:
+		val, ok := toInt64(v)
+		if !ok {
+			return unexpectedValue(b.Type(), v)
+		}
+		b.Append(int16(val))
+	case *array.Int32Builder:
+		val, ok := toInt64(v)
+		if !ok {
+			return unexpectedValue(b.Type(), v)
+		}
+		b.Append(int32(val))
+	case *array.Int64Builder:
+		val, ok := toInt64(v)
+		if !ok {
+			return unexpectedValue(b.Type(), v)
+		}
+		b.Append(val)
+	case *array.Float32Builder:
+		val, ok := v.(float64)
+		if !ok {
+			return unexpectedValue(b.Type(), v)
+		}
+		b.Append(float32(val))
+	case *array.Float64Builder:
+		val, ok := v.(float64)
+		if !ok {
+			return unexpectedValue(b.Type(), v)
+		}
+		b.Append(val)
+	case *array.Date32Builder:
+		val, ok := v.(time.Time)
+		if !ok {
+			return unexpectedValue(b.Type(), v)
+		}
+		b.Append(arrow.Date32FromTime(val))
+	case *array.TimestampBuilder:
+		val, ok := v.(time.Time)
+		if !ok {
+			return unexpectedValue(b.Type(), v)
+		}
+		ts, err := arrow.TimestampFromTime(val, arrow.Millisecond)
+		if err != nil {
+			return err
+		}
+		b.Append(ts)
+	case *array.Time32Builder:
+		val, ok := v.(time.Time)
+		if !ok {
+			return unexpectedValue(b.Type(), v)
+		}
+		sinceMidnight := time.Duration(val.Hour())*time.Hour + time.Duration(val.Minute())*time.Minute +
+			time.Duration(val.Second())*time.Second + time.Duration(val.Nanosecond())
+		b.Append(arrow.Time32(sinceMidnight / b.Type().(*arrow.Time32Type).Unit.Multiplier()))
+	case *array.DurationBuilder:
+		val, ok := v.(time.Duration)
+		if !ok {
+			return unexpectedValue(b.Type(), v)
+		}
+		b.Append(arrow.Duration(val / b.Type().(*arrow.DurationType).Unit.Multiplier()))
+	case *array.BinaryBuilder:
+		switch val := v.(type) {
+		case []byte:
+			b.Append(val)
+		case string:
+			b.AppendString(val)
+		default:
+			return unexpectedValue(b.Type(), v)
+		}
+	case *array.StringBuilder:
+		switch val := v.(type) {
+		case string:
+			b.Append(val)
+		case []byte:
+			b.Append(string(val))
+		case []interface{}, map[string]interface{}:
+			b.Append(formatRendered(val))
+		default:
+			b.Append(fmt.Sprint(val))
+		}
+	default:
+		return fmt.Errorf("unsupported arrow type %s", b.Type())
+	}
+	return nil
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch val := v.(type) {
+	case int64:
+		return val, true
+	case int32:
+		return int64(val), true
+	case int:
+		return int64(val), true
+	default:
+		return 0, false
+	}
+}
+
+func unexpectedValue(t arrow.DataType, v interface{}) error {
+	return fmt.Errorf("cannot append %T to %s", v, t)
+}
+
+// formatRendered formats an array or a map returned by the driver as Athena renders it, e.g. "[1, 2]"
+// and "{a=1, b=2}". The entries of maps are sorted by their keys.
+func formatRendered(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case []interface{}:
+		elems := make([]string, len(v))
+		for i, e := range v {
+			elems[i] = formatRendered(e)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		entries := make([]string, len(keys))
+		for i, k := range keys {
+			entries[i] = k + "=" + formatRendered(v[k])
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	default:
+		return fmt.Sprint(v)
+	}
+}
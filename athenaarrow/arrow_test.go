@@ -0,0 +1,183 @@
+package athenaarrow
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { panic("not implemented") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { panic("not implemented") }
+
+func (fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	ts := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	if query == "SELECT options" {
+		// the values of decimal_as_string, tinyint_as_bool and unconvertible_value_mode=raw_string.
+		return &fakeRows{
+			columns:   []string{"price", "flag", "raw", "at", "elapsed"},
+			types:     []string{"decimal(10,2)", "tinyint", "bigint", "time", "interval day to second"},
+			scanTypes: []reflect.Type{scanTypeString, scanTypeBool, reflect.TypeOf((*interface{})(nil)).Elem(), scanTypeTime, scanTypeDuration},
+			data: [][]driver.Value{
+				{"1.50", true, int64(1), time.Date(0, 1, 1, 1, 2, 3, 4000000, time.UTC), 26*time.Hour + 1500*time.Millisecond},
+				{"-0.05", false, "NaN", time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC), time.Duration(0)},
+			},
+		}, nil
+	}
+	return &fakeRows{
+		columns:   []string{"id", "name", "price", "flag", "created_at", "day", "data", "tags"},
+		types:     []string{"bigint", "varchar", "double", "boolean", "timestamp", "date", "varbinary", "array"},
+		scanTypes: []reflect.Type{scanTypeInt64, scanTypeString, scanTypeFloat64, scanTypeBool, scanTypeTime, scanTypeTime, scanTypeBytes, reflect.TypeOf([]interface{}(nil))},
+		data: [][]driver.Value{
+			{int64(1), "a", 1.5, true, ts, ts, []byte("xy"), []interface{}{"1", []interface{}{int64(2), nil}, map[string]interface{}{"b": "2", "a": "1"}}},
+			{int64(2), nil, nil, false, ts, ts, nil, []interface{}{}},
+			{int64(3), "c", 3.0, nil, nil, nil, []byte{}, nil},
+		},
+	}, nil
+}
+
+type fakeRows struct {
+	columns   []string
+	types     []string
+	scanTypes []reflect.Type
+	data      [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.data[0])
+	r.data = r.data[1:]
+	return nil
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(index int) string { return r.types[index] }
+func (r *fakeRows) ColumnTypeScanType(index int) reflect.Type   { return r.scanTypes[index] }
+
+func init() {
+	sql.Register("athenaarrow-fake", fakeDriver{})
+}
+
+func TestQuery(t *testing.T) {
+	db, err := sql.Open("athenaarrow-fake", "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	r, err := Query(context.Background(), db, "SELECT 1", mem, 2)
+	require.NoError(t, err)
+	defer r.Release()
+
+	assert.Equal(t, arrow.PrimitiveTypes.Int64, r.Schema().Field(0).Type)
+	assert.Equal(t, arrow.BinaryTypes.String, r.Schema().Field(1).Type)
+	assert.Equal(t, arrow.FixedWidthTypes.Timestamp_ms, r.Schema().Field(4).Type)
+	assert.Equal(t, arrow.BinaryTypes.Binary, r.Schema().Field(6).Type)
+	assert.Equal(t, arrow.BinaryTypes.String, r.Schema().Field(7).Type)
+
+	var lens []int64
+	var ids []int64
+	var names, tags []string
+	for r.Next() {
+		rec := r.RecordBatch()
+		lens = append(lens, rec.NumRows())
+		ids = append(ids, rec.Column(0).(*array.Int64).Int64Values()...)
+		col := rec.Column(1).(*array.String)
+		for i := 0; i < col.Len(); i++ {
+			if col.IsNull(i) {
+				names = append(names, "<null>")
+				continue
+			}
+			names = append(names, col.Value(i))
+		}
+		tagsCol := rec.Column(7).(*array.String)
+		for i := 0; i < tagsCol.Len(); i++ {
+			tags = append(tags, tagsCol.Value(i))
+		}
+	}
+	require.NoError(t, r.Err())
+	assert.Equal(t, []int64{2, 1}, lens)
+	assert.Equal(t, []int64{1, 2, 3}, ids)
+	assert.Equal(t, []string{"a", "<null>", "c"}, names)
+	assert.Equal(t, []string{"[1, [2, null], {a=1, b=2}]", "[]", ""}, tags)
+}
+
+func TestQuery_Options(t *testing.T) {
+	db, err := sql.Open("athenaarrow-fake", "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	r, err := Query(context.Background(), db, "SELECT options", mem, 0)
+	require.NoError(t, err)
+	defer r.Release()
+
+	assert.Equal(t, arrow.BinaryTypes.String, r.Schema().Field(0).Type)
+	assert.Equal(t, arrow.FixedWidthTypes.Boolean, r.Schema().Field(1).Type)
+	assert.Equal(t, arrow.BinaryTypes.String, r.Schema().Field(2).Type)
+	assert.Equal(t, arrow.FixedWidthTypes.Time32ms, r.Schema().Field(3).Type)
+	assert.Equal(t, arrow.FixedWidthTypes.Duration_ms, r.Schema().Field(4).Type)
+
+	require.True(t, r.Next(), r.Err())
+	rec := r.RecordBatch()
+	assert.Equal(t, []string{"1.50", "-0.05"}, stringValues(rec.Column(0).(*array.String)))
+	assert.Equal(t, []bool{true, false}, []bool{rec.Column(1).(*array.Boolean).Value(0), rec.Column(1).(*array.Boolean).Value(1)})
+	assert.Equal(t, []string{"1", "NaN"}, stringValues(rec.Column(2).(*array.String)))
+	assert.Equal(t, []arrow.Time32{3723004, 0}, rec.Column(3).(*array.Time32).Time32Values())
+	assert.Equal(t, []arrow.Duration{93601500, 0}, rec.Column(4).(*array.Duration).DurationValues())
+	assert.False(t, r.Next())
+	require.NoError(t, r.Err())
+}
+
+func stringValues(col *array.String) []string {
+	values := make([]string, col.Len())
+	for i := range values {
+		values[i] = col.Value(i)
+	}
+	return values
+}
+
+func TestArrowTypeOf(t *testing.T) {
+	assert.Equal(t, arrow.PrimitiveTypes.Int8, ArrowTypeOf("tinyint", scanTypeInt64))
+	assert.Equal(t, arrow.FixedWidthTypes.Boolean, ArrowTypeOf("tinyint", scanTypeBool))
+	assert.Equal(t, arrow.PrimitiveTypes.Float64, ArrowTypeOf("decimal(10,2)", scanTypeFloat64))
+	assert.Equal(t, arrow.BinaryTypes.String, ArrowTypeOf("decimal(10,2)", scanTypeString))
+	assert.Equal(t, arrow.BinaryTypes.String, ArrowTypeOf("double", reflect.TypeOf((*interface{})(nil)).Elem()))
+	assert.Equal(t, arrow.FixedWidthTypes.Date32, ArrowTypeOf("date", nil))
+}
+
+func TestArrowType(t *testing.T) {
+	assert.Equal(t, arrow.PrimitiveTypes.Float64, ArrowType("decimal(10,2)"))
+	assert.Equal(t, arrow.PrimitiveTypes.Int32, ArrowType("integer"))
+	assert.Equal(t, arrow.FixedWidthTypes.Date32, ArrowType("date"))
+	assert.Equal(t, arrow.BinaryTypes.String, ArrowType("map"))
+}
+
+func TestAppendValue_Unexpected(t *testing.T) {
+	b := array.NewInt64Builder(memory.NewGoAllocator())
+	defer b.Release()
+	assert.Error(t, appendValue(b, "x"))
+}
@@ -0,0 +1,89 @@
+package athena
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// BackoffStrategy decides how long to wait before the attempt-th retry, which starts at 1.
+// It's used for polling the status of queries, and retrying throttled API calls.
+type BackoffStrategy interface {
+	NextDelay(attempt int) time.Duration
+}
+
+// ConstantBackoff waits the same duration before every attempt.
+type ConstantBackoff time.Duration
+
+func (b ConstantBackoff) NextDelay(_ int) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff waits Initial before the first attempt, and Multiplier times longer
+// before each following attempt up to Max. Multiplier defaults to 2, and Max is unlimited if it's zero.
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	d := float64(b.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		return b.Max
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// JitteredBackoff randomizes the delays of Backoff by Fraction, e.g. 0.2 waits 80% to 100% of them,
+// so that clients started together don't call the API at the same time.
+type JitteredBackoff struct {
+	Backoff  BackoffStrategy
+	Fraction float64
+}
+
+func (b JitteredBackoff) NextDelay(attempt int) time.Duration {
+	d := b.Backoff.NextDelay(attempt)
+	fraction := math.Min(math.Max(b.Fraction, 0), 1)
+	return d - time.Duration(rand.Float64()*fraction*float64(d))
+}
+
+// pollDelay returns the delay before the attempt-th poll of the status of a query.
+func (c *conn) pollDelay(attempt int) time.Duration {
+	if c.pollBackoff != nil {
+		return c.pollBackoff.NextDelay(attempt)
+	}
+	return c.pollFrequency
+}
+
+// retryThrottled calls fn, retrying it by retryBackoff up to maxRetries times while it's throttled.
+func (c *conn) retryThrottled(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || c.retryBackoff == nil || attempt > c.maxRetries || !request.IsErrorThrottle(err) {
+			return err
+		}
+
+		timer := time.NewTimer(c.retryBackoff.NextDelay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
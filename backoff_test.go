@@ -0,0 +1,87 @@
+package athena
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	b := ConstantBackoff(time.Second)
+	assert.Equal(t, time.Second, b.NextDelay(1))
+	assert.Equal(t, time.Second, b.NextDelay(10))
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	assert.Equal(t, 100*time.Millisecond, b.NextDelay(1))
+	assert.Equal(t, 200*time.Millisecond, b.NextDelay(2))
+	assert.Equal(t, 800*time.Millisecond, b.NextDelay(4))
+	assert.Equal(t, time.Second, b.NextDelay(5))
+	assert.Equal(t, time.Second, b.NextDelay(1000))
+
+	b = ExponentialBackoff{Initial: time.Second, Multiplier: 1.5}
+	assert.Equal(t, 1500*time.Millisecond, b.NextDelay(2))
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	b := JitteredBackoff{Backoff: ConstantBackoff(time.Second), Fraction: 0.2}
+	for i := 0; i < 100; i++ {
+		d := b.NextDelay(1)
+		assert.True(t, d > 800*time.Millisecond && d <= time.Second, d)
+	}
+}
+
+func TestConn_PollBackoff(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "q", pending: 3}
+	var attempts []int
+	c := &conn{
+		athena:        client,
+		pollFrequency: time.Hour,
+		pollBackoff: backoffFunc(func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return time.Millisecond
+		}),
+	}
+
+	_, err := c.waitOnQuery(context.Background(), "q")
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+}
+
+func TestConn_RetryThrottled(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "q", throttled: 2}
+	c := &conn{athena: client, retryBackoff: ConstantBackoff(time.Millisecond), maxRetries: 2}
+
+	id, err := c.startQuery(context.Background(), "SELECT 1", startQueryOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "q", id)
+	assert.Len(t, client.started, 1)
+
+	// it gives up after maxRetries.
+	client.throttled = 3
+	_, err = c.startQuery(context.Background(), "SELECT 1", startQueryOptions{})
+	aerr, ok := err.(awserr.Error)
+	require.True(t, ok, err)
+	assert.Equal(t, "ThrottlingException", aerr.Code())
+
+	// other errors aren't retried.
+	calls := 0
+	err = c.retryThrottled(context.Background(), func() error {
+		calls++
+		return awserr.New(athena.ErrCodeInvalidRequestException, "invalid", nil)
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+type backoffFunc func(attempt int) time.Duration
+
+func (f backoffFunc) NextDelay(attempt int) time.Duration {
+	return f(attempt)
+}
@@ -0,0 +1,263 @@
+package athena
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func init() {
+	// basic types are registered by gob itself. Arrays, maps and rows are the slices and maps of
+	// the other values, which are registered so that they can be nested.
+	gob.Register(time.Time{})
+	gob.Register(time.Duration(0))
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
+
+// cachedResult is the query result persisted in the local cache.
+type cachedResult struct {
+	Columns   []string
+	TypeNames []string
+	Rows      [][]driver.Value
+
+	// Precisions and Scales are those of decimal columns, and Precisions are zero for the others.
+	Precisions []int64
+	Scales     []int64
+	// Nullables are the nullability of the columns as Athena reports it, e.g. "NULLABLE", or empty if it's unknown.
+	Nullables []string
+}
+
+// resultCache is a local cache of query results keyed by query hash.
+// It's intended for development and testing, to replay queries offline.
+type resultCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// newResultCache returns nil when dir is empty, which means the cache is disabled.
+func newResultCache(dir string, ttl time.Duration) *resultCache {
+	if dir == "" {
+		return nil
+	}
+	return &resultCache{dir: dir, ttl: ttl}
+}
+
+func (rc *resultCache) key(db, catalog, query string, params ...string) string {
+	h := sha256.New()
+	for _, s := range append([]string{catalog, db, query}, params...) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (rc *resultCache) path(key string) string {
+	return filepath.Join(rc.dir, key+".gob")
+}
+
+// get returns the cached rows of key if it exists and isn't expired.
+func (rc *resultCache) get(key string) (driver.Rows, bool) {
+	path := rc.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if rc.ttl > 0 && time.Since(info.ModTime()) > rc.ttl {
+		return nil, false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var result cachedResult
+	if err := gob.NewDecoder(f).Decode(&result); err != nil {
+		return nil, false
+	}
+	return &rowsCached{result: result}, true
+}
+
+// put writes the result of key. Nothing is written if it fails, e.g. with values of types unknown to gob
+// returned by Config.ValueConverter.
+func (rc *resultCache) put(key string, result cachedResult) error {
+	if err := os.MkdirAll(rc.dir, 0o755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so that readers never see a partial result.
+	f, err := os.CreateTemp(rc.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := gob.NewEncoder(f).Encode(result); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), rc.path(key))
+}
+
+// wrap returns rows which write the result to the cache once all rows have been read.
+// Since the cache is best-effort, the rows don't fail when the result isn't written, and onError,
+// if it's not nil, is called with the error instead.
+func (rc *resultCache) wrap(key string, rows driver.Rows, onError func(error)) driver.Rows {
+	return &rowsCacheRecorder{Rows: rows, cache: rc, key: key, onError: onError}
+}
+
+// rowsCached is driver.Rows replaying a cached result.
+type rowsCached struct {
+	result cachedResult
+	cursor int
+}
+
+func (r *rowsCached) Columns() []string {
+	return r.result.Columns
+}
+
+func (r *rowsCached) ColumnTypeDatabaseTypeName(index int) string {
+	return r.result.TypeNames[index]
+}
+
+func (r *rowsCached) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	// the results cached before the precisions were recorded don't have them.
+	if index >= len(r.result.Precisions) || r.result.Precisions[index] == 0 {
+		return 0, 0, false
+	}
+	return r.result.Precisions[index], r.result.Scales[index], true
+}
+
+func (r *rowsCached) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if index >= len(r.result.Nullables) {
+		return false, false
+	}
+	return columnInfoNullable(&athena.ColumnInfo{Nullable: aws.String(r.result.Nullables[index])})
+}
+
+// ColumnTypeScanType returns the type of the cached values of the column,
+// since the options of the converter they were converted by aren't cached.
+func (r *rowsCached) ColumnTypeScanType(index int) reflect.Type {
+	for _, row := range r.result.Rows {
+		if row[index] != nil {
+			return reflect.TypeOf(row[index])
+		}
+	}
+	return scanTypeInterface
+}
+
+func (r *rowsCached) Next(dest []driver.Value) error {
+	if r.cursor >= len(r.result.Rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.Rows[r.cursor])
+	r.cursor++
+	return nil
+}
+
+func (r *rowsCached) Close() error {
+	return nil
+}
+
+// rowsCacheRecorder is driver.Rows recording rows read from the wrapped rows.
+type rowsCacheRecorder struct {
+	driver.Rows
+	cache   *resultCache
+	key     string
+	rows    [][]driver.Value
+	onError func(error)
+}
+
+func (r *rowsCacheRecorder) ColumnTypeDatabaseTypeName(index int) string {
+	if tn, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return tn.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *rowsCacheRecorder) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if ps, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return ps.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+func (r *rowsCacheRecorder) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if n, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return n.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *rowsCacheRecorder) ColumnTypeScanType(index int) reflect.Type {
+	if st, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return st.ColumnTypeScanType(index)
+	}
+	return scanTypeInterface
+}
+
+func (r *rowsCacheRecorder) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == io.EOF {
+		r.flush()
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	row := make([]driver.Value, len(dest))
+	copy(row, dest)
+	r.rows = append(r.rows, row)
+	return nil
+}
+
+func (r *rowsCacheRecorder) flush() {
+	if r.cache == nil {
+		return
+	}
+
+	columns := r.Rows.Columns()
+	typeNames := make([]string, len(columns))
+	precisions := make([]int64, len(columns))
+	scales := make([]int64, len(columns))
+	nullables := make([]string, len(columns))
+	for i := range columns {
+		typeNames[i] = r.ColumnTypeDatabaseTypeName(i)
+		precisions[i], scales[i], _ = r.ColumnTypePrecisionScale(i)
+		if nullable, ok := r.ColumnTypeNullable(i); ok && nullable {
+			nullables[i] = athena.ColumnNullableNullable
+		} else if ok {
+			nullables[i] = athena.ColumnNullableNotNull
+		}
+	}
+	err := r.cache.put(r.key, cachedResult{
+		Columns:    columns,
+		TypeNames:  typeNames,
+		Rows:       r.rows,
+		Precisions: precisions,
+		Scales:     scales,
+		Nullables:  nullables,
+	})
+	if err != nil && r.onError != nil {
+		r.onError(err)
+	}
+
+	// write only once, and release the recorded rows.
+	r.cache = nil
+	r.rows = nil
+}
@@ -0,0 +1,161 @@
+package athena
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAllRows(t *testing.T, rows driver.Rows) [][]driver.Value {
+	var ret [][]driver.Value
+	for {
+		dest := make([]driver.Value, len(rows.Columns()))
+		err := rows.Next(dest)
+		if err == io.EOF {
+			return ret
+		}
+		require.NoError(t, err)
+		ret = append(ret, dest)
+	}
+}
+
+func TestConn_ResultCache(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "select"}
+	c := &conn{
+		athena: client,
+		cache:  newResultCache(t.TempDir(), time.Hour),
+	}
+
+	rows, err := c.runQuery(context.Background(), "SELECT * FROM foo")
+	require.NoError(t, err)
+	expected := readAllRows(t, rows)
+	require.Len(t, expected, 9)
+
+	rows, err = c.runQuery(context.Background(), "SELECT * FROM foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first_name", "last_name"}, rows.Columns())
+	assert.Equal(t, expected, readAllRows(t, rows))
+	assert.Len(t, client.started, 1, "the second query should be read from the cache")
+
+	_, err = c.runQuery(context.Background(), "SELECT * FROM bar")
+	require.NoError(t, err)
+	assert.Len(t, client.started, 2, "another query should not be read from the cache")
+
+	_, err = c.runQuery(withExecutionParameters(context.Background(), []string{"1"}), "SELECT * FROM foo")
+	require.NoError(t, err)
+	assert.Len(t, client.started, 3, "the query with other parameters should not be read from the cache")
+
+	rows, err = c.runQuery(SetForceFreshResults(context.Background(), true), "SELECT * FROM foo")
+	require.NoError(t, err)
+	fresh := readAllRows(t, rows)
+	assert.Len(t, client.started, 4, "fresh results should not be read from the cache")
+	assert.NotEqual(t, expected, fresh)
+
+	rows, err = c.runQuery(context.Background(), "SELECT * FROM foo")
+	require.NoError(t, err)
+	assert.Equal(t, fresh, readAllRows(t, rows))
+	assert.Len(t, client.started, 4, "fresh results should refresh the cache")
+}
+
+func TestResultCache_Nullable(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "mixed"}
+	c := &conn{
+		athena: client,
+		cache:  newResultCache(t.TempDir(), time.Hour),
+	}
+	for i := 0; i < 2; i++ {
+		rows, err := c.runQuery(context.Background(), "SELECT * FROM foo")
+		require.NoError(t, err)
+		readAllRows(t, rows)
+
+		n := rows.(driver.RowsColumnTypeNullable)
+		nullable, ok := n.ColumnTypeNullable(0)
+		assert.True(t, ok)
+		assert.False(t, nullable)
+		nullable, ok = n.ColumnTypeNullable(4)
+		assert.True(t, ok)
+		assert.True(t, nullable)
+		_, ok = n.ColumnTypeNullable(1)
+		assert.False(t, ok)
+	}
+	assert.Len(t, client.started, 1, "the second query should be read from the cache")
+}
+
+func TestResultCache_Expired(t *testing.T) {
+	rc := newResultCache(t.TempDir(), time.Nanosecond)
+	require.NoError(t, rc.put("key", cachedResult{Columns: []string{"a"}}))
+	time.Sleep(time.Millisecond)
+
+	_, ok := rc.get("key")
+	assert.False(t, ok)
+}
+
+func TestResultCache_ComplexValues(t *testing.T) {
+	rc := newResultCache(t.TempDir(), time.Hour)
+	ts := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	row := []driver.Value{
+		[]interface{}{int64(1), nil, []interface{}{"a"}},
+		map[string]interface{}{"a": []interface{}{time.Second, ts}, "b": map[string]interface{}{"c": 0.5}},
+		26 * time.Hour,
+	}
+	require.NoError(t, rc.put("key", cachedResult{Columns: []string{"a", "m", "d"}, Rows: [][]driver.Value{row}}))
+
+	rows, ok := rc.get("key")
+	require.True(t, ok)
+	dest := make([]driver.Value, 3)
+	require.NoError(t, rows.Next(dest))
+	assert.Equal(t, row, dest)
+	assert.Equal(t, reflect.TypeOf(time.Duration(0)), rows.(driver.RowsColumnTypeScanType).ColumnTypeScanType(2))
+
+	// values of types unknown to gob aren't cached, which is reported.
+	type custom struct{ V int }
+	var errs []error
+	recorder := rc.wrap("custom", &rowsCached{result: cachedResult{
+		Columns: []string{"v"}, TypeNames: []string{"integer"}, Rows: [][]driver.Value{{custom{V: 1}}},
+	}}, func(err error) { errs = append(errs, err) })
+	dest = make([]driver.Value, 1)
+	require.NoError(t, recorder.Next(dest))
+	assert.Equal(t, io.EOF, recorder.Next(dest))
+	assert.Len(t, errs, 1)
+	_, ok = rc.get("custom")
+	assert.False(t, ok)
+}
+
+func TestResultCache_PrecisionScale(t *testing.T) {
+	rc := newResultCache(t.TempDir(), time.Hour)
+	recorder := &rowsCacheRecorder{
+		Rows: &rowsGzipDL{
+			ctasTableColumns: []*athena.Column{
+				genTableColumn("price", "decimal(10,2)"),
+				genTableColumn("name", "string"),
+			},
+		},
+		cache: rc,
+		key:   "key",
+	}
+	recorder.flush()
+
+	rows, ok := rc.get("key")
+	require.True(t, ok)
+	ps := rows.(driver.RowsColumnTypePrecisionScale)
+	precision, scale, ok := ps.ColumnTypePrecisionScale(0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), precision)
+	assert.Equal(t, int64(2), scale)
+	_, _, ok = ps.ColumnTypePrecisionScale(1)
+	assert.False(t, ok)
+
+	_, ok = rows.(driver.RowsColumnTypeNullable).ColumnTypeNullable(0)
+	assert.False(t, ok)
+
+	// results cached without the precisions don't report them.
+	_, _, ok = (&rowsCached{result: cachedResult{Columns: []string{"price"}}}).ColumnTypePrecisionScale(0)
+	assert.False(t, ok)
+}
@@ -0,0 +1,138 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// CalculationResult is the result of a Spark calculation.
+type CalculationResult struct {
+	CalculationID string
+
+	// ResultS3URI is the S3 location of the calculation result.
+	ResultS3URI   string
+	ResultType    string
+	StdOutS3URI   string
+	StdErrorS3URI string
+}
+
+// RunCalculation runs code as a calculation in the Spark session sessionID of
+// a Spark enabled workgroup, and blocks until it finishes.
+// The calculation is polled as queries are, by Config.PollBackoff or the poll frequency of db,
+// with throttled calls retried by Config.RetryBackoff, and cancelled when ctx is done.
+//
+// Calculations don't go through database/sql. The Spark session must be started in advance,
+// e.g. by StartSession of the AWS SDK.
+func RunCalculation(ctx context.Context, db *sql.DB, sessionID, code string) (*CalculationResult, error) {
+	var result *CalculationResult
+	err := withConn(ctx, db, func(c *conn) error {
+		// the code can't be checked to be read-only.
+		if c.readOnly {
+			return ErrReadOnly
+		}
+
+		var err error
+		result, err = c.runCalculation(ctx, sessionID, code)
+		return err
+	})
+	return result, err
+}
+
+func (c *conn) runCalculation(ctx context.Context, sessionID, code string) (*CalculationResult, error) {
+	var resp *athena.StartCalculationExecutionOutput
+	err := c.retryThrottled(ctx, func() error {
+		var err error
+		resp, err = c.athena.StartCalculationExecutionWithContext(ctx, &athena.StartCalculationExecutionInput{
+			SessionId: aws.String(sessionID),
+			CodeBlock: aws.String(code),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	calculationID := *resp.CalculationExecutionId
+	if err := c.waitOnCalculation(ctx, calculationID); err != nil {
+		return nil, err
+	}
+
+	var out *athena.GetCalculationExecutionOutput
+	err = c.retryThrottled(ctx, func() error {
+		var err error
+		out, err = c.athena.GetCalculationExecutionWithContext(ctx, &athena.GetCalculationExecutionInput{
+			CalculationExecutionId: aws.String(calculationID),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CalculationResult{CalculationID: calculationID}
+	if r := out.Result; r != nil {
+		result.ResultS3URI = aws.StringValue(r.ResultS3Uri)
+		result.ResultType = aws.StringValue(r.ResultType)
+		result.StdOutS3URI = aws.StringValue(r.StdOutS3Uri)
+		result.StdErrorS3URI = aws.StringValue(r.StdErrorS3Uri)
+	}
+	return result, nil
+}
+
+// waitOnCalculation blocks until a calculation finishes, returning an error if it failed.
+func (c *conn) waitOnCalculation(ctx context.Context, calculationID string) error {
+	for attempt := 1; ; attempt++ {
+		var statusResp *athena.GetCalculationExecutionStatusOutput
+		err := c.retryThrottled(ctx, func() error {
+			var err error
+			statusResp, err = c.athena.GetCalculationExecutionStatusWithContext(ctx, &athena.GetCalculationExecutionStatusInput{
+				CalculationExecutionId: aws.String(calculationID),
+			})
+			return err
+		})
+		if err != nil {
+			// the request fails when ctx is done during it.
+			if ctx.Err() != nil {
+				c.stopCalculation(calculationID)
+				return ctx.Err()
+			}
+			return err
+		}
+
+		switch aws.StringValue(statusResp.Status.State) {
+		case athena.CalculationExecutionStateCanceled:
+			return context.Canceled
+		case athena.CalculationExecutionStateFailed:
+			return errors.New(aws.StringValue(statusResp.Status.StateChangeReason))
+		case athena.CalculationExecutionStateCompleted:
+			return nil
+		}
+
+		timer := time.NewTimer(c.pollDelay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			c.stopCalculation(calculationID)
+
+			return ctx.Err()
+		case <-timer.C:
+			continue
+		}
+	}
+}
+
+// stopCalculation stops a calculation, retrying while it's throttled. It's called after ctx is done,
+// so the retries aren't bound to it.
+func (c *conn) stopCalculation(calculationID string) {
+	c.retryThrottled(context.Background(), func() error {
+		_, err := c.athena.StopCalculationExecution(&athena.StopCalculationExecutionInput{
+			CalculationExecutionId: aws.String(calculationID),
+		})
+		return err
+	})
+}
@@ -0,0 +1,115 @@
+package athena
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockAthenaCalculationClient struct {
+	mockAthenaClient
+
+	states []string
+	// throttles and stopThrottles are the number of times the status and the stop of calculations are throttled.
+	throttles     int
+	stopThrottles int
+	polls         int
+	stopped       int
+}
+
+func throttle(n *int) error {
+	if *n > 0 {
+		*n--
+		return awserr.New("ThrottlingException", "Rate exceeded", nil)
+	}
+	return nil
+}
+
+func (m *mockAthenaCalculationClient) StopCalculationExecution(_ *athena.StopCalculationExecutionInput) (*athena.StopCalculationExecutionOutput, error) {
+	if err := throttle(&m.stopThrottles); err != nil {
+		return nil, err
+	}
+	m.stopped++
+	return &athena.StopCalculationExecutionOutput{}, nil
+}
+
+func (m *mockAthenaCalculationClient) StartCalculationExecutionWithContext(_ aws.Context, _ *athena.StartCalculationExecutionInput, _ ...request.Option) (*athena.StartCalculationExecutionOutput, error) {
+	return &athena.StartCalculationExecutionOutput{CalculationExecutionId: aws.String("calc")}, nil
+}
+
+func (m *mockAthenaCalculationClient) GetCalculationExecutionStatusWithContext(_ aws.Context, _ *athena.GetCalculationExecutionStatusInput, _ ...request.Option) (*athena.GetCalculationExecutionStatusOutput, error) {
+	if err := throttle(&m.throttles); err != nil {
+		return nil, err
+	}
+	m.polls++
+	state := m.states[0]
+	if len(m.states) > 1 {
+		m.states = m.states[1:]
+	}
+	return &athena.GetCalculationExecutionStatusOutput{
+		Status: &athena.CalculationStatus{
+			State:             aws.String(state),
+			StateChangeReason: aws.String("boom"),
+		},
+	}, nil
+}
+
+func (m *mockAthenaCalculationClient) GetCalculationExecutionWithContext(_ aws.Context, _ *athena.GetCalculationExecutionInput, _ ...request.Option) (*athena.GetCalculationExecutionOutput, error) {
+	return &athena.GetCalculationExecutionOutput{
+		Result: &athena.CalculationResult{
+			ResultS3Uri: aws.String("s3://bucket/calc/result"),
+		},
+	}, nil
+}
+
+func TestConn_RunCalculation(t *testing.T) {
+	c := &conn{
+		athena: &mockAthenaCalculationClient{
+			states: []string{athena.CalculationExecutionStateRunning, athena.CalculationExecutionStateCompleted},
+		},
+		pollFrequency: time.Millisecond,
+	}
+
+	result, err := c.runCalculation(context.Background(), "session", "print(1)")
+	require.NoError(t, err)
+	assert.Equal(t, "calc", result.CalculationID)
+	assert.Equal(t, "s3://bucket/calc/result", result.ResultS3URI)
+
+	c.athena = &mockAthenaCalculationClient{states: []string{athena.CalculationExecutionStateFailed}}
+	_, err = c.runCalculation(context.Background(), "session", "print(1)")
+	assert.EqualError(t, err, "boom")
+}
+
+func TestConn_RunCalculation_Backoff(t *testing.T) {
+	client := &mockAthenaCalculationClient{
+		states:    []string{athena.CalculationExecutionStateRunning, athena.CalculationExecutionStateCompleted},
+		throttles: 2,
+	}
+	// the poll frequency would time the test out.
+	c := &conn{
+		athena:        client,
+		pollFrequency: time.Hour,
+		pollBackoff:   ConstantBackoff(time.Millisecond),
+		retryBackoff:  ConstantBackoff(time.Millisecond),
+		maxRetries:    2,
+	}
+	_, err := c.runCalculation(context.Background(), "session", "print(1)")
+	require.NoError(t, err)
+	assert.Equal(t, 2, client.polls)
+
+	// the calculation is stopped when ctx is done, even if it's throttled.
+	client = &mockAthenaCalculationClient{states: []string{athena.CalculationExecutionStateRunning}, stopThrottles: 1}
+	c.athena = client
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.runCalculation(ctx, "session", "print(1)")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, client.stopped)
+}
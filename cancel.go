@@ -0,0 +1,91 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// maxBatchGetQueryExecution is the maximum number of query ids for BatchGetQueryExecution.
+const maxBatchGetQueryExecution = 50
+
+// StopQueriesContaining stops the queued or running queries in the workgroup of db
+// whose SQL contains substr (e.g. a tag comment like "-- job:nightly-report"),
+// and returns the ids of the stopped queries.
+//
+// Queries submitted earlier than Athena's timeout limit are not looked up.
+// An empty substr is an error, since every query contains it.
+func StopQueriesContaining(ctx context.Context, db *sql.DB, substr string) ([]string, error) {
+	var stopped []string
+	err := withConn(ctx, db, func(c *conn) error {
+		var err error
+		stopped, err = c.stopQueriesContaining(ctx, substr)
+		return err
+	})
+	return stopped, err
+}
+
+func (c *conn) stopQueriesContaining(ctx context.Context, substr string) ([]string, error) {
+	if substr == "" {
+		return nil, errors.New("substr is empty, which would stop all queries")
+	}
+	limit := time.Now().Add(-time.Duration(timeOutLimitDefault) * time.Second)
+	stopped := make([]string, 0)
+
+	var token *string
+	for {
+		list, err := c.athena.ListQueryExecutionsWithContext(ctx, &athena.ListQueryExecutionsInput{
+			MaxResults: aws.Int64(maxBatchGetQueryExecution),
+			NextToken:  token,
+			WorkGroup:  aws.String(c.workgroup),
+		})
+		if err != nil {
+			return stopped, err
+		}
+		if len(list.QueryExecutionIds) == 0 {
+			return stopped, nil
+		}
+
+		batch, err := c.athena.BatchGetQueryExecutionWithContext(ctx, &athena.BatchGetQueryExecutionInput{
+			QueryExecutionIds: list.QueryExecutionIds,
+		})
+		if err != nil {
+			return stopped, err
+		}
+
+		reachedLimit := true
+		for _, qe := range batch.QueryExecutions {
+			if qe.Status == nil {
+				continue
+			}
+			if qe.Status.SubmissionDateTime != nil && qe.Status.SubmissionDateTime.After(limit) {
+				reachedLimit = false
+			}
+
+			state := aws.StringValue(qe.Status.State)
+			if state != athena.QueryExecutionStateQueued && state != athena.QueryExecutionStateRunning {
+				continue
+			}
+			if !strings.Contains(aws.StringValue(qe.Query), substr) {
+				continue
+			}
+
+			if _, err := c.athena.StopQueryExecutionWithContext(ctx, &athena.StopQueryExecutionInput{
+				QueryExecutionId: qe.QueryExecutionId,
+			}); err != nil {
+				return stopped, err
+			}
+			stopped = append(stopped, aws.StringValue(qe.QueryExecutionId))
+		}
+
+		if reachedLimit || list.NextToken == nil {
+			return stopped, nil
+		}
+		token = list.NextToken
+	}
+}
@@ -0,0 +1,78 @@
+package athena
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockAthenaListClient struct {
+	mockAthenaClient
+
+	executions []*athena.QueryExecution
+	stopped    []string
+}
+
+func (m *mockAthenaListClient) ListQueryExecutionsWithContext(_ aws.Context, _ *athena.ListQueryExecutionsInput, _ ...request.Option) (*athena.ListQueryExecutionsOutput, error) {
+	var ids []*string
+	for _, qe := range m.executions {
+		ids = append(ids, qe.QueryExecutionId)
+	}
+	return &athena.ListQueryExecutionsOutput{QueryExecutionIds: ids}, nil
+}
+
+func (m *mockAthenaListClient) BatchGetQueryExecutionWithContext(_ aws.Context, _ *athena.BatchGetQueryExecutionInput, _ ...request.Option) (*athena.BatchGetQueryExecutionOutput, error) {
+	return &athena.BatchGetQueryExecutionOutput{QueryExecutions: m.executions}, nil
+}
+
+func (m *mockAthenaListClient) StopQueryExecutionWithContext(_ aws.Context, input *athena.StopQueryExecutionInput, _ ...request.Option) (*athena.StopQueryExecutionOutput, error) {
+	m.stopped = append(m.stopped, *input.QueryExecutionId)
+	return &athena.StopQueryExecutionOutput{}, nil
+}
+
+func genQueryExecution(id, query, state string) *athena.QueryExecution {
+	return &athena.QueryExecution{
+		QueryExecutionId: aws.String(id),
+		Query:            aws.String(query),
+		Status: &athena.QueryExecutionStatus{
+			State:              aws.String(state),
+			SubmissionDateTime: aws.Time(time.Now()),
+		},
+	}
+}
+
+func TestConn_StopQueriesContaining(t *testing.T) {
+	client := &mockAthenaListClient{
+		executions: []*athena.QueryExecution{
+			genQueryExecution("1", "-- job:report\nSELECT 1", athena.QueryExecutionStateRunning),
+			genQueryExecution("2", "-- job:report\nSELECT 2", athena.QueryExecutionStateSucceeded),
+			genQueryExecution("3", "-- job:other\nSELECT 3", athena.QueryExecutionStateQueued),
+			genQueryExecution("4", "-- job:report\nSELECT 4", athena.QueryExecutionStateQueued),
+		},
+	}
+	c := &conn{athena: client, workgroup: "primary"}
+
+	stopped, err := c.stopQueriesContaining(context.Background(), "-- job:report")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "4"}, stopped)
+	assert.Equal(t, []string{"1", "4"}, client.stopped)
+}
+
+func TestConn_StopQueriesContaining_Empty(t *testing.T) {
+	client := &mockAthenaListClient{
+		executions: []*athena.QueryExecution{
+			genQueryExecution("1", "SELECT 1", athena.QueryExecutionStateRunning),
+		},
+	}
+	c := &conn{athena: client, workgroup: "primary"}
+
+	_, err := c.stopQueriesContaining(context.Background(), "")
+	assert.Error(t, err)
+	assert.Empty(t, client.stopped)
+}
@@ -5,20 +5,24 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"math"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
 
 	uuid "github.com/satori/go.uuid"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
 type conn struct {
 	athena         athenaiface.AthenaAPI
+	s3             s3iface.S3API
 	db             string
 	OutputLocation string
 	workgroup      string
@@ -26,50 +30,139 @@ type conn struct {
 	pollFrequency time.Duration
 
 	resultMode ResultMode
-	session    *session.Session
-	timeout    uint
+	timeout    time.Duration
 	catalog    string
+	strictMode bool
+	converter  converter
+	cache      *resultCache
+
+	downloadConcurrency int
+	downloadPrefetch    int
+	// downloadTimeout limits downloading the result instead of timeout if it's positive.
+	downloadTimeout     time.Duration
+	downloadRetries     int
+	downloadNonSelect   bool
+	validateETag        bool
+	queryRewriter       QueryRewriter
+	resultReuseMaxAge   ResultReusePolicy
+	duplicateColumnMode DuplicateColumnMode
+
+	keepCTASTableOnAbort bool
+	readOnly             bool
+
+	logger           Logger
+	scanWarningRatio float64
+	maxQueueTime     time.Duration
+	autoLimit        int
+
+	pollBackoff  BackoffStrategy
+	retryBackoff BackoffStrategy
+	maxRetries   int
+
+	shadow *ShadowConfig
+
+	ctasFormat      string
+	ctasCompression string
+	ctasDatabase    string
+	ctasTablePrefix string
+	// ctasFieldDelimiter is field_delimiter of the CTAS table of TEXTFILE, Athena's default if it's empty.
+	ctasFieldDelimiter string
+
+	maxPages           int
+	truncateAtMaxPages bool
+
+	// validQueries is shared by the connections of the connector, or nil.
+	validQueries *validQueries
+	// queryTags are the default tags of the queries.
+	queryTags map[string]string
+
+	// workGroupConfig is cached by getWorkGroupConfig.
+	workGroupConfig *WorkGroupConfig
 }
 
+// QueryContext runs a query. The arguments are passed as the execution parameters of the `?` placeholders.
 func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
-	if len(args) > 0 {
-		panic("Athena doesn't support prepared statements. Format your own arguments.")
+	ctx, err := withArgs(ctx, args)
+	if err != nil {
+		return nil, err
 	}
 
 	rows, err := c.runQuery(ctx, query)
 	return rows, err
 }
 
+// ExecContext runs a query. The arguments are passed as the execution parameters of the `?` placeholders.
 func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
-	if len(args) > 0 {
-		panic("Athena doesn't support prepared statements. Format your own arguments.")
+	ctx, err := withArgs(ctx, args)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err := c.runQuery(ctx, query)
-	return nil, err
+	rows, err := c.runQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return newResult(rows), nil
 }
 
-func (c *conn) runQuery(ctx context.Context, query string) (driver.Rows, error) {
+func (c *conn) runQuery(ctx context.Context, query string) (_ driver.Rows, err error) {
+	if queryID, ok := getQueryExecutionID(ctx); ok {
+		return c.resumeQuery(ctx, queryID)
+	}
+
+	query, err = c.rewriteQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if hasMultipleStatements(query) {
+		return nil, ErrMultipleStatements
+	}
+	if err := c.checkReadOnly(query); err != nil {
+		return nil, err
+	}
+
 	// result mode
-	isSelect := isSelectQuery(query)
+	qt := getQueryType(query)
+	isSelect := qt == queryTypeSelect
+	if isSelect && c.autoLimit > 0 {
+		if limited := addLimit(query, c.autoLimit); limited != query {
+			query = limited
+			c.log(ctx, LogLevelDebug, "LIMIT is added to query", "query", query)
+		}
+	}
 	resultMode := c.resultMode
-	if rmode, ok := getResultMode(ctx); ok {
+	rmode, fromContext := getResultMode(ctx)
+	if fromContext {
 		resultMode = rmode
 	}
-	if !isSelect {
+	// DML and UNLOAD statements write data instead of returning rows, so they're always run in API mode.
+	writes := qt == queryTypeDML || qt == queryTypeUnload
+	if !isSelect && (writes || !(c.downloadNonSelect && resultMode == ResultModeDL)) {
+		if c.strictMode && fromContext && resultMode != ResultModeAPI {
+			return nil, ErrResultModeMismatch
+		}
 		resultMode = ResultModeAPI
 	}
 
 	// timeout
-	timeout := c.timeout
-	if to, ok := getTimeout(ctx); ok {
-		timeout = to
-	}
+	timeout := c.getTimeout(ctx)
 
-	// catalog
-	catalog := c.catalog
-	if cat, ok := getCatalog(ctx); ok {
-		catalog = cat
+	// catalog and database
+	catalog, database := c.getQueryContext(ctx)
+
+	// local result cache
+	fresh := getForceFreshResults(ctx)
+	var cacheKey string
+	params := getExecutionParameters(ctx)
+	if c.cache != nil && isSelect {
+		// the fresh result still refreshes the cache by the key.
+		cacheKey = c.cache.key(database, catalog, query, params...)
+		if !fresh {
+			if rows, ok := c.cache.get(cacheKey); ok {
+				return rows, nil
+			}
+		}
 	}
 
 	// output location (with empty value)
@@ -81,64 +174,550 @@ func (c *conn) runQuery(ctx context.Context, query string) (driver.Rows, error)
 		}
 	}
 
+	// the shadow query is the SELECT query before it's rewritten into CTAS.
+	shadowQuery := query
+
 	// mode ctas
 	var ctasTable string
+	var format ctasFormat
+	var tableOpts ctasTableOptions
 	var afterDownload func() error
-	if isSelect && resultMode == ResultModeGzipDL {
+	var ctasStarted, ctasSucceeded bool
+	if isSelect && (resultMode == ResultModeGzipDL || resultMode == ResultModeParquetDL) {
+		format, err = c.getCTASFormat(ctx, resultMode)
+		if err != nil {
+			return nil, err
+		}
+
+		tableOpts, err = c.getCTASTableOptions(ctx)
+		if err != nil {
+			return nil, err
+		}
+
 		// Create AS Select
-		ctasTable = fmt.Sprintf("tmp_ctas_%v", strings.Replace(uuid.NewV4().String(), "-", "", -1))
-		query = fmt.Sprintf("CREATE TABLE %s WITH (format='TEXTFILE') AS %s", ctasTable, query)
-		afterDownload = c.dropCTASTable(ctx, ctasTable)
+		ctasTable = tableOpts.prefix + strings.Replace(uuid.NewV4().String(), "-", "", -1)
+		query = fmt.Sprintf("CREATE TABLE %s WITH (%s) AS %s", tableOpts.qualify(ctasTable), format.withClause(), query)
+		afterDownload = c.dropCTASTable(ctx, tableOpts.database, ctasTable)
+		c.log(ctx, LogLevelDebug, "query is rewritten into CTAS", "table", ctasTable, "query", query)
+
+		// the rows drop the table once they're read or closed, so it's dropped here only if they aren't returned,
+		// e.g. when the query fails or the download fails. With keepCTASTableOnAbort, the table of
+		// a succeeded query is kept to be read again with SetQueryExecutionID.
+		defer func() {
+			if err != nil && ctasStarted && !(ctasSucceeded && c.keepCTASTableOnAbort) {
+				_ = afterDownload()
+			}
+		}()
+	}
+
+	opts := startQueryOptions{clientRequestToken: getClientRequestToken(ctx), executionParameters: params}
+	if c.resultReuseMaxAge != nil && isSelectQuery(query) && !fresh {
+		opts.resultReuseMaxAge = c.resultReuseMaxAge(ctx, query)
 	}
 
-	queryID, err := c.startQuery(query)
+	queryID, err := c.startQuery(ctx, query, opts)
 	if err != nil {
 		return nil, err
 	}
+	ctasStarted = true
 
-	if err := c.waitOnQuery(ctx, queryID); err != nil {
+	var polls int
+	waitStart := time.Now()
+	qe, err := c.waitOnQueryTimeout(ctx, queryID, timeout, &polls)
+	if err != nil {
 		return nil, err
 	}
+	ctasSucceeded = true
+
+	if stats, ok := getQueryStatsReceiver(ctx); ok {
+		stats.setQueryExecution(qe)
+		stats.PollCount = polls
+		stats.WaitTime = time.Since(waitStart)
+	}
+
+	if isSelect {
+		c.warnLargeScan(ctx, qe, ctasTable != "")
+	}
+
+	if obj, ok := getResultObjectReceiver(ctx); ok {
+		location := resultLocation(qe)
+		if ctasTable != "" {
+			location = manifestLocation(qe)
+		}
+		head, err := headResultObject(ctx, c.s3, location)
+		if err != nil {
+			return nil, err
+		}
+		*obj = *head
+	}
+
+	cfg := c.newRowsConfig(ctx, qe, query, resultMode, timeout)
+	cfg.AfterDownload = afterDownload
+	cfg.CTASTable = ctasTable
+	cfg.CTASDatabase = tableOpts.database
+	cfg.CTASFormat = format.format
+	cfg.CTASFieldDelimiter = format.fieldDelimiter
+	rows, err := newRows(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.shadow != nil && isSelect {
+		rows = c.shadowRows(ctx, shadowQuery, queryID, timeout, rows)
+	}
+	if cacheKey != "" {
+		rows = c.cache.wrap(cacheKey, rows, func(err error) {
+			c.log(ctx, LogLevelWarn, "failed to write the result to the cache", "query_id", queryID, "error", err)
+		})
+	}
+	return rows, nil
+}
 
-	return newRows(rowsConfig{
+// newRowsConfig returns the config of the rows of a query execution.
+// query is the query run, which may be different from the one of the user, e.g. CTAS.
+func (c *conn) newRowsConfig(
+	ctx context.Context,
+	qe *athena.QueryExecution,
+	query string,
+	resultMode ResultMode,
+	timeout time.Duration,
+) rowsConfig {
+	catalog, database := c.getQueryContext(ctx)
+	queryID := aws.StringValue(qe.QueryExecutionId)
+	rowConverter := c.converter
+	// the values treated as truncated are logged even if they're returned as nil or raw strings.
+	rowConverter.onTruncatedValue = func(column string, length int) {
+		c.log(ctx, LogLevelWarn, "value may be truncated by GetQueryResults", "query_id", queryID, "column", column, "bytes", length)
+	}
+	return rowsConfig{
 		Athena:         c.athena,
 		QueryID:        queryID,
 		SkipHeader:     !isDDLQuery(query),
 		ResultMode:     resultMode,
-		Session:        c.session,
+		S3:             c.s3,
 		OutputLocation: c.OutputLocation,
+		ResultLocation: resultLocation(qe),
 		Timeout:        timeout,
-		AfterDownload:  afterDownload,
-		CTASTable:      ctasTable,
-		DB:             c.db,
+		DB:             database,
 		Catalog:        catalog,
-	})
+		Converter:      rowConverter,
+
+		DownloadConcurrency:  c.downloadConcurrency,
+		DownloadTimeout:      c.downloadTimeout,
+		DownloadRetries:      c.downloadRetries,
+		MaxPages:             c.maxPages,
+		TruncateAtMaxPages:   c.truncateAtMaxPages,
+		DownloadPrefetch:     c.downloadPrefetch,
+		ProjectedColumns:     getColumnProjection(ctx),
+		ManifestLocation:     manifestLocation(qe),
+		ValidateETag:         c.validateETag,
+		DuplicateColumnMode:  c.duplicateColumnMode,
+		KeepCTASTableOnAbort: c.keepCTASTableOnAbort,
+	}
+}
+
+const (
+	ctasFormatTextfile = "TEXTFILE"
+	ctasFormatParquet  = "PARQUET"
+)
+
+// ctasCompressions are the compressions of each CTAS format which the driver can read.
+var ctasCompressions = map[string][]string{
+	ctasFormatTextfile: {"GZIP", "NONE"},
+	ctasFormatParquet:  {"SNAPPY", "GZIP", "NONE"},
+}
+
+// ctasFormat is the format of the CTAS table of GZIP DL and PARQUET DL mode.
+type ctasFormat struct {
+	format string
+	// compression is write_compression of the table. Athena's default of the format is used if it's empty.
+	compression string
+	// fieldDelimiter is field_delimiter of TEXTFILE. Athena's default, '\001', is used if it's empty.
+	fieldDelimiter string
+}
+
+// ctasFieldDelimiterRegex matches the field delimiters of TEXTFILE the driver can put in the CTAS query
+// and find in it again: a tab, or a punctuation without quotes, backslashes and parentheses.
+var ctasFieldDelimiterRegex = regexp.MustCompile(`^[\t !#$%&*+,\-./:;<=>?@^_|~]$`)
+
+// newCTASFormat validates a format, its compression and the field delimiter of TEXTFILE.
+// The format and the compression are case insensitive, and the format defaults to TEXTFILE.
+func newCTASFormat(format, compression, fieldDelimiter string) (ctasFormat, error) {
+	f := ctasFormat{format: strings.ToUpper(format), compression: strings.ToUpper(compression), fieldDelimiter: fieldDelimiter}
+	if f.format == "" {
+		f.format = ctasFormatTextfile
+	}
+	compressions, ok := ctasCompressions[f.format]
+	if !ok {
+		return ctasFormat{}, fmt.Errorf("unsupported CTAS format: %s", format)
+	}
+	if f.fieldDelimiter != "" {
+		if f.format != ctasFormatTextfile {
+			return ctasFormat{}, fmt.Errorf("field delimiter of CTAS format %s is not supported", f.format)
+		}
+		if !ctasFieldDelimiterRegex.MatchString(f.fieldDelimiter) {
+			return ctasFormat{}, fmt.Errorf("unsupported field delimiter of CTAS table: %q", f.fieldDelimiter)
+		}
+	}
+	if f.compression == "" {
+		return f, nil
+	}
+	for _, c := range compressions {
+		if f.compression == c {
+			return f, nil
+		}
+	}
+	return ctasFormat{}, fmt.Errorf("unsupported compression of CTAS format %s: %s", f.format, compression)
+}
+
+func (f ctasFormat) withClause() string {
+	clause := fmt.Sprintf("format='%s'", f.format)
+	if f.compression != "" {
+		clause += fmt.Sprintf(", write_compression='%s'", f.compression)
+	}
+	if f.fieldDelimiter != "" {
+		clause += fmt.Sprintf(", field_delimiter='%s'", f.fieldDelimiter)
+	}
+	return clause
+}
+
+// getCTASFormat returns the format of the CTAS table set in context or the connection.
+// PARQUET DL mode always uses PARQUET, which is compressed by SNAPPY unless its compression is set.
+// The field delimiter of the connection is used only for TEXTFILE.
+func (c *conn) getCTASFormat(ctx context.Context, resultMode ResultMode) (ctasFormat, error) {
+	format, compression := c.ctasFormat, c.ctasCompression
+	if f, ok := getCTASFormat(ctx); ok {
+		format, compression = f.format, f.compression
+	}
+	if resultMode == ResultModeParquetDL && !strings.EqualFold(format, ctasFormatParquet) {
+		format, compression = ctasFormatParquet, ""
+	}
+	fieldDelimiter := c.ctasFieldDelimiter
+	if strings.EqualFold(format, ctasFormatParquet) {
+		fieldDelimiter = ""
+		if compression == "" {
+			compression = "SNAPPY"
+		}
+	}
+	return newCTASFormat(format, compression, fieldDelimiter)
+}
+
+// defaultCTASTablePrefix is the prefix of the name of the CTAS table, which is followed by a random hex.
+const defaultCTASTablePrefix = "tmp_ctas_"
+
+// ctasNameRegex matches the database and the table prefix of the CTAS table, which are put in queries unquoted.
+var ctasNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ctasTableOptions is where the CTAS table of GZIP DL and PARQUET DL mode is created.
+type ctasTableOptions struct {
+	// database is the database of the table. The database of the query is used if it's empty.
+	database string
+	// prefix is the prefix of the name of the table.
+	prefix string
 }
 
-func (c *conn) dropCTASTable(ctx context.Context, table string) func() error {
+// newCTASTableOptions validates the database and the prefix of the CTAS table.
+// The prefix defaults to "tmp_ctas_".
+func newCTASTableOptions(database, prefix string) (ctasTableOptions, error) {
+	if database != "" && !ctasNameRegex.MatchString(database) {
+		return ctasTableOptions{}, fmt.Errorf("invalid database of CTAS table: %s", database)
+	}
+	if prefix == "" {
+		prefix = defaultCTASTablePrefix
+	}
+	if !ctasNameRegex.MatchString(prefix) {
+		return ctasTableOptions{}, fmt.Errorf("invalid prefix of CTAS table: %s", prefix)
+	}
+	return ctasTableOptions{database: database, prefix: prefix}, nil
+}
+
+// qualify returns the name of a table qualified by the database if it's set.
+func (o ctasTableOptions) qualify(table string) string {
+	if o.database == "" {
+		return table
+	}
+	return o.database + "." + table
+}
+
+// isCTASTable is whether a table is the CTAS table with the prefix.
+func (o ctasTableOptions) isCTASTable(table string) bool {
+	return strings.HasPrefix(table, o.prefix) && ctasTableSuffixRegex.MatchString(strings.TrimPrefix(table, o.prefix))
+}
+
+var ctasTableSuffixRegex = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// getCTASTableOptions returns the database and the prefix of the CTAS table set in context or the connection.
+// Each of them set in context overrides the one of the connection unless it's empty.
+func (c *conn) getCTASTableOptions(ctx context.Context) (ctasTableOptions, error) {
+	database, prefix := c.ctasDatabase, c.ctasTablePrefix
+	if o, ok := getCTASTable(ctx); ok {
+		if o.database != "" {
+			database = o.database
+		}
+		if o.prefix != "" {
+			prefix = o.prefix
+		}
+	}
+	return newCTASTableOptions(database, prefix)
+}
+
+// ctasQueryRegex matches the CTAS query run by GZIP DL and PARQUET DL mode,
+// capturing the database (if any), the table and the format.
+var ctasQueryRegex = regexp.MustCompile(`^CREATE TABLE (?:([A-Za-z0-9_]+)\.)?([A-Za-z0-9_]+) WITH \(format='(TEXTFILE|PARQUET)'[^)]*\) AS `)
+
+// ctasFieldDelimiterClauseRegex matches the field delimiter in the WITH clause of the CTAS query,
+// capturing the delimiter.
+var ctasFieldDelimiterClauseRegex = regexp.MustCompile(`field_delimiter='(.)'`)
+
+// resumeQuery reads the result of a query which has already been run, e.g. to retry reading it
+// after the download was interrupted. The result of GZIP DL mode is read from its CTAS table.
+func (c *conn) resumeQuery(ctx context.Context, queryID string) (driver.Rows, error) {
+	timeout := c.getTimeout(ctx)
+
+	qe, err := c.waitOnQueryTimeout(ctx, queryID, timeout, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the tags are prepended to the query as a comment.
+	query := stripLeadingComments(aws.StringValue(qe.Query))
+
+	tableOpts, err := c.getCTASTableOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resultMode := ResultModeAPI
+	var ctasDatabase, ctasTable, format, fieldDelimiter string
+	var afterDownload func() error
+	if m := ctasQueryRegex.FindStringSubmatch(query); m != nil && tableOpts.isCTASTable(m[2]) {
+		resultMode = ResultModeGzipDL
+		if m[3] == ctasFormatParquet {
+			resultMode = ResultModeParquetDL
+		}
+		ctasDatabase = m[1]
+		ctasTable = m[2]
+		format = m[3]
+		if d := ctasFieldDelimiterClauseRegex.FindStringSubmatch(m[0]); d != nil {
+			fieldDelimiter = d[1]
+		}
+		afterDownload = c.dropCTASTable(ctx, ctasDatabase, ctasTable)
+	} else if isSelectQuery(query) {
+		resultMode = c.resultMode
+		if rmode, ok := getResultMode(ctx); ok {
+			resultMode = rmode
+		}
+		if resultMode == ResultModeGzipDL || resultMode == ResultModeParquetDL {
+			// the query wasn't run by CTAS.
+			resultMode = ResultModeDL
+		}
+	}
+
+	cfg := c.newRowsConfig(ctx, qe, query, resultMode, timeout)
+	cfg.AfterDownload = afterDownload
+	cfg.CTASTable = ctasTable
+	cfg.CTASDatabase = ctasDatabase
+	cfg.CTASFormat = format
+	cfg.CTASFieldDelimiter = fieldDelimiter
+	rows, err := newRows(cfg)
+	if err != nil && afterDownload != nil && !c.keepCTASTableOnAbort {
+		_ = afterDownload()
+	}
+	return rows, err
+}
+
+// resultLocation returns the S3 location of the query result, or empty if it's unknown.
+func resultLocation(qe *athena.QueryExecution) string {
+	if qe == nil || qe.ResultConfiguration == nil {
+		return ""
+	}
+	return aws.StringValue(qe.ResultConfiguration.OutputLocation)
+}
+
+// warnLargeScan logs a warning if the query scanned more than scanWarningRatio times the size
+// of its result, which often means a missing partition filter.
+// It's best-effort, so failures to look up the size of the result are ignored.
+func (c *conn) warnLargeScan(ctx context.Context, qe *athena.QueryExecution, ctas bool) {
+	if c.logger == nil || c.scanWarningRatio <= 0 || qe.Statistics == nil {
+		return
+	}
+	scanned := aws.Int64Value(qe.Statistics.DataScannedInBytes)
+	if scanned == 0 {
+		return
+	}
+
+	var size int64
+	if ctas {
+		// the objects of CTAS table are written next to its manifest.
+		location := strings.TrimSuffix(manifestLocation(qe), "-manifest.csv") + "/"
+		bucket, prefix, err := parseS3URI(location)
+		if err != nil {
+			return
+		}
+		if size, err = sumObjectSizes(ctx, c.s3, bucket, prefix); err != nil {
+			return
+		}
+	} else {
+		obj, err := headResultObject(ctx, c.s3, resultLocation(qe))
+		if err != nil {
+			return
+		}
+		size = obj.ContentLength
+	}
+
+	// an empty result is compared as a byte.
+	if float64(scanned) > c.scanWarningRatio*math.Max(float64(size), 1) {
+		c.log(ctx, LogLevelWarn, "query scanned much more data than its result, a partition filter may be missing",
+			"query_id", aws.StringValue(qe.QueryExecutionId),
+			"data_scanned_bytes", scanned,
+			"result_bytes", size,
+		)
+	}
+}
+
+// manifestLocation returns the S3 location of the data manifest, or empty if it's unknown.
+func manifestLocation(qe *athena.QueryExecution) string {
+	if qe == nil || qe.Statistics == nil {
+		return ""
+	}
+	return aws.StringValue(qe.Statistics.DataManifestLocation)
+}
+
+// ctasDropTimeout is the timeout of dropping the CTAS table.
+const ctasDropTimeout = time.Minute
+
+// dropCTASTable returns the function dropping the CTAS table in a database, or the database of the query if it's empty.
+// It's best-effort, so a failure is logged instead of returned: the table may not even be created when the query failed.
+// The table is dropped in another context than ctx, which is often done when the query or the download is aborted.
+func (c *conn) dropCTASTable(ctx context.Context, database, table string) func() error {
 	return func() error {
-		query := fmt.Sprintf("DROP TABLE %s", table)
+		query := fmt.Sprintf("DROP TABLE IF EXISTS %s", ctasTableOptions{database: database}.qualify(table))
+		c.log(ctx, LogLevelDebug, "CTAS table of GZIP DL mode is dropped", "table", table, "query", query)
 
-		queryID, err := c.startQuery(query)
+		// the table is dropped in the catalog and the database of the query even if ctx is done.
+		dropCtx, cancel := context.WithTimeout(context.Background(), ctasDropTimeout)
+		defer cancel()
+		catalog, db := c.getQueryContext(ctx)
+		dropCtx = SetQueryContext(dropCtx, catalog, db)
+		queryID, err := c.startQuery(dropCtx, query, startQueryOptions{})
+		if err == nil {
+			_, err = c.waitOnQuery(dropCtx, queryID)
+		}
 		if err != nil {
-			return err
+			c.log(ctx, LogLevelWarn, "failed to drop CTAS table", "table", table, "error", err)
+		}
+		return nil
+	}
+}
+
+// getTimeout returns the timeout of queries set in context by SetQueryTimeout, or the one of the connection.
+func (c *conn) getTimeout(ctx context.Context) time.Duration {
+	if to, ok := getTimeout(ctx); ok {
+		return to
+	}
+	return c.timeout
+}
+
+// getQueryContext returns the catalog and the database of queries set in context by SetQueryContext,
+// or the ones of the connection. The catalog can also be set alone by CatalogContextKey.
+func (c *conn) getQueryContext(ctx context.Context) (catalog, database string) {
+	catalog, database = c.catalog, c.db
+	if cat, ok := getCatalog(ctx); ok {
+		catalog = cat
+	}
+	if qc, ok := getQueryContext(ctx); ok {
+		if qc.catalog != "" {
+			catalog = qc.catalog
 		}
+		if qc.database != "" {
+			database = qc.database
+		}
+	}
+	return catalog, database
+}
 
-		return c.waitOnQuery(ctx, queryID)
+// getQueryTags returns the tags of a query, which are Config.QueryTags overridden by the ones set in context.
+func (c *conn) getQueryTags(ctx context.Context) map[string]string {
+	tags := getQueryTags(ctx)
+	if len(c.queryTags) == 0 {
+		return tags
+	}
+	merged := make(map[string]string)
+	for k, v := range c.queryTags {
+		merged[k] = v
 	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
+
+// tagQuery prepends the tags to a query as a comment, e.g. `/* tags: app=etl, team=data */`,
+// since StartQueryExecution has no tags. The DDL statements except CTAS are kept as they are.
+func tagQuery(query string, tags map[string]string) string {
+	if len(tags) == 0 || (isDDLQuery(query) && !isCTASQuery(query)) {
+		return query
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + tags[k]
+	}
+	// the tags can't end the comment.
+	comment := strings.ReplaceAll(strings.Join(pairs, ", "), "*/", "* /")
+	return fmt.Sprintf("/* tags: %s */\n%s", comment, query)
+}
+
+// startQueryOptions are the options of a query execution.
+type startQueryOptions struct {
+	// clientRequestToken starts the query idempotently when it's not empty:
+	// Athena returns the ID of the query already started with the token instead of starting another one.
+	clientRequestToken string
+	// resultReuseMaxAge enables Athena's result reuse when it's positive. It's rounded up to minutes.
+	resultReuseMaxAge time.Duration
+	// executionParameters are the literals of the `?` placeholders of the query.
+	executionParameters []string
 }
 
 // startQuery starts an Athena query and returns its ID.
-func (c *conn) startQuery(query string) (string, error) {
-	resp, err := c.athena.StartQueryExecution(&athena.StartQueryExecutionInput{
-		QueryString: aws.String(query),
+func (c *conn) startQuery(ctx context.Context, query string, opts startQueryOptions) (string, error) {
+	catalog, database := c.getQueryContext(ctx)
+	input := &athena.StartQueryExecutionInput{
+		QueryString: aws.String(tagQuery(query, c.getQueryTags(ctx))),
 		QueryExecutionContext: &athena.QueryExecutionContext{
-			Database: aws.String(c.db),
+			Catalog:  aws.String(catalog),
+			Database: aws.String(database),
 		},
 		ResultConfiguration: &athena.ResultConfiguration{
-			OutputLocation: aws.String(c.OutputLocation),
+			OutputLocation: aws.String(resolveOutputLocation(c.OutputLocation, time.Now())),
 		},
 		WorkGroup: aws.String(c.workgroup),
+	}
+	if opts.clientRequestToken != "" {
+		input.ClientRequestToken = aws.String(opts.clientRequestToken)
+	}
+	if len(opts.executionParameters) > 0 {
+		input.ExecutionParameters = aws.StringSlice(opts.executionParameters)
+	}
+	if opts.resultReuseMaxAge > 0 {
+		minutes := int64((opts.resultReuseMaxAge + time.Minute - 1) / time.Minute)
+		input.ResultReuseConfiguration = &athena.ResultReuseConfiguration{
+			ResultReuseByAgeConfiguration: &athena.ResultReuseByAgeConfiguration{
+				Enabled:         aws.Bool(true),
+				MaxAgeInMinutes: aws.Int64(minutes),
+			},
+		}
+	}
+
+	var resp *athena.StartQueryExecutionOutput
+	err := c.retryThrottled(ctx, func() error {
+		var err error
+		resp, err = c.athena.StartQueryExecution(input)
+		return err
 	})
 	if err != nil {
 		return "", err
@@ -147,41 +726,134 @@ func (c *conn) startQuery(query string) (string, error) {
 	return *resp.QueryExecutionId, nil
 }
 
-// waitOnQuery blocks until a query finishes, returning an error if it failed.
-func (c *conn) waitOnQuery(ctx context.Context, queryID string) error {
-	for {
-		statusResp, err := c.athena.GetQueryExecutionWithContext(ctx, &athena.GetQueryExecutionInput{
-			QueryExecutionId: aws.String(queryID),
+// resolveOutputLocation replaces the date placeholders of the output location, e.g.
+// "s3://bucket/results/{yyyy}/{mm}/{dd}/", with the UTC date of t when the query is submitted.
+// The results are written under it by Athena, e.g. "<location>/<query id>.csv".
+func resolveOutputLocation(location string, t time.Time) string {
+	if !strings.Contains(location, "{") {
+		return location
+	}
+	t = t.UTC()
+	return strings.NewReplacer(
+		"{yyyy}", t.Format("2006"),
+		"{mm}", t.Format("01"),
+		"{dd}", t.Format("02"),
+		"{hh}", t.Format("15"),
+	).Replace(location)
+}
+
+// waitOnQuery blocks until a query finishes, returning the query execution
+// or an error if it failed.
+func (c *conn) waitOnQuery(ctx context.Context, queryID string) (*athena.QueryExecution, error) {
+	return c.waitOnQueryPolling(ctx, queryID, nil)
+}
+
+// waitOnQueryTimeout is waitOnQueryPolling which stops the query if it doesn't finish in timeout.
+// The parent ctx is kept for the query stopped by the timeout to be distinguished from ctx being done.
+func (c *conn) waitOnQueryTimeout(ctx context.Context, queryID string, timeout time.Duration, polls *int) (*athena.QueryExecution, error) {
+	if timeout <= 0 {
+		return c.waitOnQueryPolling(ctx, queryID, polls)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	qe, err := c.waitOnQueryPolling(waitCtx, queryID, polls)
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		return nil, fmt.Errorf("query %s didn't finish in %s: %w", queryID, timeout, err)
+	}
+	return qe, err
+}
+
+// waitOnQueryPolling is waitOnQuery which counts GetQueryExecution calls in polls unless it's nil.
+func (c *conn) waitOnQueryPolling(ctx context.Context, queryID string, polls *int) (*athena.QueryExecution, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		if polls != nil {
+			*polls++
+		}
+		var statusResp *athena.GetQueryExecutionOutput
+		err := c.retryThrottled(ctx, func() error {
+			var err error
+			statusResp, err = c.athena.GetQueryExecutionWithContext(ctx, &athena.GetQueryExecutionInput{
+				QueryExecutionId: aws.String(queryID),
+			})
+			return err
 		})
 		if err != nil {
-			return err
+			// the request fails when ctx is done during it.
+			if ctx.Err() != nil {
+				c.stopQuery(queryID)
+				return nil, ctx.Err()
+			}
+			return nil, err
 		}
 
 		switch *statusResp.QueryExecution.Status.State {
 		case athena.QueryExecutionStateCancelled:
-			return context.Canceled
+			reason := aws.StringValue(statusResp.QueryExecution.Status.StateChangeReason)
+			if isBytesScannedCutoffReason(reason) {
+				return nil, c.bytesScannedCutoffError(ctx, queryID, reason)
+			}
+			return nil, context.Canceled
 		case athena.QueryExecutionStateFailed:
 			reason := *statusResp.QueryExecution.Status.StateChangeReason
-			return errors.New(reason)
+			return nil, errors.New(reason)
 		case athena.QueryExecutionStateSucceeded:
-			return nil
+			return statusResp.QueryExecution, nil
 		case athena.QueryExecutionStateQueued:
 		case athena.QueryExecutionStateRunning:
 		}
 
+		wait := c.pollDelay(attempt)
+		if c.maxQueueTime > 0 && *statusResp.QueryExecution.Status.State == athena.QueryExecutionStateQueued {
+			queued := time.Since(start)
+			if queued >= c.maxQueueTime {
+				c.stopQuery(queryID)
+				return nil, fmt.Errorf("query %s was queued for more than %s: %w", queryID, c.maxQueueTime, ErrQueueTimeout)
+			}
+			// poll again as soon as the limit is reached.
+			if rest := c.maxQueueTime - queued; rest < wait {
+				wait = rest
+			}
+		}
+
+		timer := time.NewTimer(wait)
 		select {
 		case <-ctx.Done():
-			c.athena.StopQueryExecution(&athena.StopQueryExecutionInput{
-				QueryExecutionId: aws.String(queryID),
-			})
+			timer.Stop()
+			c.stopQuery(queryID)
 
-			return ctx.Err()
-		case <-time.After(c.pollFrequency):
+			return nil, ctx.Err()
+		case <-timer.C:
 			continue
 		}
 	}
 }
 
+// isBytesScannedCutoffReason reports whether the reason of a cancelled query means
+// that it exceeded BytesScannedCutoffPerQuery of the workgroup.
+func isBytesScannedCutoffReason(reason string) bool {
+	return strings.Contains(strings.ToLower(reason), "bytes scanned limit was exceeded")
+}
+
+func (c *conn) bytesScannedCutoffError(ctx context.Context, queryID, reason string) error {
+	e := &BytesScannedCutoffExceededError{QueryID: queryID, Reason: reason}
+
+	// the cutoff is best-effort, since it's only for the error message.
+	if wg, err := c.getWorkGroupConfig(ctx); err == nil {
+		e.Cutoff = wg.BytesScannedCutoffPerQuery
+	}
+	return e
+}
+
+// stopQuery stops a query. It's best-effort since it's called when the query is abandoned.
+func (c *conn) stopQuery(queryID string) {
+	c.athena.StopQueryExecution(&athena.StopQueryExecutionInput{
+		QueryExecutionId: aws.String(queryID),
+	})
+}
+
 func (c *conn) Prepare(query string) (driver.Stmt, error) {
 	panic("Athena doesn't support prepared statements")
 }
@@ -211,18 +883,207 @@ func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
 var _ driver.Queryer = (*conn)(nil)
 var _ driver.Execer = (*conn)(nil)
 
+// readOnlyQueryRegex matches the queries which don't modify data or metadata.
+// EXPLAIN ANALYZE is excluded since it runs the query.
+var readOnlyQueryRegex = regexp.MustCompile(`(?i)^(SELECT|WITH|SHOW|DESCRIBE|EXPLAIN\s+(\(|SELECT|WITH|INSERT|CREATE|DELETE|UPDATE|MERGE))`)
+
+// checkReadOnly returns ErrReadOnly if the connection is read-only and query may modify data or metadata.
+// Queries run by the driver itself, e.g. CTAS of GZIP DL mode, are not checked.
+func (c *conn) checkReadOnly(query string) error {
+	if c.readOnly && !readOnlyQueryRegex.MatchString(stripLeadingComments(query)) {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// rewriteQuery normalizes a query of the user, and rewrites it by the QueryRewriter of the connection if it's set.
+func (c *conn) rewriteQuery(ctx context.Context, query string) (string, error) {
+	query = normalizeQuery(query)
+	if c.queryRewriter == nil {
+		return query, nil
+	}
+
+	rewritten, err := c.queryRewriter(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	rewritten = normalizeQuery(rewritten)
+	if rewritten != query {
+		c.log(ctx, LogLevelDebug, "query is rewritten by QueryRewriter", "original", query, "query", rewritten)
+	}
+	return rewritten, nil
+}
+
+// normalizeQuery trims surrounding whitespace, and trailing semicolons and comments from query,
+// which would break the query when it's wrapped, e.g. by CTAS.
+func normalizeQuery(query string) string {
+	return stripTrailingComments(strings.TrimSpace(query))
+}
+
+// stripTrailingComments removes the whitespace, semicolons, line comments and block comments
+// after the last token of a query. Semicolons and comments in string literals and quoted
+// identifiers are kept, and so is a query with an unterminated one.
+func stripTrailingComments(query string) string {
+	end := 0
+	for i := 0; i < len(query); i++ {
+		switch {
+		case query[i] == '\'' || query[i] == '"':
+			// '' and "" are escaped quotes, which are read as two literals.
+			j := strings.IndexByte(query[i+1:], query[i])
+			if j < 0 {
+				return query
+			}
+			i += j + 1
+			end = i + 1
+		case strings.HasPrefix(query[i:], "--"):
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				return query[:end]
+			}
+			i += j
+		case strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				return query[:end]
+			}
+			i += j + 3
+		case strings.IndexByte("; \t\r\n", query[i]) >= 0:
+		default:
+			end = i + 1
+		}
+	}
+	return query[:end]
+}
+
+// hasMultipleStatements reports whether a query has a statement after a semicolon.
+// Semicolons in string literals, quoted identifiers and comments are ignored,
+// and so are the ones followed only by comments.
+func hasMultipleStatements(query string) bool {
+	for i := 0; i < len(query); i++ {
+		switch query[i] {
+		case '\'', '"':
+			// '' and "" are escaped quotes, which are read as two literals.
+			j := strings.IndexByte(query[i+1:], query[i])
+			if j < 0 {
+				return false
+			}
+			i += j + 1
+		case '-':
+			if strings.HasPrefix(query[i:], "--") {
+				j := strings.IndexByte(query[i:], '\n')
+				if j < 0 {
+					return false
+				}
+				i += j
+			}
+		case '/':
+			if strings.HasPrefix(query[i:], "/*") {
+				j := strings.Index(query[i+2:], "*/")
+				if j < 0 {
+					return false
+				}
+				i += j + 3
+			}
+		case ';':
+			if strings.TrimRight(stripLeadingComments(query[i+1:]), "; \t\r\n") != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stripLeadingComments removes the whitespace, line comments and block comments before
+// the first keyword of a query, e.g. the trace IDs prepended by ORMs and dashboards,
+// so that the query is classified by the keyword.
+func stripLeadingComments(query string) string {
+	for {
+		query = strings.TrimLeftFunc(query, unicode.IsSpace)
+		switch {
+		case strings.HasPrefix(query, "--"):
+			i := strings.IndexByte(query, '\n')
+			if i < 0 {
+				return ""
+			}
+			query = query[i+1:]
+		case strings.HasPrefix(query, "/*"):
+			i := strings.Index(query[2:], "*/")
+			if i < 0 {
+				return ""
+			}
+			query = query[i+4:]
+		default:
+			return query
+		}
+	}
+}
+
 // supported DDL statements by Athena
 // https://docs.aws.amazon.com/athena/latest/ug/language-reference.html
 var ddlQueryRegex = regexp.MustCompile(`(?i)^(ALTER|CREATE|DESCRIBE|DROP|MSCK|SHOW)`)
 
 func isDDLQuery(query string) bool {
-	return ddlQueryRegex.Match([]byte(query))
+	return ddlQueryRegex.MatchString(stripLeadingComments(query))
 }
 
+// selectQueryRegex matches SELECT queries, including the ones starting with a common table expression.
+var selectQueryRegex = regexp.MustCompile(`(?is)^(SELECT|WITH\s.+\bSELECT\b)`)
+
 func isSelectQuery(query string) bool {
-	return regexp.MustCompile(`(?i)^SELECT`).Match([]byte(query))
+	return selectQueryRegex.MatchString(stripLeadingComments(query))
+}
+
+// limitClauseRegex matches a LIMIT or FETCH clause at the end of a query, whose count can be
+// a `?` placeholder of the execution parameters.
+var limitClauseRegex = regexp.MustCompile(`(?is)\b(LIMIT\s+(\d+|ALL|\?)|FETCH\s+(FIRST|NEXT)\s+.*\s+ONLY|WITH\s+TIES)$`)
+
+// addLimit appends `LIMIT n` to a query which doesn't end with a LIMIT or FETCH clause.
+// Trailing semicolons and comments are stripped first, so that the clause is found before them
+// and the added one isn't commented out or put after a semicolon.
+func addLimit(query string, n int) string {
+	query = stripTrailingComments(query)
+	if limitClauseRegex.MatchString(query) {
+		return query
+	}
+	return fmt.Sprintf("%s\nLIMIT %d", query, n)
 }
 
 func isCTASQuery(query string) bool {
-	return regexp.MustCompile(`(?i)^CREATE.+AS\s+SELECT`).Match([]byte(query))
+	return regexp.MustCompile(`(?is)^CREATE.+AS\s+(SELECT|WITH|\()`).MatchString(stripLeadingComments(query))
+}
+
+// dmlQueryRegex matches the statements writing data.
+var dmlQueryRegex = regexp.MustCompile(`(?i)^(INSERT|UPDATE|DELETE|MERGE)\s`)
+
+// unloadQueryRegex matches `UNLOAD (SELECT ...) TO 's3://...' WITH (...)`.
+var unloadQueryRegex = regexp.MustCompile(`(?i)^UNLOAD\s*\(`)
+
+// queryType is the kind of statement of a query.
+type queryType int
+
+const (
+	queryTypeUnknown queryType = iota
+	queryTypeDDL
+	queryTypeSelect
+	queryTypeCTAS
+	queryTypeDML
+	queryTypeUnload
+)
+
+// getQueryType classifies a query. CTAS is distinguished from the other DDL statements.
+func getQueryType(query string) queryType {
+	query = stripLeadingComments(query)
+	switch {
+	case isCTASQuery(query):
+		return queryTypeCTAS
+	case isDDLQuery(query):
+		return queryTypeDDL
+	case isSelectQuery(query):
+		return queryTypeSelect
+	case dmlQueryRegex.MatchString(query):
+		return queryTypeDML
+	case unloadQueryRegex.MatchString(query):
+		return queryTypeUnload
+	}
+	return queryTypeUnknown
 }
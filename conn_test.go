@@ -0,0 +1,1184 @@
+package athena
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn_StrictResultMode(t *testing.T) {
+	c := &conn{
+		athena:     new(mockAthenaClient),
+		resultMode: ResultModeAPI,
+		strictMode: true,
+	}
+
+	_, err := c.runQuery(SetGzipDLMode(context.Background()), "SHOW TABLES")
+	assert.Equal(t, ErrResultModeMismatch, err)
+
+	_, err = c.runQuery(SetDLMode(context.Background()), "DROP TABLE foo")
+	assert.Equal(t, ErrResultModeMismatch, err)
+}
+
+type mockAthenaConnClient struct {
+	mockAthenaClient
+
+	queryID    string
+	state      string // defaults to SUCCEEDED
+	pending    int    // number of polls reporting RUNNING before state
+	location   string // output location of the result
+	query      string
+	reason     string
+	statistics *athena.QueryExecutionStatistics
+	started    []*athena.StartQueryExecutionInput
+	stopped    []string
+	workGroup  *athena.WorkGroup
+	throttled  int // number of StartQueryExecution calls throttled before it succeeds
+	// submitted and completed are SubmissionDateTime and CompletionDateTime, which are unset if they're zero.
+	submitted, completed time.Time
+	// tableColumns are the columns of the CTAS table, returned by GetTableMetadata.
+	tableColumns []*athena.Column
+	described    []*athena.GetTableMetadataInput
+}
+
+func (m *mockAthenaConnClient) GetTableMetadata(input *athena.GetTableMetadataInput) (*athena.GetTableMetadataOutput, error) {
+	m.described = append(m.described, input)
+	return &athena.GetTableMetadataOutput{
+		TableMetadata: &athena.TableMetadata{Columns: m.tableColumns},
+	}, nil
+}
+
+func (m *mockAthenaConnClient) GetWorkGroupWithContext(_ aws.Context, _ *athena.GetWorkGroupInput, _ ...request.Option) (*athena.GetWorkGroupOutput, error) {
+	if m.workGroup == nil {
+		return nil, errors.New("workgroup not found")
+	}
+	return &athena.GetWorkGroupOutput{WorkGroup: m.workGroup}, nil
+}
+
+func (m *mockAthenaConnClient) GetWorkGroup(input *athena.GetWorkGroupInput) (*athena.GetWorkGroupOutput, error) {
+	return m.GetWorkGroupWithContext(context.Background(), input)
+}
+
+func (m *mockAthenaConnClient) StartQueryExecution(input *athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	if m.throttled > 0 {
+		m.throttled--
+		return nil, awserr.New("ThrottlingException", "Rate exceeded", nil)
+	}
+	m.started = append(m.started, input)
+	return &athena.StartQueryExecutionOutput{
+		QueryExecutionId: aws.String(m.queryID),
+	}, nil
+}
+
+func (m *mockAthenaConnClient) GetQueryExecutionWithContext(_ aws.Context, input *athena.GetQueryExecutionInput, _ ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	state := m.state
+	if state == "" {
+		state = athena.QueryExecutionStateSucceeded
+	}
+	if m.pending > 0 {
+		m.pending--
+		state = athena.QueryExecutionStateRunning
+	}
+	status := &athena.QueryExecutionStatus{
+		State:             aws.String(state),
+		StateChangeReason: aws.String(m.reason),
+	}
+	if !m.submitted.IsZero() {
+		status.SubmissionDateTime = aws.Time(m.submitted)
+	}
+	if !m.completed.IsZero() {
+		status.CompletionDateTime = aws.Time(m.completed)
+	}
+	return &athena.GetQueryExecutionOutput{
+		QueryExecution: &athena.QueryExecution{
+			QueryExecutionId:    input.QueryExecutionId,
+			Query:               aws.String(m.query),
+			Status:              status,
+			Statistics:          m.statistics,
+			ResultConfiguration: &athena.ResultConfiguration{OutputLocation: aws.String(m.location)},
+		},
+	}, nil
+}
+
+func (m *mockAthenaConnClient) StopQueryExecution(input *athena.StopQueryExecutionInput) (*athena.StopQueryExecutionOutput, error) {
+	m.stopped = append(m.stopped, *input.QueryExecutionId)
+	return &athena.StopQueryExecutionOutput{}, nil
+}
+
+func TestConn_waitOnQueryCancel(t *testing.T) {
+	client := &mockAthenaConnClient{state: athena.QueryExecutionStateRunning}
+	c := &conn{athena: client, pollFrequency: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.waitOnQuery(ctx, "running")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, []string{"running"}, client.stopped)
+}
+
+func TestConn_waitOnQueryMaxQueueTime(t *testing.T) {
+	client := &mockAthenaConnClient{state: athena.QueryExecutionStateQueued}
+	c := &conn{athena: client, pollFrequency: time.Hour, maxQueueTime: 10 * time.Millisecond}
+
+	start := time.Now()
+	_, err := c.waitOnQuery(context.Background(), "queued")
+	assert.True(t, errors.Is(err, ErrQueueTimeout))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, []string{"queued"}, client.stopped)
+
+	// running queries aren't limited by it.
+	client = &mockAthenaConnClient{state: athena.QueryExecutionStateRunning}
+	c = &conn{athena: client, pollFrequency: 5 * time.Millisecond, maxQueueTime: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	_, err = c.waitOnQuery(ctx, "running")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestConn_QueryStatsReceiver(t *testing.T) {
+	submitted := time.Date(2024, 1, 15, 1, 2, 3, 0, time.UTC)
+	c := &conn{
+		athena: &mockAthenaConnClient{
+			queryID: "show",
+			statistics: &athena.QueryExecutionStatistics{
+				DataScannedInBytes:   aws.Int64(1024),
+				DataManifestLocation: aws.String("s3://bucket/show-manifest.csv"),
+			},
+			submitted: submitted,
+			completed: submitted.Add(3 * time.Second),
+		},
+	}
+
+	var stats QueryStats
+	ctx := SetQueryStatsReceiver(context.Background(), &stats)
+	_, err := c.runQuery(ctx, "INSERT INTO foo SELECT * FROM bar")
+	require.NoError(t, err)
+
+	assert.Equal(t, "show", stats.QueryID)
+	assert.Equal(t, int64(1024), stats.DataScannedInBytes)
+	assert.Equal(t, "s3://bucket/show-manifest.csv", stats.DataManifestLocation)
+	assert.Equal(t, 1, stats.PollCount)
+	assert.Equal(t, submitted, stats.SubmittedAt)
+	assert.Equal(t, 3*time.Second, stats.CompletedAt.Sub(stats.SubmittedAt))
+}
+
+func TestConn_QueryStatsPolls(t *testing.T) {
+	c := &conn{
+		athena:        &mockAthenaConnClient{queryID: "show", pending: 2},
+		pollFrequency: 5 * time.Millisecond,
+	}
+
+	var stats QueryStats
+	ctx := SetQueryStatsReceiver(context.Background(), &stats)
+	_, err := c.runQuery(ctx, "SHOW TABLES")
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, stats.PollCount)
+	assert.GreaterOrEqual(t, int64(stats.WaitTime), int64(10*time.Millisecond))
+}
+
+func Test_normalizeQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT 1;", "SELECT 1"},
+		{"  SELECT 1 ; \n", "SELECT 1"},
+		{"SELECT 1;;\n;", "SELECT 1"},
+		{"\n\tSELECT ';'", "SELECT ';'"},
+		{"SELECT * FROM t; -- done", "SELECT * FROM t"},
+		{"SELECT * FROM t -- done\n;\n/* end */", "SELECT * FROM t"},
+		{"SELECT '--;' /* a */ FROM t /* b */;", "SELECT '--;' /* a */ FROM t"},
+		{"SELECT \"/*\" FROM t -- x", "SELECT \"/*\" FROM t"},
+		{"SELECT 'unterminated; -- x", "SELECT 'unterminated; -- x"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, normalizeQuery(test.query), test.query)
+	}
+}
+
+func Test_getQueryType(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected queryType
+	}{
+		{"SELECT * FROM foo", queryTypeSelect},
+		{"SHOW TABLES", queryTypeDDL},
+		{"CREATE TABLE foo AS SELECT * FROM bar", queryTypeCTAS},
+		{"INSERT INTO foo SELECT * FROM bar", queryTypeDML},
+		{"INSERT INTO foo VALUES (1, 'a')", queryTypeDML},
+		{"  insert into foo\nSELECT * FROM bar", queryTypeDML},
+		{"\tInSeRt InTo foo VALUES (1)", queryTypeDML},
+		{"UPDATE foo SET a = 1", queryTypeDML},
+		{"DELETE FROM foo WHERE a = 1", queryTypeDML},
+		{"MERGE INTO foo USING bar ON foo.id = bar.id WHEN MATCHED THEN DELETE", queryTypeDML},
+		{"UNLOAD (SELECT * FROM foo) TO 's3://bucket/unload/' WITH (format='PARQUET')", queryTypeUnload},
+		{"unload(SELECT 1) TO 's3://bucket/unload/'", queryTypeUnload},
+		{"WITH t AS (SELECT 1) SELECT * FROM t", queryTypeSelect},
+		{"with t as (\n  select 1\n)\nselect * from t", queryTypeSelect},
+		{"CREATE TABLE foo AS WITH t AS (SELECT 1) SELECT * FROM t", queryTypeCTAS},
+		{"CREATE TABLE foo WITH (format='PARQUET') AS\nSELECT 1", queryTypeCTAS},
+		{"CREATE TABLE foo (id int)", queryTypeDDL},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getQueryType(test.query), test.query)
+	}
+}
+
+func TestConn_DMLInAPIMode(t *testing.T) {
+	for _, mode := range []ResultMode{ResultModeDL, ResultModeGzipDL} {
+		client := &mockAthenaConnClient{queryID: "show"}
+		c := &conn{athena: client, resultMode: mode, downloadNonSelect: true}
+
+		for _, query := range []string{
+			"INSERT INTO foo SELECT * FROM bar",
+			"UNLOAD (SELECT * FROM bar) TO 's3://bucket/unload/' WITH (format='PARQUET')",
+		} {
+			client.started = nil
+			rows, err := c.runQuery(context.Background(), query)
+			require.NoError(t, err)
+			assert.IsType(t, &rowsAPI{}, rows, "mode: %d", mode)
+			require.Len(t, client.started, 1)
+			assert.Equal(t, query, *client.started[0].QueryString, "it should not be run by CTAS")
+		}
+	}
+}
+
+func Test_resolveOutputLocation(t *testing.T) {
+	at := time.Date(2024, 1, 15, 23, 4, 5, 0, time.FixedZone("JST", 9*60*60))
+	assert.Equal(t, "s3://bucket/results/2024/01/15/14/", resolveOutputLocation("s3://bucket/results/{yyyy}/{mm}/{dd}/{hh}/", at))
+	assert.Equal(t, "s3://bucket/results/", resolveOutputLocation("s3://bucket/results/", at))
+}
+
+func TestConn_TemplatedOutputLocation(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "show"}
+	c := &conn{athena: client, OutputLocation: "s3://bucket/{yyyy}-{mm}-{dd}/"}
+
+	before := time.Now().UTC().Format("2006-01-02")
+	_, err := c.runQuery(context.Background(), "SHOW TABLES")
+	require.NoError(t, err)
+	after := time.Now().UTC().Format("2006-01-02")
+
+	location := *client.started[0].ResultConfiguration.OutputLocation
+	assert.Contains(t, []string{"s3://bucket/" + before + "/", "s3://bucket/" + after + "/"}, location)
+}
+
+func Test_hasMultipleStatements(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"SELECT 1", false},
+		{"SELECT 1; SELECT 2", true},
+		{"SELECT 1;\nSELECT 2;", true},
+		{"SELECT 1; -- done", false},
+		{"SELECT 1; /* done */ ;", false},
+		{"SELECT ';' AS a, 'it''s; ok' AS b", false},
+		{`SELECT 1 AS "a;b"`, false},
+		{"SELECT 1 -- a; b\nFROM foo", false},
+		{"SELECT /* ; */ 1", false},
+		{"SELECT 'a'; DROP TABLE foo", true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, hasMultipleStatements(test.query), test.query)
+	}
+}
+
+func TestConn_MultipleStatements(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "select"}
+	c := &conn{athena: client}
+
+	_, err := c.runQuery(context.Background(), "SELECT 1; SELECT 2;")
+	assert.Equal(t, ErrMultipleStatements, err)
+	assert.Empty(t, client.started)
+
+	_, err = c.runQuery(context.Background(), "SELECT 1;")
+	require.NoError(t, err)
+}
+
+func Test_stripLeadingComments(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"\n\t SELECT 1", "SELECT 1"},
+		{"-- trace_id: abc\nSELECT 1", "SELECT 1"},
+		{"/* dashboard: sales, user: 42 */ SELECT 1", "SELECT 1"},
+		{"/* multi\nline */\n-- a\n  -- b\n/**/SELECT 1 -- tail", "SELECT 1 -- tail"},
+		{"-- only a comment", ""},
+		{"/* unterminated", ""},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, stripLeadingComments(test.query), test.query)
+	}
+}
+
+func Test_getQueryTypeWithComments(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected queryType
+	}{
+		{"-- Metabase:: userID: 1 queryType: native queryHash: 0a1b\nSELECT count(*) FROM foo", queryTypeSelect},
+		{"/* {\"app\": \"superset\", \"trace_id\": \"abc\"} */\nWITH t AS (SELECT 1) SELECT * FROM t", queryTypeSelect},
+		{"-- job: nightly\nSHOW TABLES", queryTypeDDL},
+		{"/* dbt */ CREATE TABLE foo AS SELECT 1", queryTypeCTAS},
+		{"-- etl\nINSERT INTO foo SELECT * FROM bar", queryTypeDML},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getQueryType(test.query), test.query)
+	}
+	assert.True(t, isSelectQuery("-- trace\nSELECT 1"))
+	assert.True(t, isDDLQuery("/* trace */ SHOW TABLES"))
+}
+
+func Test_addLimit(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT * FROM foo", "SELECT * FROM foo\nLIMIT 100"},
+		{"SELECT * FROM foo -- all", "SELECT * FROM foo\nLIMIT 100"},
+		{"SELECT * FROM foo LIMIT 5 -- note", "SELECT * FROM foo LIMIT 5"},
+		{"SELECT 1; -- c", "SELECT 1\nLIMIT 100"},
+		{"SELECT * FROM foo LIMIT 5 /* note */;", "SELECT * FROM foo LIMIT 5"},
+		{"SELECT * FROM (SELECT * FROM foo LIMIT 10) t", "SELECT * FROM (SELECT * FROM foo LIMIT 10) t\nLIMIT 100"},
+		{"SELECT * FROM foo limit 10", "SELECT * FROM foo limit 10"},
+		{"SELECT * FROM foo LIMIT ALL", "SELECT * FROM foo LIMIT ALL"},
+		{"SELECT * FROM foo WHERE id = ? LIMIT ?", "SELECT * FROM foo WHERE id = ? LIMIT ?"},
+		{"SELECT * FROM foo WHERE id = ?", "SELECT * FROM foo WHERE id = ?\nLIMIT 100"},
+		{"SELECT * FROM foo ORDER BY id FETCH FIRST ? ROWS ONLY", "SELECT * FROM foo ORDER BY id FETCH FIRST ? ROWS ONLY"},
+		{"SELECT * FROM foo ORDER BY id\nFETCH FIRST 10 ROWS ONLY", "SELECT * FROM foo ORDER BY id\nFETCH FIRST 10 ROWS ONLY"},
+		{"SELECT * FROM foo ORDER BY id FETCH NEXT 1 ROW WITH TIES", "SELECT * FROM foo ORDER BY id FETCH NEXT 1 ROW WITH TIES"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, addLimit(test.query, 100), test.query)
+	}
+}
+
+func TestConn_AutoLimit(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "show"}
+	c := &conn{athena: client, autoLimit: 100}
+
+	_, err := c.runQuery(context.Background(), "SELECT * FROM foo;")
+	require.NoError(t, err)
+	_, err = c.runQuery(context.Background(), "SHOW TABLES")
+	require.NoError(t, err)
+	_, err = c.runQuery(withExecutionParameters(context.Background(), []string{"10"}), "SELECT * FROM foo LIMIT ?")
+	require.NoError(t, err)
+
+	require.Len(t, client.started, 3)
+	assert.Equal(t, "SELECT * FROM foo\nLIMIT 100", *client.started[0].QueryString)
+	assert.Equal(t, "SHOW TABLES", *client.started[1].QueryString)
+	assert.Equal(t, "SELECT * FROM foo LIMIT ?", *client.started[2].QueryString)
+	assert.Equal(t, []*string{aws.String("10")}, client.started[2].ExecutionParameters)
+}
+
+func TestConn_NormalizeQueryBeforeSubmit(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "show"}
+	c := &conn{athena: client}
+
+	_, err := c.runQuery(context.Background(), "SHOW TABLES;\n")
+	require.NoError(t, err)
+	require.Len(t, client.started, 1)
+	assert.Equal(t, "SHOW TABLES", *client.started[0].QueryString)
+}
+
+func TestConn_QueryRewriter(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "show"}
+	c := &conn{
+		athena: client,
+		queryRewriter: func(_ context.Context, query string) (string, error) {
+			if strings.Contains(query, "forbidden") {
+				return "", errors.New("forbidden table")
+			}
+			return strings.Replace(query, "{env}", "prod", -1) + ";", nil
+		},
+	}
+
+	_, err := c.runQuery(context.Background(), "SHOW TABLES IN db_{env};")
+	require.NoError(t, err)
+	require.Len(t, client.started, 1)
+	assert.Equal(t, "SHOW TABLES IN db_prod", *client.started[0].QueryString)
+
+	_, err = c.runQuery(context.Background(), "SELECT * FROM forbidden")
+	assert.EqualError(t, err, "forbidden table")
+	assert.Len(t, client.started, 1)
+}
+
+func TestConn_BytesScannedCutoffExceeded(t *testing.T) {
+	client := &mockAthenaConnClient{
+		state:  athena.QueryExecutionStateCancelled,
+		reason: "Query cancelled! : Bytes scanned limit was exceeded",
+		workGroup: &athena.WorkGroup{
+			Configuration: &athena.WorkGroupConfiguration{
+				BytesScannedCutoffPerQuery: aws.Int64(10000000),
+			},
+		},
+	}
+	c := &conn{athena: client, workgroup: "primary"}
+
+	_, err := c.waitOnQuery(context.Background(), "q")
+	assert.True(t, errors.Is(err, ErrBytesScannedCutoffExceeded))
+	var cutoffErr *BytesScannedCutoffExceededError
+	require.True(t, errors.As(err, &cutoffErr))
+	assert.Equal(t, int64(10000000), cutoffErr.Cutoff)
+	assert.Equal(t, "q", cutoffErr.QueryID)
+
+	client.reason = "Query cancelled by user"
+	_, err = c.waitOnQuery(context.Background(), "q")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestConn_DownloadNonSelect(t *testing.T) {
+	s3Client := &mockS3Client{objects: map[string][]byte{
+		"bucket/show.csv": []byte("a\nc\n"),
+	}}
+	c := &conn{
+		athena:            &mockAthenaConnClient{queryID: "show"},
+		s3:                s3Client,
+		OutputLocation:    "s3://bucket",
+		resultMode:        ResultModeDL,
+		timeout:           10 * time.Second,
+		downloadNonSelect: true,
+	}
+
+	rows, err := c.runQuery(context.Background(), "SHOW TABLES")
+	require.NoError(t, err)
+	assert.IsType(t, &rowsDL{}, rows)
+	assert.Len(t, readAllRows(t, rows), 2)
+
+	rows, err = c.runQuery(SetGzipDLMode(context.Background()), "SHOW TABLES")
+	require.NoError(t, err)
+	assert.IsType(t, &rowsAPI{}, rows)
+
+	c.downloadNonSelect = false
+	rows, err = c.runQuery(context.Background(), "SHOW TABLES")
+	require.NoError(t, err)
+	assert.IsType(t, &rowsAPI{}, rows)
+}
+
+func TestConn_ResultReuseMaxAge(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "select"}
+	var queries []string
+	c := &conn{
+		athena: client,
+		resultReuseMaxAge: func(_ context.Context, query string) time.Duration {
+			queries = append(queries, query)
+			if strings.Contains(query, "dashboard") {
+				return 30 * time.Second
+			}
+			return 0
+		},
+	}
+
+	_, err := c.runQuery(context.Background(), "SELECT * FROM dashboard;")
+	require.NoError(t, err)
+	_, err = c.runQuery(context.Background(), "SELECT * FROM report")
+	require.NoError(t, err)
+	_, err = c.runQuery(context.Background(), "SHOW TABLES")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"SELECT * FROM dashboard", "SELECT * FROM report"}, queries)
+	require.Len(t, client.started, 3)
+	reuse := client.started[0].ResultReuseConfiguration.ResultReuseByAgeConfiguration
+	assert.True(t, *reuse.Enabled)
+	assert.Equal(t, int64(1), *reuse.MaxAgeInMinutes)
+	assert.Nil(t, client.started[1].ResultReuseConfiguration)
+	assert.Nil(t, client.started[2].ResultReuseConfiguration)
+}
+
+func TestConn_ForceFreshResults(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "select"}
+	c := &conn{
+		athena: client,
+		resultReuseMaxAge: func(context.Context, string) time.Duration {
+			return time.Hour
+		},
+	}
+
+	_, err := c.runQuery(SetForceFreshResults(context.Background(), true), "SELECT * FROM dashboard")
+	require.NoError(t, err)
+	_, err = c.runQuery(SetForceFreshResults(context.Background(), false), "SELECT * FROM dashboard")
+	require.NoError(t, err)
+
+	require.Len(t, client.started, 2)
+	assert.Nil(t, client.started[0].ResultReuseConfiguration)
+	assert.NotNil(t, client.started[1].ResultReuseConfiguration)
+}
+
+func TestConn_ResultObjectReceiver(t *testing.T) {
+	c := &conn{
+		athena: &mockAthenaConnClient{queryID: "select", location: "s3://bucket/select.csv"},
+		s3: &mockS3Client{
+			objects: map[string][]byte{"bucket/select.csv": []byte("a,b\n")},
+			etags:   map[string]string{"bucket/select.csv": "etag"},
+		},
+	}
+
+	var obj ResultObject
+	_, err := c.runQuery(SetResultObjectReceiver(context.Background(), &obj), "SELECT * FROM foo")
+	require.NoError(t, err)
+	assert.Equal(t, ResultObject{
+		Location:      "s3://bucket/select.csv",
+		LastModified:  time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		ContentLength: 4,
+		ETag:          "etag",
+	}, obj)
+}
+
+func TestConn_ResumeQuery(t *testing.T) {
+	client := &mockAthenaConnClient{query: "SELECT first_name, last_name FROM foo"}
+	c := &conn{
+		athena:         client,
+		s3:             &mockS3Client{objects: map[string][]byte{"bucket/select.csv": []byte("first_name,last_name\na,b\n")}},
+		OutputLocation: "s3://bucket",
+		resultMode:     ResultModeGzipDL,
+		timeout:        10 * time.Second,
+	}
+
+	rows, err := c.runQuery(SetQueryExecutionID(context.Background(), "select"), "")
+	require.NoError(t, err)
+	assert.Empty(t, client.started)
+	// the query wasn't run by CTAS, so it's read by DL mode.
+	assert.IsType(t, &rowsDL{}, rows)
+	assert.Len(t, readAllRows(t, rows), 1)
+}
+
+func TestConn_ResumeQueryAPI(t *testing.T) {
+	client := &mockAthenaConnClient{query: "SELECT first_name, last_name FROM foo"}
+	c := &conn{athena: client}
+
+	rows, err := c.runQuery(SetQueryExecutionID(context.Background(), "select"), "")
+	require.NoError(t, err)
+	assert.Empty(t, client.started)
+	assert.Len(t, readAllRows(t, rows), 9)
+}
+
+func Test_ctasQueryRegex(t *testing.T) {
+	query := "CREATE TABLE tmp_ctas_0123abcd WITH (format='TEXTFILE') AS SELECT * FROM foo"
+	assert.Equal(t, []string{"CREATE TABLE tmp_ctas_0123abcd WITH (format='TEXTFILE') AS ", "", "tmp_ctas_0123abcd", "TEXTFILE"}, ctasQueryRegex.FindStringSubmatch(query))
+	query = "CREATE TABLE tmp_ctas_0123abcd WITH (format='PARQUET', write_compression='SNAPPY') AS SELECT * FROM foo"
+	assert.Equal(t, []string{"CREATE TABLE tmp_ctas_0123abcd WITH (format='PARQUET', write_compression='SNAPPY') AS ", "", "tmp_ctas_0123abcd", "PARQUET"}, ctasQueryRegex.FindStringSubmatch(query))
+	query = "CREATE TABLE scratch.tmp_ctas_0123abcd WITH (format='TEXTFILE') AS SELECT * FROM foo"
+	assert.Equal(t, []string{"CREATE TABLE scratch.tmp_ctas_0123abcd WITH (format='TEXTFILE') AS ", "scratch", "tmp_ctas_0123abcd", "TEXTFILE"}, ctasQueryRegex.FindStringSubmatch(query))
+	assert.Nil(t, ctasQueryRegex.FindStringSubmatch("CREATE TABLE foo AS SELECT 1"))
+}
+
+func TestConn_ReadOnly(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "show"}
+	c := &conn{athena: client, readOnly: true}
+
+	for _, query := range []string{
+		"INSERT INTO foo SELECT * FROM bar",
+		"CREATE TABLE foo AS SELECT 1",
+		"DROP TABLE foo",
+		"EXPLAIN ANALYZE INSERT INTO foo SELECT 1",
+		"MSCK REPAIR TABLE foo",
+	} {
+		_, err := c.runQuery(context.Background(), query)
+		assert.Equal(t, ErrReadOnly, err, query)
+	}
+	assert.Empty(t, client.started)
+
+	for _, query := range []string{
+		"SELECT 1",
+		"with t AS (SELECT 1) SELECT * FROM t",
+		"SHOW TABLES",
+		"DESCRIBE foo",
+		"EXPLAIN (TYPE DISTRIBUTED) SELECT 1",
+	} {
+		_, err := c.runQuery(context.Background(), query)
+		assert.NoError(t, err, query)
+	}
+}
+
+func TestConn_waitOnQueryTimeout(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "show", state: athena.QueryExecutionStateRunning}
+	c := &conn{athena: client, pollFrequency: time.Millisecond, timeout: 20 * time.Millisecond}
+
+	_, err := c.runQuery(context.Background(), "SELECT 1")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Len(t, client.stopped, 1)
+
+	// the timeout in context overrides the config
+	client.pending, client.state = 5, ""
+	_, err = c.runQuery(SetQueryTimeout(context.Background(), time.Minute), "SHOW TABLES")
+	assert.NoError(t, err)
+
+	// waiting isn't limited without a timeout
+	client.pending = 30
+	c.timeout = 0
+	_, err = c.runQuery(context.Background(), "SHOW TABLES")
+	assert.NoError(t, err)
+	assert.Len(t, client.stopped, 1)
+}
+
+func TestConn_warnLargeScan(t *testing.T) {
+	type logged struct {
+		level   LogLevel
+		keyvals []interface{}
+	}
+	tests := []struct {
+		name    string
+		scanned int64
+		want    bool
+	}{
+		{name: "within ratio", scanned: 40, want: false},
+		{name: "over ratio", scanned: 41, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs []logged
+			c := &conn{
+				athena: &mockAthenaConnClient{
+					queryID:    "select",
+					location:   "s3://bucket/select.csv",
+					statistics: &athena.QueryExecutionStatistics{DataScannedInBytes: aws.Int64(tt.scanned)},
+				},
+				s3: &mockS3Client{objects: map[string][]byte{"bucket/select.csv": []byte("a,b\n")}},
+				logger: func(_ context.Context, level LogLevel, _ string, keyvals ...interface{}) {
+					logs = append(logs, logged{level, keyvals})
+				},
+				scanWarningRatio: 10,
+			}
+
+			_, err := c.runQuery(context.Background(), "SELECT * FROM foo")
+			require.NoError(t, err)
+			if !tt.want {
+				assert.Empty(t, logs)
+				return
+			}
+			require.Len(t, logs, 1)
+			assert.Equal(t, LogLevelWarn, logs[0].level)
+			assert.Equal(t, []interface{}{"query_id", "select", "data_scanned_bytes", tt.scanned, "result_bytes", int64(4)}, logs[0].keyvals)
+		})
+	}
+}
+
+func TestConn_ColumnOrderAcrossResultModes(t *testing.T) {
+	// the projection isn't in the order of the table, nor in the alphabetical one.
+	query := "SELECT last_name, first_name FROM foo"
+	columns := []*athena.ColumnInfo{genColumnInfo("last_name"), genColumnInfo("first_name")}
+	queryToResultsGenMap["projection"] = func(string) (*athena.GetQueryResultsOutput, error) {
+		return &athena.GetQueryResultsOutput{ResultSet: &athena.ResultSet{
+			ResultSetMetadata: &athena.ResultSetMetadata{ColumnInfo: columns},
+			Rows: []*athena.Row{
+				genRow(true, columns),
+				{Data: []*athena.Datum{{VarCharValue: aws.String("b")}, {VarCharValue: aws.String("a")}}},
+			},
+		}}, nil
+	}
+	defer delete(queryToResultsGenMap, "projection")
+
+	newConn := func(mode ResultMode, location string, tableColumns []*athena.Column, object [][]string) *conn {
+		return &conn{
+			athena: &mockAthenaConnClient{queryID: "projection", location: location, tableColumns: tableColumns},
+			s3: &mockS3Client{objects: map[string][]byte{
+				"bucket/projection.csv":                 []byte("last_name,first_name\nb,a\n"),
+				"bucket/tables/projection-manifest.csv": []byte("s3://bucket/tables/projection/00000.gz\n"),
+				"bucket/tables/projection/00000.gz":     genGzipObject(t, object),
+			}},
+			OutputLocation: "s3://bucket",
+			resultMode:     mode,
+			timeout:        10 * time.Second,
+		}
+	}
+	// Athena creates the CTAS table with the columns in the order of the projection.
+	projected := []*athena.Column{genTableColumn("last_name", "string"), genTableColumn("first_name", "string")}
+	for _, tt := range []struct {
+		mode     ResultMode
+		location string
+	}{
+		{mode: ResultModeAPI, location: "s3://bucket/projection.csv"},
+		{mode: ResultModeDL, location: "s3://bucket/projection.csv"},
+		{mode: ResultModeGzipDL, location: "s3://bucket/tables/projection"},
+	} {
+		rows, err := newConn(tt.mode, tt.location, projected, [][]string{{"b", "a"}}).runQuery(context.Background(), query)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"last_name", "first_name"}, rows.Columns(), "mode: %d", tt.mode)
+		assert.Equal(t, [][]driver.Value{{"b", "a"}}, readAllRows(t, rows), "mode: %d", tt.mode)
+		require.NoError(t, rows.Close())
+	}
+
+	// GZIP DL mode doesn't parse the projection but follows the metadata of the table,
+	// which the fields of its objects are in, so the values stay with their columns.
+	table := []*athena.Column{genTableColumn("first_name", "string"), genTableColumn("last_name", "string")}
+	rows, err := newConn(ResultModeGzipDL, "s3://bucket/tables/projection", table, [][]string{{"a", "b"}}).runQuery(context.Background(), query)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first_name", "last_name"}, rows.Columns())
+	assert.Equal(t, [][]driver.Value{{"a", "b"}}, readAllRows(t, rows))
+	require.NoError(t, rows.Close())
+}
+
+func TestConn_LogRewrittenQuery(t *testing.T) {
+	type logged struct {
+		msg     string
+		keyvals []interface{}
+	}
+	var logs []logged
+	c := &conn{
+		athena: &mockAthenaConnClient{
+			queryID:      "select",
+			location:     "s3://bucket/tables/select",
+			tableColumns: []*athena.Column{genTableColumn("first_name", "string")},
+		},
+		s3: &mockS3Client{objects: map[string][]byte{
+			"bucket/tables/select-manifest.csv": []byte("s3://bucket/tables/select/00000.gz\n"),
+			"bucket/tables/select/00000.gz":     genGzipObject(t, [][]string{{"a"}}),
+		}},
+		OutputLocation: "s3://bucket",
+		resultMode:     ResultModeGzipDL,
+		timeout:        10 * time.Second,
+		logger: func(_ context.Context, level LogLevel, msg string, keyvals ...interface{}) {
+			assert.Equal(t, LogLevelDebug, level)
+			logs = append(logs, logged{msg, keyvals})
+		},
+	}
+
+	rows, err := c.runQuery(context.Background(), "SELECT first_name FROM foo")
+	require.NoError(t, err)
+	readAllRows(t, rows)
+
+	require.Len(t, logs, 2)
+	table := logs[0].keyvals[1].(string)
+	assert.True(t, strings.HasPrefix(table, "tmp_ctas_"))
+	assert.Equal(t, []interface{}{"table", table, "query", "CREATE TABLE " + table + " WITH (format='TEXTFILE') AS SELECT first_name FROM foo"}, logs[0].keyvals)
+	assert.Equal(t, []interface{}{"table", table, "query", "DROP TABLE IF EXISTS " + table}, logs[1].keyvals)
+}
+
+func TestConn_LogsTruncatedValues(t *testing.T) {
+	var logs [][]interface{}
+	c := &conn{
+		athena:     &mockAthenaConnClient{queryID: "mixed"},
+		resultMode: ResultModeAPI,
+		converter:  newConverter(&Config{TruncatedValueLength: 20, UnconvertibleValueMode: UnconvertibleValueModeNil}),
+		logger: func(_ context.Context, level LogLevel, msg string, keyvals ...interface{}) {
+			assert.Equal(t, LogLevelWarn, level)
+			assert.Equal(t, "value may be truncated by GetQueryResults", msg)
+			logs = append(logs, keyvals)
+		},
+	}
+
+	rows, err := c.runQuery(context.Background(), "SELECT * FROM foo")
+	require.NoError(t, err)
+	values := readAllRows(t, rows)
+
+	// the value is returned as nil, but it isn't silently.
+	require.Len(t, values, 1)
+	assert.Nil(t, values[0][3])
+	require.Len(t, logs, 1)
+	assert.Equal(t, []interface{}{"query_id", "mixed", "column", "created_at", "bytes", 23}, logs[0])
+}
+
+func TestConn_CTASFormat(t *testing.T) {
+	newConn := func(object []byte) (*conn, *mockAthenaConnClient) {
+		client := &mockAthenaConnClient{
+			queryID:      "select",
+			location:     "s3://bucket/tables/select",
+			tableColumns: []*athena.Column{genTableColumn("id", "bigint")},
+		}
+		return &conn{
+			athena: client,
+			s3: &mockS3Client{objects: map[string][]byte{
+				"bucket/tables/select-manifest.csv": []byte("s3://bucket/tables/select/00000\n"),
+				"bucket/tables/select/00000":        object,
+			}},
+			OutputLocation: "s3://bucket",
+			resultMode:     ResultModeGzipDL,
+			timeout:        10 * time.Second,
+		}, client
+	}
+
+	// uncompressed TEXTFILE
+	c, client := newConn([]byte("1\n2\n"))
+	c.ctasCompression = "none"
+	rows, err := c.runQuery(context.Background(), "SELECT id FROM foo")
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(*client.started[0].QueryString, "WITH (format='TEXTFILE', write_compression='NONE') AS "))
+	assert.Equal(t, [][]driver.Value{{int64(1)}, {int64(2)}}, readAllRows(t, rows))
+
+	// PARQUET set in context
+	object := genParquetObject(t, []parquetTestColumn{
+		{name: "id", typ: parquetInt64, converted: -1, values: []interface{}{1, 2}},
+	}, parquetCodecGzip)
+	c, client = newConn(object)
+	rows, err = c.runQuery(SetCTASFormat(context.Background(), "parquet", "gzip"), "SELECT id FROM foo")
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(*client.started[0].QueryString, "WITH (format='PARQUET', write_compression='GZIP') AS "))
+	assert.Equal(t, [][]driver.Value{{int64(1)}, {int64(2)}}, readAllRows(t, rows))
+
+	// formats which the driver can't read
+	c, client = newConn(nil)
+	_, err = c.runQuery(SetCTASFormat(context.Background(), "ORC", ""), "SELECT id FROM foo")
+	assert.Error(t, err)
+	_, err = c.runQuery(SetCTASFormat(context.Background(), "TEXTFILE", "ZSTD"), "SELECT id FROM foo")
+	assert.Error(t, err)
+	assert.Empty(t, client.started)
+}
+
+func Test_newCTASFormat(t *testing.T) {
+	f, err := newCTASFormat("", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "format='TEXTFILE'", f.withClause())
+
+	f, err = newCTASFormat("Parquet", "snappy", "")
+	require.NoError(t, err)
+	assert.Equal(t, "format='PARQUET', write_compression='SNAPPY'", f.withClause())
+
+	f, err = newCTASFormat("textfile", "none", ",")
+	require.NoError(t, err)
+	assert.Equal(t, "format='TEXTFILE', write_compression='NONE', field_delimiter=','", f.withClause())
+
+	_, err = newCTASFormat("ORC", "", "")
+	assert.Error(t, err)
+	_, err = newCTASFormat("PARQUET", "", ",")
+	assert.Error(t, err)
+	for _, delim := range []string{"'", ")", "a", "\\", ",,", "\n"} {
+		_, err = newCTASFormat("TEXTFILE", "", delim)
+		assert.Error(t, err, "delimiter: %q", delim)
+	}
+}
+
+func TestConn_ExecRowsAffected(t *testing.T) {
+	queryToResultsGenMap["delete"] = func(string) (*athena.GetQueryResultsOutput, error) {
+		return &athena.GetQueryResultsOutput{
+			ResultSet:   &athena.ResultSet{ResultSetMetadata: &athena.ResultSetMetadata{}},
+			UpdateCount: aws.Int64(7),
+		}, nil
+	}
+	defer delete(queryToResultsGenMap, "delete")
+
+	db := openMockDB(t, &conn{athena: &mockAthenaConnClient{queryID: "delete"}})
+	res, err := db.ExecContext(context.Background(), "DELETE FROM foo WHERE id = 1")
+	require.NoError(t, err)
+	n, err := res.RowsAffected()
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), n)
+	_, err = res.LastInsertId()
+	assert.Error(t, err)
+
+	// the other statements affect no rows.
+	db = openMockDB(t, &conn{athena: &mockAthenaConnClient{queryID: "show"}})
+	res, err = db.ExecContext(context.Background(), "SHOW TABLES")
+	require.NoError(t, err)
+	n, err = res.RowsAffected()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestConn_CTASTable(t *testing.T) {
+	newConn := func() (*conn, *mockAthenaConnClient) {
+		client := &mockAthenaConnClient{
+			queryID:      "select",
+			location:     "s3://bucket/tables/select",
+			tableColumns: []*athena.Column{genTableColumn("first_name", "string")},
+		}
+		return &conn{
+			athena: client,
+			s3: &mockS3Client{objects: map[string][]byte{
+				"bucket/tables/select-manifest.csv": []byte("s3://bucket/tables/select/00000.gz\n"),
+				"bucket/tables/select/00000.gz":     genGzipObject(t, [][]string{{"a"}}),
+			}},
+			db:              "analytics",
+			OutputLocation:  "s3://bucket",
+			resultMode:      ResultModeGzipDL,
+			timeout:         10 * time.Second,
+			ctasDatabase:    "scratch",
+			ctasTablePrefix: "tmp_go_athena_",
+		}, client
+	}
+
+	t.Run("connection", func(t *testing.T) {
+		c, client := newConn()
+		rows, err := c.runQuery(context.Background(), "SELECT first_name FROM foo")
+		require.NoError(t, err)
+		readAllRows(t, rows)
+
+		require.Len(t, client.started, 2)
+		m := ctasQueryRegex.FindStringSubmatch(*client.started[0].QueryString)
+		require.NotNil(t, m)
+		assert.Equal(t, "scratch", m[1])
+		assert.True(t, strings.HasPrefix(m[2], "tmp_go_athena_"))
+		// the SELECT query still runs in the database of the connection.
+		assert.Equal(t, "analytics", *client.started[0].QueryExecutionContext.Database)
+		assert.Equal(t, "DROP TABLE IF EXISTS scratch."+m[2], *client.started[1].QueryString)
+		require.Len(t, client.described, 1)
+		assert.Equal(t, "scratch", *client.described[0].DatabaseName)
+		assert.Equal(t, m[2], *client.described[0].TableName)
+	})
+
+	t.Run("context", func(t *testing.T) {
+		c, client := newConn()
+		rows, err := c.runQuery(SetCTASTable(context.Background(), "other", ""), "SELECT first_name FROM foo")
+		require.NoError(t, err)
+		readAllRows(t, rows)
+
+		m := ctasQueryRegex.FindStringSubmatch(*client.started[0].QueryString)
+		require.NotNil(t, m)
+		assert.Equal(t, "other", m[1])
+		assert.True(t, strings.HasPrefix(m[2], "tmp_go_athena_"))
+		assert.Equal(t, "DROP TABLE IF EXISTS other."+m[2], *client.started[1].QueryString)
+		assert.Equal(t, "other", *client.described[0].DatabaseName)
+
+		_, err = c.runQuery(SetCTASTable(context.Background(), "", "tmp-ctas"), "SELECT first_name FROM foo")
+		assert.Error(t, err)
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		c, client := newConn()
+		client.query = "CREATE TABLE scratch.tmp_go_athena_0123abcd WITH (format='TEXTFILE') AS SELECT first_name FROM foo"
+		rows, err := c.runQuery(SetQueryExecutionID(context.Background(), "select"), "")
+		require.NoError(t, err)
+		assert.IsType(t, &rowsGzipDL{}, rows)
+		readAllRows(t, rows)
+
+		require.Len(t, client.started, 1)
+		assert.Equal(t, "DROP TABLE IF EXISTS scratch.tmp_go_athena_0123abcd", *client.started[0].QueryString)
+		assert.Equal(t, "scratch", *client.described[0].DatabaseName)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		c, client := newConn()
+		c.ctasDatabase, c.ctasTablePrefix = "", ""
+		rows, err := c.runQuery(context.Background(), "SELECT first_name FROM foo")
+		require.NoError(t, err)
+		readAllRows(t, rows)
+
+		m := ctasQueryRegex.FindStringSubmatch(*client.started[0].QueryString)
+		require.NotNil(t, m)
+		assert.Equal(t, "", m[1])
+		assert.True(t, strings.HasPrefix(m[2], "tmp_ctas_"))
+		assert.Equal(t, "DROP TABLE IF EXISTS "+m[2], *client.started[1].QueryString)
+		assert.Equal(t, "analytics", *client.described[0].DatabaseName)
+	})
+}
+
+func TestConn_QueryContext(t *testing.T) {
+	client := &mockAthenaConnClient{
+		queryID:      "select",
+		location:     "s3://bucket/tables/select",
+		tableColumns: []*athena.Column{genTableColumn("first_name", "string")},
+	}
+	c := &conn{
+		athena: client,
+		s3: &mockS3Client{objects: map[string][]byte{
+			"bucket/tables/select-manifest.csv": []byte("s3://bucket/tables/select/00000.gz\n"),
+			"bucket/tables/select/00000.gz":     genGzipObject(t, [][]string{{"a"}}),
+		}},
+		db:             "analytics",
+		catalog:        CATALOG_AWS_DATA_CATALOG,
+		OutputLocation: "s3://bucket",
+		resultMode:     ResultModeGzipDL,
+		timeout:        10 * time.Second,
+	}
+
+	// the query, its CTAS table and dropping it are all in the catalog and the database of context.
+	rows, err := c.runQuery(SetQueryContext(context.Background(), "federated", "sales"), "SELECT first_name FROM foo")
+	require.NoError(t, err)
+	readAllRows(t, rows)
+
+	require.Len(t, client.started, 2)
+	for _, input := range client.started {
+		assert.Equal(t, "federated", *input.QueryExecutionContext.Catalog)
+		assert.Equal(t, "sales", *input.QueryExecutionContext.Database)
+	}
+	require.Len(t, client.described, 1)
+	assert.Equal(t, "federated", *client.described[0].CatalogName)
+	assert.Equal(t, "sales", *client.described[0].DatabaseName)
+
+	// empty ones are the ones of the connection.
+	client.started, client.described = nil, nil
+	rows, err = c.runQuery(SetQueryContext(context.Background(), "", "sales"), "SELECT first_name FROM foo")
+	require.NoError(t, err)
+	readAllRows(t, rows)
+	assert.Equal(t, CATALOG_AWS_DATA_CATALOG, *client.started[0].QueryExecutionContext.Catalog)
+	assert.Equal(t, "sales", *client.started[0].QueryExecutionContext.Database)
+	assert.Equal(t, CATALOG_AWS_DATA_CATALOG, *client.described[0].CatalogName)
+}
+
+func TestConn_CTASFieldDelimiter(t *testing.T) {
+	client := &mockAthenaConnClient{
+		queryID:      "select",
+		location:     "s3://bucket/tables/select",
+		tableColumns: []*athena.Column{genTableColumn("a", "string"), genTableColumn("b", "string")},
+	}
+	c := &conn{
+		athena: client,
+		s3: &mockS3Client{objects: map[string][]byte{
+			"bucket/tables/select-manifest.csv": []byte("s3://bucket/tables/select/00000.gz\n"),
+			"bucket/tables/select/00000.gz":     []byte("x\ty\001z\n"),
+		}},
+		OutputLocation:     "s3://bucket",
+		resultMode:         ResultModeGzipDL,
+		timeout:            10 * time.Second,
+		ctasFieldDelimiter: "\t",
+	}
+
+	rows, err := c.runQuery(context.Background(), "SELECT a, b FROM foo")
+	require.NoError(t, err)
+	assert.Equal(t, [][]driver.Value{{"x", "y\001z"}}, readAllRows(t, rows))
+	assert.Contains(t, *client.started[0].QueryString, "field_delimiter='\t'")
+
+	// the delimiter of the resumed query is the one in its CTAS query.
+	client.query = *client.started[0].QueryString
+	rows, err = c.runQuery(SetQueryExecutionID(context.Background(), "select"), "")
+	require.NoError(t, err)
+	assert.Equal(t, [][]driver.Value{{"x", "y\001z"}}, readAllRows(t, rows))
+
+	// PARQUET has no field delimiter.
+	format, err := c.getCTASFormat(context.Background(), ResultModeParquetDL)
+	require.NoError(t, err)
+	assert.Equal(t, "", format.fieldDelimiter)
+}
+
+func TestConn_QueryTags(t *testing.T) {
+	client := &mockAthenaConnClient{
+		queryID:      "select",
+		location:     "s3://bucket/tables/select",
+		tableColumns: []*athena.Column{genTableColumn("first_name", "string")},
+	}
+	c := &conn{
+		athena: client,
+		s3: &mockS3Client{objects: map[string][]byte{
+			"bucket/tables/select-manifest.csv": []byte("s3://bucket/tables/select/00000.gz\n"),
+			"bucket/tables/select/00000.gz":     genGzipObject(t, [][]string{{"a"}}),
+		}},
+		OutputLocation: "s3://bucket",
+		resultMode:     ResultModeGzipDL,
+		timeout:        10 * time.Second,
+		queryTags:      map[string]string{"team": "data", "env": "dev"},
+	}
+
+	ctx := SetQueryTags(context.Background(), map[string]string{"app": "etl"})
+	ctx = SetQueryTags(ctx, map[string]string{"env": "prod"})
+	rows, err := c.runQuery(ctx, "SELECT first_name FROM foo")
+	require.NoError(t, err)
+	readAllRows(t, rows)
+
+	require.Len(t, client.started, 2)
+	ctasQuery := *client.started[0].QueryString
+	assert.True(t, strings.HasPrefix(ctasQuery, "/* tags: app=etl, env=prod, team=data */\nCREATE TABLE "), ctasQuery)
+	// DROP TABLE isn't tagged.
+	assert.True(t, strings.HasPrefix(*client.started[1].QueryString, "DROP TABLE "))
+	assert.Equal(t, map[string]string{"team": "data", "env": "dev"}, c.queryTags, "the defaults should not be changed")
+
+	// the tagged CTAS query is resumed in GZIP DL mode.
+	client.query = ctasQuery
+	rows, err = c.runQuery(SetQueryExecutionID(context.Background(), "select"), "")
+	require.NoError(t, err)
+	assert.Equal(t, [][]driver.Value{{"a"}}, readAllRows(t, rows))
+}
+
+func Test_tagQuery(t *testing.T) {
+	assert.Equal(t, "SELECT 1", tagQuery("SELECT 1", nil))
+	assert.Equal(t, "/* tags: a=1 */\nSELECT 1", tagQuery("SELECT 1", map[string]string{"a": "1"}))
+	assert.Equal(t, "/* tags: a=* / */\nSELECT 1", tagQuery("SELECT 1", map[string]string{"a": "*/"}))
+	assert.Equal(t, "SHOW TABLES", tagQuery("SHOW TABLES", map[string]string{"a": "1"}))
+}
+
+// mockDropFailingClient fails to start DROP TABLE queries.
+type mockDropFailingClient struct {
+	*mockAthenaConnClient
+}
+
+func (m *mockDropFailingClient) StartQueryExecution(input *athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	if strings.HasPrefix(*input.QueryString, "DROP TABLE") {
+		return nil, errors.New("access denied")
+	}
+	return m.mockAthenaConnClient.StartQueryExecution(input)
+}
+
+func TestConn_DropCTASTableOnError(t *testing.T) {
+	newConn := func(objects map[string][]byte) (*conn, *mockAthenaConnClient) {
+		client := &mockAthenaConnClient{
+			queryID:      "select",
+			location:     "s3://bucket/tables/select",
+			tableColumns: []*athena.Column{genTableColumn("first_name", "string")},
+		}
+		return &conn{
+			athena:         client,
+			s3:             &mockS3Client{objects: objects},
+			OutputLocation: "s3://bucket",
+			resultMode:     ResultModeGzipDL,
+			timeout:        10 * time.Second,
+		}, client
+	}
+	assertDropped := func(t *testing.T, client *mockAthenaConnClient) {
+		t.Helper()
+		require.Len(t, client.started, 2)
+		table := ctasQueryRegex.FindStringSubmatch(*client.started[0].QueryString)[2]
+		assert.Equal(t, "DROP TABLE IF EXISTS "+table, *client.started[1].QueryString)
+	}
+	objects := map[string][]byte{
+		"bucket/tables/select-manifest.csv": []byte("s3://bucket/tables/select/00000.gz\n"),
+		"bucket/tables/select/00000.gz":     genGzipObject(t, [][]string{{"a"}}),
+	}
+
+	t.Run("query failed", func(t *testing.T) {
+		c, client := newConn(objects)
+		client.state = athena.QueryExecutionStateFailed
+		_, err := c.runQuery(context.Background(), "SELECT first_name FROM foo")
+		assert.Error(t, err)
+		assertDropped(t, client)
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		c, client := newConn(objects)
+		c.pollFrequency = 5 * time.Millisecond
+		client.pending = 20
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := c.runQuery(ctx, "SELECT first_name FROM foo")
+		assert.Equal(t, context.DeadlineExceeded, err)
+		// the table is dropped although ctx is done.
+		assertDropped(t, client)
+	})
+
+	t.Run("download failed", func(t *testing.T) {
+		c, client := newConn(map[string][]byte{})
+		_, err := c.runQuery(context.Background(), "SELECT first_name FROM foo")
+		assert.Error(t, err)
+		assertDropped(t, client)
+	})
+
+	t.Run("download failed with keepCTASTableOnAbort", func(t *testing.T) {
+		c, client := newConn(map[string][]byte{})
+		c.keepCTASTableOnAbort = true
+		_, err := c.runQuery(context.Background(), "SELECT first_name FROM foo")
+		assert.Error(t, err)
+		// the result can be read again with SetQueryExecutionID.
+		assert.Len(t, client.started, 1)
+	})
+
+	t.Run("drop failed", func(t *testing.T) {
+		c, client := newConn(objects)
+		c.athena = &mockDropFailingClient{client}
+		var warned []string
+		c.logger = func(_ context.Context, level LogLevel, msg string, _ ...interface{}) {
+			if level == LogLevelWarn {
+				warned = append(warned, msg)
+			}
+		}
+		rows, err := c.runQuery(context.Background(), "SELECT first_name FROM foo")
+		require.NoError(t, err)
+		// the drop is best-effort, so reading the rows succeeds.
+		assert.Len(t, readAllRows(t, rows), 1)
+		assert.Equal(t, []string{"failed to drop CTAS table"}, warned)
+	})
+}
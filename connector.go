@@ -0,0 +1,126 @@
+package athena
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// connector is a driver.Connector sharing the AWS clients among its connections.
+type connector struct {
+	driver *Driver
+	cfg    *Config
+	athena athenaiface.AthenaAPI
+	s3     s3iface.S3API
+
+	// mu guards healthy, which is whether the health check query succeeded.
+	mu      sync.Mutex
+	healthy bool
+
+	// validQueries remembers the queries found valid by ValidateQuery with Config.CacheValidQueries.
+	validQueries *validQueries
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cfg := c.cfg
+
+	// output location (with empty value), resolved per connection since cfg is shared by them.
+	outputLocation := cfg.OutputLocation
+	if checkOutputLocation(cfg.ResultMode, outputLocation) {
+		var err error
+		outputLocation, err = getOutputLocation(c.athena, cfg.WorkGroup)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cn := &conn{
+		athena:         c.athena,
+		s3:             c.s3,
+		db:             cfg.Database,
+		OutputLocation: outputLocation,
+		pollFrequency:  cfg.PollFrequency,
+		workgroup:      cfg.WorkGroup,
+		resultMode:     cfg.ResultMode,
+		timeout:        cfg.queryTimeout(),
+		catalog:        cfg.Catalog,
+		strictMode:     cfg.StrictResultMode,
+		converter:      newConverter(cfg),
+		cache:          newResultCache(cfg.CacheDir, cfg.CacheTTL),
+
+		downloadConcurrency: cfg.DownloadConcurrency,
+		downloadTimeout:     cfg.DownloadTimeout,
+		downloadRetries:     cfg.DownloadRetries,
+		downloadPrefetch:    cfg.DownloadPrefetch,
+		downloadNonSelect:   cfg.DownloadNonSelect,
+		validateETag:        cfg.ValidateETag,
+		queryRewriter:       cfg.QueryRewriter,
+		resultReuseMaxAge:   cfg.ResultReuseMaxAge,
+		duplicateColumnMode: cfg.DuplicateColumnMode,
+
+		keepCTASTableOnAbort: cfg.KeepCTASTableOnAbort,
+		readOnly:             cfg.ReadOnly,
+
+		logger:           cfg.Logger,
+		scanWarningRatio: cfg.ScanWarningRatio,
+		maxQueueTime:     cfg.MaxQueueTime,
+		autoLimit:        cfg.AutoLimit,
+
+		maxPages:           cfg.MaxPages,
+		truncateAtMaxPages: cfg.TruncateAtMaxPages,
+
+		pollBackoff:  cfg.PollBackoff,
+		retryBackoff: cfg.RetryBackoff,
+		maxRetries:   cfg.MaxRetries,
+
+		shadow:          cfg.Shadow,
+		ctasFormat:      cfg.CTASFormat,
+		ctasCompression: cfg.CTASCompression,
+		ctasDatabase:    cfg.CTASDatabase,
+		ctasTablePrefix: cfg.CTASTablePrefix,
+
+		ctasFieldDelimiter: cfg.CTASFieldDelimiter,
+		validQueries:       c.validQueries,
+		queryTags:          cfg.QueryTags,
+	}
+
+	if cfg.HealthCheck {
+		if err := c.checkHealth(ctx, cn); err != nil {
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// healthCheckQuery is the trivial query run by the health check.
+const healthCheckQuery = "SELECT 1"
+
+// checkHealth runs the health check query by the first connection, and the next ones until it succeeds.
+func (c *connector) checkHealth(ctx context.Context, cn *conn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.healthy {
+		return nil
+	}
+
+	queryID, err := cn.startQuery(ctx, healthCheckQuery, startQueryOptions{})
+	if err == nil {
+		_, err = cn.waitOnQuery(ctx, queryID)
+	}
+	if err != nil {
+		return fmt.Errorf("health check query failed: %w", err)
+	}
+	c.healthy = true
+	return nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+var _ driver.Connector = (*connector)(nil)
+var _ driver.DriverContext = (*Driver)(nil)
@@ -0,0 +1,80 @@
+package athena
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_SharesClients(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String("us-east-1")})
+	require.NoError(t, err)
+
+	d := NewDriver(&Config{
+		Session:        sess,
+		Database:       "db",
+		OutputLocation: "s3://bucket",
+	})
+	connector, err := d.OpenConnector("")
+	require.NoError(t, err)
+
+	c1, err := connector.Connect(context.Background())
+	require.NoError(t, err)
+	c2, err := connector.Connect(context.Background())
+	require.NoError(t, err)
+
+	assert.Same(t, c1.(*conn).athena, c2.(*conn).athena)
+	assert.Same(t, c1.(*conn).s3, c2.(*conn).s3)
+}
+
+func TestConnector_OutputLocationOfWorkGroup(t *testing.T) {
+	client := &mockAthenaConnClient{
+		workGroup: &athena.WorkGroup{
+			Configuration: &athena.WorkGroupConfiguration{
+				ResultConfiguration: &athena.ResultConfiguration{OutputLocation: aws.String("s3://workgroup")},
+			},
+		},
+	}
+	cfg := &Config{WorkGroup: "primary", ResultMode: ResultModeDL}
+	c := &connector{cfg: cfg, athena: client}
+
+	cn, err := c.Connect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "s3://workgroup", cn.(*conn).OutputLocation)
+	// the shared config is left as it is.
+	assert.Empty(t, cfg.OutputLocation)
+}
+
+func TestConnector_HealthCheck(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "health", state: athena.QueryExecutionStateFailed, reason: "workgroup is disabled"}
+	c := &connector{
+		cfg:    &Config{OutputLocation: "s3://bucket", HealthCheck: true},
+		athena: client,
+	}
+
+	_, err := c.Connect(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "workgroup is disabled")
+
+	// it's run again until it succeeds, but only once after that.
+	client.state = athena.QueryExecutionStateSucceeded
+	_, err = c.Connect(context.Background())
+	require.NoError(t, err)
+	_, err = c.Connect(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, client.started, 2)
+	assert.Equal(t, "SELECT 1", *client.started[0].QueryString)
+
+	// it's disabled by default.
+	client = &mockAthenaConnClient{}
+	c = &connector{cfg: &Config{OutputLocation: "s3://bucket"}, athena: client}
+	_, err = c.Connect(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, client.started)
+}
@@ -1,6 +1,9 @@
 package athena
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 const contextPrefix string = "go-athena"
 
@@ -28,6 +31,11 @@ func SetGzipDLMode(ctx context.Context) context.Context {
 	return context.WithValue(ctx, ResultModeContextKey, ResultModeGzipDL)
 }
 
+// SetParquetDLMode set ParquetDLMode to ResultMode from context
+func SetParquetDLMode(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ResultModeContextKey, ResultModeParquetDL)
+}
+
 func getResultMode(ctx context.Context) (ResultMode, bool) {
 	val, ok := ctx.Value(ResultModeContextKey).(ResultMode)
 	return val, ok
@@ -42,14 +50,27 @@ const timeoutContextKey string = "timeout_key"
 // TimeoutContextKey context key of setting timeout
 var TimeoutContextKey string = contextPrefix + timeoutContextKey
 
-// SetTimeout set timeout from context
+// SetTimeout set timeout in seconds from context
+//
+// Deprecated: use SetQueryTimeout.
 func SetTimeout(ctx context.Context, timeout uint) context.Context {
 	return context.WithValue(ctx, TimeoutContextKey, timeout)
 }
 
-func getTimeout(ctx context.Context) (uint, bool) {
-	val, ok := ctx.Value(TimeoutContextKey).(uint)
-	return val, ok
+// SetQueryTimeout set timeout from context, see Config.QueryTimeout.
+func SetQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, TimeoutContextKey, timeout)
+}
+
+func getTimeout(ctx context.Context) (time.Duration, bool) {
+	switch val := ctx.Value(TimeoutContextKey).(type) {
+	case time.Duration:
+		return val, true
+	case uint:
+		return time.Duration(val) * time.Second, true
+	default:
+		return 0, false
+	}
 }
 
 /*
@@ -70,3 +91,241 @@ func getCatalog(ctx context.Context) (string, bool) {
 	val, ok := ctx.Value(CatalogContextKey).(string)
 	return val, ok
 }
+
+/*
+ * query context
+ */
+
+const queryContextContextKey string = "query_context_key"
+
+// QueryContextContextKey context key of setting the catalog and the database of queries
+var QueryContextContextKey string = contextPrefix + queryContextContextKey
+
+// queryContext is the catalog and the database of queries.
+type queryContext struct {
+	catalog  string
+	database string
+}
+
+// SetQueryContext set the catalog and the database of queries together from context, overriding Config.Catalog
+// and Config.Database, e.g. for a database of a federated catalog. Both of them are used to run the query
+// and to read the metadata of its CTAS table in GZIP DL mode. Each of them can be empty for the one of the connection.
+func SetQueryContext(ctx context.Context, catalog, database string) context.Context {
+	return context.WithValue(ctx, QueryContextContextKey, queryContext{catalog: catalog, database: database})
+}
+
+func getQueryContext(ctx context.Context) (queryContext, bool) {
+	val, ok := ctx.Value(QueryContextContextKey).(queryContext)
+	return val, ok
+}
+
+/*
+ * query stats
+ */
+
+const queryStatsContextKey string = "query_stats_key"
+
+// QueryStatsContextKey context key of setting query stats receiver
+var QueryStatsContextKey string = contextPrefix + queryStatsContextKey
+
+// SetQueryStatsReceiver set a receiver to which the statistics of the query execution
+// are written when the query succeeds.
+func SetQueryStatsReceiver(ctx context.Context, stats *QueryStats) context.Context {
+	return context.WithValue(ctx, QueryStatsContextKey, stats)
+}
+
+func getQueryStatsReceiver(ctx context.Context) (*QueryStats, bool) {
+	val, ok := ctx.Value(QueryStatsContextKey).(*QueryStats)
+	return val, ok && val != nil
+}
+
+/*
+ * column projection
+ */
+
+const columnProjectionContextKey string = "column_projection_key"
+
+// ColumnProjectionContextKey context key of setting column projection
+var ColumnProjectionContextKey string = contextPrefix + columnProjectionContextKey
+
+// SetColumnProjection set the columns to be converted in GZIP DL mode from context.
+// The values of the other columns are nil, which saves conversion for wide results
+// where only a few columns are read.
+func SetColumnProjection(ctx context.Context, columns ...string) context.Context {
+	return context.WithValue(ctx, ColumnProjectionContextKey, columns)
+}
+
+func getColumnProjection(ctx context.Context) []string {
+	val, _ := ctx.Value(ColumnProjectionContextKey).([]string)
+	return val
+}
+
+/*
+ * CTAS format
+ */
+
+const ctasFormatContextKey string = "ctas_format_key"
+
+// CTASFormatContextKey context key of setting the format of the CTAS table
+var CTASFormatContextKey string = contextPrefix + ctasFormatContextKey
+
+// SetCTASFormat set the format of the CTAS table of GZIP DL mode and its compression from context,
+// see Config.CTASFormat. compression can be empty for the default of the format.
+func SetCTASFormat(ctx context.Context, format, compression string) context.Context {
+	return context.WithValue(ctx, CTASFormatContextKey, ctasFormat{format: format, compression: compression})
+}
+
+func getCTASFormat(ctx context.Context) (ctasFormat, bool) {
+	val, ok := ctx.Value(CTASFormatContextKey).(ctasFormat)
+	return val, ok
+}
+
+/*
+ * CTAS table
+ */
+
+const ctasTableContextKey string = "ctas_table_key"
+
+// CTASTableContextKey context key of setting the database and the prefix of the CTAS table
+var CTASTableContextKey string = contextPrefix + ctasTableContextKey
+
+// SetCTASTable set the database and the name prefix of the CTAS table of GZIP DL mode from context,
+// see Config.CTASDatabase. Each of them can be empty for the one of the connection.
+func SetCTASTable(ctx context.Context, database, prefix string) context.Context {
+	return context.WithValue(ctx, CTASTableContextKey, ctasTableOptions{database: database, prefix: prefix})
+}
+
+func getCTASTable(ctx context.Context) (ctasTableOptions, bool) {
+	val, ok := ctx.Value(CTASTableContextKey).(ctasTableOptions)
+	return val, ok
+}
+
+/*
+ * client request token
+ */
+
+const clientRequestTokenContextKey string = "client_request_token_key"
+
+// ClientRequestTokenContextKey context key of setting client request token
+var ClientRequestTokenContextKey string = contextPrefix + clientRequestTokenContextKey
+
+// SetClientRequestToken set the idempotency token with which the query is started from context.
+// Retrying the same query with the same token returns the result of the query already started
+// instead of starting another one. The token must be 32 to 128 characters.
+//
+// In GZIP DL mode, the query is wrapped by CTAS with a random table name, so retrying it starts another query.
+// StartOrReattachQuery starts queries which can be reattached by their tokens after the process restarted.
+func SetClientRequestToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, ClientRequestTokenContextKey, token)
+}
+
+func getClientRequestToken(ctx context.Context) string {
+	val, _ := ctx.Value(ClientRequestTokenContextKey).(string)
+	return val
+}
+
+/*
+ * result object
+ */
+
+const resultObjectContextKey string = "result_object_key"
+
+// ResultObjectContextKey context key of setting result object receiver
+var ResultObjectContextKey string = contextPrefix + resultObjectContextKey
+
+// SetResultObjectReceiver set a receiver to which the metadata of the S3 object of the query result,
+// e.g. when it was written, is written when the query succeeds. It costs a HeadObject request.
+func SetResultObjectReceiver(ctx context.Context, obj *ResultObject) context.Context {
+	return context.WithValue(ctx, ResultObjectContextKey, obj)
+}
+
+func getResultObjectReceiver(ctx context.Context) (*ResultObject, bool) {
+	val, ok := ctx.Value(ResultObjectContextKey).(*ResultObject)
+	return val, ok && val != nil
+}
+
+/*
+ * query execution id
+ */
+
+const queryExecutionIDContextKey string = "query_execution_id_key"
+
+// QueryExecutionIDContextKey context key of setting query execution id
+var QueryExecutionIDContextKey string = contextPrefix + queryExecutionIDContextKey
+
+// SetQueryExecutionID set the ID of a query already run from context, whose result is read
+// instead of running the query given to Query, e.g. to read it again after the download was interrupted.
+// The result of GZIP DL mode is read as long as its CTAS table is kept, see Config.KeepCTASTableOnAbort.
+func SetQueryExecutionID(ctx context.Context, queryID string) context.Context {
+	return context.WithValue(ctx, QueryExecutionIDContextKey, queryID)
+}
+
+func getQueryExecutionID(ctx context.Context) (string, bool) {
+	val, ok := ctx.Value(QueryExecutionIDContextKey).(string)
+	return val, ok && val != ""
+}
+
+/*
+ * force fresh results
+ */
+
+const forceFreshResultsContextKey string = "force_fresh_results_key"
+
+// ForceFreshResultsContextKey context key of setting force fresh results
+var ForceFreshResultsContextKey string = contextPrefix + forceFreshResultsContextKey
+
+// SetForceFreshResults set whether the query is executed freshly from context, e.g. for a "refresh" button.
+// Neither the results reused by Config.ResultReuseMaxAge nor the local result cache are returned,
+// though the fresh result is cached.
+func SetForceFreshResults(ctx context.Context, fresh bool) context.Context {
+	return context.WithValue(ctx, ForceFreshResultsContextKey, fresh)
+}
+
+func getForceFreshResults(ctx context.Context) bool {
+	val, _ := ctx.Value(ForceFreshResultsContextKey).(bool)
+	return val
+}
+
+/*
+ * query tags
+ */
+
+const queryTagsContextKey string = "query_tags_key"
+
+// QueryTagsContextKey context key of setting query tags
+var QueryTagsContextKey string = contextPrefix + queryTagsContextKey
+
+// SetQueryTags set the tags of the query from context, in addition to Config.QueryTags and the tags set before.
+// The tags with the same keys override them.
+func SetQueryTags(ctx context.Context, tags map[string]string) context.Context {
+	merged := make(map[string]string)
+	for k, v := range getQueryTags(ctx) {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, QueryTagsContextKey, merged)
+}
+
+func getQueryTags(ctx context.Context) map[string]string {
+	val, _ := ctx.Value(QueryTagsContextKey).(map[string]string)
+	return val
+}
+
+/*
+ * execution parameters
+ */
+
+// executionParametersContextKey is the context key of the execution parameters of the query,
+// which are the arguments of QueryContext and ExecContext. It's internal to the driver.
+const executionParametersContextKey string = contextPrefix + "execution_parameters_key"
+
+func withExecutionParameters(ctx context.Context, params []string) context.Context {
+	return context.WithValue(ctx, executionParametersContextKey, params)
+}
+
+func getExecutionParameters(ctx context.Context) []string {
+	val, _ := ctx.Value(executionParametersContextKey).([]string)
+	return val
+}
@@ -0,0 +1,105 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryToCSV runs a query and writes its result to w as RFC 4180 CSV, with a header row of the column names.
+// Unlike the CSV of Athena, the values are written in portable string forms regardless of the result mode:
+// NULL is an empty field, timestamps, dates and times are in TimestampLayout, DateLayout and TimeLayout,
+// binaries are hex, and arrays and maps are JSON.
+func QueryToCSV(ctx context.Context, db *sql.DB, query string, w io.Writer, args ...interface{}) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			if record[i], err = csvField(strings.ToLower(columnTypes[i].DatabaseTypeName()), v); err != nil {
+				return err
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvField formats a value of a column of athenaType for QueryToCSV.
+func csvField(athenaType string, v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return hex.EncodeToString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		// float is parsed in 32 bits, so it's formatted in the shortest text of them, e.g. 0.1.
+		if athenaType == "float" {
+			return strconv.FormatFloat(v, 'g', -1, 32), nil
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case time.Time:
+		switch athenaType {
+		case "date":
+			return v.Format(DateLayout), nil
+		case "time":
+			return v.Format(TimeLayout), nil
+		case "timestamp with time zone":
+			return v.Format(TimestampWithTimeZoneLayout), nil
+		default:
+			return v.Format(TimestampLayout), nil
+		}
+	case []interface{}, map[string]interface{}:
+		b, err := json.Marshal(v)
+		return string(b), err
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
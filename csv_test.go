@@ -0,0 +1,73 @@
+package athena
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryToCSV(t *testing.T) {
+	columns := []struct{ name, typ string }{
+		{"name", "varchar"}, {"n", "bigint"}, {"score", "double"}, {"ok", "boolean"}, {"day", "date"}, {"at", "timestamp"},
+	}
+	row := func(values ...*string) *athena.Row {
+		var data []*athena.Datum
+		for _, v := range values {
+			data = append(data, &athena.Datum{VarCharValue: v})
+		}
+		return &athena.Row{Data: data}
+	}
+	queryToResultsGenMap["csv"] = func(string) (*athena.GetQueryResultsOutput, error) {
+		var info []*athena.ColumnInfo
+		var header []*string
+		for _, c := range columns {
+			info = append(info, &athena.ColumnInfo{Name: aws.String(c.name), Type: aws.String(c.typ)})
+			header = append(header, aws.String(c.name))
+		}
+		return &athena.GetQueryResultsOutput{
+			ResultSet: &athena.ResultSet{
+				ResultSetMetadata: &athena.ResultSetMetadata{ColumnInfo: info},
+				Rows: []*athena.Row{
+					row(header...),
+					row(aws.String(`a "quoted", name`), aws.String("42"), aws.String("0.5"), aws.String("true"),
+						aws.String("2024-01-15"), aws.String("2024-01-15 01:02:03.456")),
+					row(nil, nil, nil, nil, nil, nil),
+				},
+			},
+		}, nil
+	}
+	defer delete(queryToResultsGenMap, "csv")
+
+	db := openMockDB(t, &conn{athena: &mockAthenaConnClient{queryID: "csv"}})
+	var buf bytes.Buffer
+	require.NoError(t, QueryToCSV(context.Background(), db, "SELECT * FROM foo", &buf))
+	assert.Equal(t, "name,n,score,ok,day,at\r\n"+
+		"\"a \"\"quoted\"\", name\",42,0.5,true,2024-01-15,2024-01-15 01:02:03.456\r\n"+
+		",,,,,\r\n", buf.String())
+}
+
+func Test_csvField(t *testing.T) {
+	for _, tc := range []struct {
+		athenaType string
+		v          interface{}
+		expected   string
+	}{
+		{"varbinary", []byte("hi"), "6869"},
+		{"time", time.Date(0, 1, 1, 1, 2, 3, 0, time.UTC), "01:02:03"},
+		{"array<string>", []interface{}{"a", nil}, `["a",null]`},
+		{"map<string,int>", map[string]interface{}{"a": int64(1)}, `{"a":1}`},
+		{"real", 1e21, "1e+21"},
+		{"float", float64(float32(0.1)), "0.1"},
+		{"double", 0.1, "0.1"},
+	} {
+		field, err := csvField(tc.athenaType, tc.v)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, field, "type: %s", tc.athenaType)
+	}
+}
@@ -1,6 +1,7 @@
 package athena
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
@@ -15,6 +16,9 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 var (
@@ -57,6 +61,9 @@ func init() {
 // This is the S3 location Athena will dump query results in the format
 // "s3://bucket/and/so/forth". In the AWS UI, this defaults to
 // "s3://aws-athena-query-results-<ACCOUNTID>-<REGION>", but the driver requires it.
+// It can have the placeholders of the UTC date when each query is submitted, `{yyyy}`, `{mm}`, `{dd}`
+// and `{hh}`, e.g. "s3://bucket/results/{yyyy}/{mm}/{dd}/", for lifecycle rules by date. Athena writes
+// the result of each query under it named by the query id, e.g. "<location>/<query id>.csv".
 //
 // - `poll_frequency` (optional)
 // Athena's API requires polling to retrieve query results. This is the frequency at
@@ -66,13 +73,169 @@ func init() {
 // - `region` (optional)
 // Override AWS region. Useful if it is not set with environment variable.
 //
+// - `profile` (optional)
+// The profile of the shared config (~/.aws/config) to use. This defaults to
+// AWS_PROFILE or "default". IAM Identity Center (SSO) profiles are supported
+// as long as the SSO token is cached, e.g. by `aws sso login`.
+//
 // - `workgroup` (optional)
 // Athena's workgroup. This defaults to "primary".
 //
+// - `unconvertible_value` (optional)
+// The behavior for a value which cannot be converted to the Go type of its column.
+// "error" (default) fails Next, "raw" returns the raw string and "nil" returns nil.
+//
+// - `result_encoding` (optional)
+// The charset string values are transcoded to from UTF-8, e.g. "shift_jis" or "windows-1252",
+// by its WHATWG name. Strings are returned as UTF-8 by default.
+//
+// - `trim_fields` (optional)
+// If "true", the surrounding whitespace of the values of string columns is trimmed.
+//
+// - `truncated_value_length` (optional)
+// If set, the values of API mode at least this many bytes long are treated as truncated by GetQueryResults,
+// and are handled as unconvertible values with ErrTruncatedValue, and logged as warnings. It's disabled by default.
+//
+// - `decimal_as_string` (optional)
+// If "true", the values of decimal columns are returned as exact strings instead of float64.
+//
+// - `parse_complex_types` (optional)
+// If "true", the values of array, map and row columns of API and DL mode are parsed into slices and maps
+// of strings instead of returned as the strings rendered by Athena. It's ambiguous, see Config.ParseComplexTypes.
+//
+// - `null_string` (optional)
+// The string of NULL in the objects of the CTAS table of GZIP DL mode, e.g. for tables written
+// with another `serialization.null.format`. This defaults to `\N`. If it's set, the fields of
+// the CSV of DL mode equal to it are also NULL, in addition to empty unquoted fields.
+//
+// - `tinyint_as_bool` (optional)
+// If "true", the values of `tinyint` columns encoding booleans as 0 or 1 are converted to bool.
+// A comma separated list of columns, e.g. "is_active,is_deleted", converts only the columns.
+// They're converted to int64 by default.
+//
+// - `duplicate_columns` (optional)
+// How duplicate column names, e.g. two `id` columns of a join, are returned by Columns.
+// "keep" (default) returns them as they are, "suffix" returns `id`, `id_2`,
+// and "qualify" returns `users.id`, `orders.id` when Athena reports the tables.
+//
+// - `download_concurrency` (optional)
+// The maximum number of S3 objects downloaded concurrently in GZIP DL mode.
+// This defaults to 8.
+//
+// - `download_prefetch` (optional)
+// The maximum number of S3 objects downloaded ahead of reading rows in GZIP DL mode.
+// This defaults to `download_concurrency`.
+//
+// - `validate_etag` (optional)
+// If "true", the ETags of the result objects are listed when the manifest is read in GZIP DL mode,
+// and every object is downloaded with If-Match, so that reading fails with ErrResultObjectChanged
+// when an object is rewritten in the meantime.
+//
+// - `keep_ctas_on_abort` (optional)
+// If "true", the CTAS table of GZIP DL mode isn't dropped when the rows are closed before
+// all rows are read, e.g. when the download is cancelled. The result can be read again
+// with SetQueryExecutionID, which drops the table after all rows are read.
+//
+// - `ctas_format` (optional)
+// The format of the CTAS table of GZIP DL mode, "TEXTFILE" (default) or "PARQUET",
+// which is read by the driver. ORC isn't supported, since the driver can't read it.
+//
+// - `ctas_compression` (optional)
+// The write_compression of the CTAS table of GZIP DL mode, "GZIP" or "NONE" for TEXTFILE,
+// and "SNAPPY" (default), "GZIP" or "NONE" for PARQUET. Athena's default of TEXTFILE is GZIP.
+//
+// - `ctas_field_delimiter` (optional)
+// The field_delimiter of the CTAS table of TEXTFILE, e.g. "," or a tab ("%09"), which is Athena's default, '\001',
+// if it's empty. Values containing it aren't escaped, so it should be one which doesn't appear in the values.
+//
+// - `ctas_database` (optional)
+// The database the CTAS table of GZIP DL mode is created in, e.g. a scratch database.
+// The database of the query (`db`) is used by default.
+//
+// - `ctas_table_prefix` (optional)
+// The prefix of the name of the CTAS table of GZIP DL mode. The default is "tmp_ctas_".
+//
+// - `cache_dir` (optional)
+// The local directory to cache the results of SELECT queries in. When the same query
+// is run again, the cached rows are returned without querying Athena. It's intended
+// for development and testing, so this is disabled by default.
+//
+// - `cache_ttl` (optional)
+// How long cached results are used. It should be a time/Duration.String().
+// Cached results never expire by default.
+//
+// - `strict_result_mode` (optional)
+// If "true", queries fail with ErrResultModeMismatch instead of silently falling back
+// to API mode when DL or GZIP DL mode is set in context for a non-SELECT query.
+//
+// - `read_only` (optional)
+// If "true", queries other than SELECT, WITH, SHOW, DESCRIBE and EXPLAIN fail with ErrReadOnly
+// before they're run, e.g. for analytics-only services. Spark calculations are also rejected.
+//
+// - `health_check` (optional)
+// If "true", `SELECT 1` is run when the first connection is established, so that misconfiguration,
+// e.g. a bad workgroup or insufficient permissions, fails the first use of the DB, e.g. `db.Ping`,
+// instead of the first real query. Note that `sql.Open` doesn't connect.
+//
+// - `cache_valid_queries` (optional)
+// If "true", `ValidateQuery` remembers the queries found valid by the connections of the DB,
+// and doesn't run EXPLAIN of them again.
+//
+// - `query_tags` (optional)
+// The default tags of the queries as comma separated `key:value` pairs, e.g. "team:data,app:etl",
+// which are prepended to the queries as a comment, e.g. for filtering the query history.
+//
+// - `download_non_select` (optional)
+// If "true", non-SELECT queries producing rows, e.g. SHOW and DESCRIBE, are also run in DL mode
+// under DL mode. They always fall back to API mode in GZIP DL mode, which needs a SELECT for CTAS.
+//
+// - `timeout` (optional)
+// The limit of each of waiting for the query execution and downloading its result in DL and
+// GZIP DL mode. It should be a time/Duration.String(), or a number of seconds for compatibility.
+// The query is stopped when waiting for it times out. Submitting the query and reading pages
+// in API mode are limited only by the context. This defaults to Athena's limit of "30m".
+//
+// - `download_timeout` (optional)
+// The limit of downloading the result in DL and GZIP DL mode, separately from waiting for the query,
+// e.g. a generous one for big results. In GZIP and PARQUET DL mode, it limits reading the manifest and
+// each download of an S3 object, not reading all rows. It should be a time/Duration.String().
+// This defaults to `timeout`.
+//
+// - `download_retries` (optional)
+// The number of times a failed object of the CTAS table is downloaded again in GZIP DL mode,
+// without downloading the other objects again. It defaults to 0.
+//
+// - `auto_limit` (optional)
+// If set, `LIMIT <auto_limit>` is appended to SELECT queries which don't end with a LIMIT or FETCH clause,
+// e.g. to keep the users of a query editor from downloading huge results by mistake.
+// It's disabled by default.
+//
+// - `max_pages` (optional)
+// The maximum number of pages of GetQueryResults read in API mode, each of which has up to 1000 rows.
+// Reading more pages fails with ErrMaxPagesExceeded. It's unlimited by default.
+//
+// - `truncate_at_max_pages` (optional)
+// If "true", the rows end at `max_pages` instead of failing with ErrMaxPagesExceeded.
+//
+// - `max_queue_time` (optional)
+// The limit of the time a query stays queued, e.g. while the concurrency of the workgroup
+// is saturated. The query is stopped and fails with ErrQueueTimeout when it's exceeded.
+// It should be a time/Duration.String(). Queries can be queued until the timeout by default.
+//
 // Credentials must be accessible via the SDK's Default Credential Provider Chain.
 // For more advanced AWS credentials/session/config management, please supply
 // a custom AWS session directly via `athena.Open()`.
 func (d *Driver) Open(connStr string) (driver.Conn, error) {
+	c, err := d.OpenConnector(connStr)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector implements driver.DriverContext. The parameters are the same as Open.
+// The AWS clients are created once per connector and shared among its connections.
+func (d *Driver) OpenConnector(connStr string) (driver.Connector, error) {
 	cfg := d.cfg
 	if cfg == nil {
 		var err error
@@ -86,29 +249,16 @@ func (d *Driver) Open(connStr string) (driver.Conn, error) {
 		cfg.PollFrequency = 5 * time.Second
 	}
 
-	// athena client
-	athenaClient := athena.New(cfg.Session)
-
-	// output location (with empty value)
-	if checkOutputLocation(cfg.ResultMode, cfg.OutputLocation) {
-		var err error
-		cfg.OutputLocation, err = getOutputLocation(athenaClient, cfg.WorkGroup)
-		if err != nil {
-			return nil, err
-		}
+	c := &connector{
+		driver: d,
+		cfg:    cfg,
+		athena: athena.New(cfg.Session),
+		s3:     s3.New(cfg.Session),
 	}
-
-	return &conn{
-		athena:         athenaClient,
-		db:             cfg.Database,
-		OutputLocation: cfg.OutputLocation,
-		pollFrequency:  cfg.PollFrequency,
-		workgroup:      cfg.WorkGroup,
-		resultMode:     cfg.ResultMode,
-		session:        cfg.Session,
-		timeout:        cfg.Timeout,
-		catalog:        cfg.Catalog,
-	}, nil
+	if cfg.CacheValidQueries {
+		c.validQueries = newValidQueries()
+	}
+	return c, nil
 }
 
 // Open is a more robust version of `db.Open`, as it accepts a raw aws.Session.
@@ -146,12 +296,162 @@ type Config struct {
 	WorkGroup      string
 
 	PollFrequency time.Duration
+	// PollBackoff decides the intervals of polling the status of queries, e.g. an ExponentialBackoff
+	// to poll short queries quickly. PollFrequency is used for every poll if it's nil.
+	PollBackoff BackoffStrategy
+	// RetryBackoff decides the intervals of retrying throttled API calls, up to MaxRetries times.
+	// Throttled calls aren't retried by the driver if it's nil.
+	RetryBackoff BackoffStrategy
+	MaxRetries   int
 
 	ResultMode ResultMode
-	Timeout    uint
-	Catalog    string
+	// QueryTimeout limits each of waiting for the query execution and downloading its result
+	// in DL and GZIP DL mode. The query is stopped when waiting for it times out.
+	// Zero means Timeout. If it's also zero, waiting isn't limited, e.g. for the queries
+	// running longer than the default quota of Athena, and the download is limited to 30 minutes.
+	QueryTimeout time.Duration
+	// Timeout is QueryTimeout in seconds.
+	//
+	// Deprecated: use QueryTimeout.
+	Timeout uint
+	Catalog string
+
+	// StrictResultMode makes a query fail instead of falling back to API mode
+	// when the result mode set in context doesn't support the query.
+	StrictResultMode bool
+	// ReadOnly makes queries which may modify data or metadata fail with ErrReadOnly before they're run.
+	ReadOnly bool
+	// HealthCheck runs `SELECT 1` when the first connection is established, so that misconfiguration,
+	// e.g. a bad workgroup or insufficient permissions, fails the first use of the DB, e.g. db.Ping,
+	// instead of the first real query. It's run by the next connections until it succeeds.
+	HealthCheck bool
+	// CacheValidQueries makes ValidateQuery remember the queries found valid in memory,
+	// so that repeated identical queries aren't run by EXPLAIN again, e.g. by a query editor
+	// validating every submit. A query remains valid even if a table it reads is dropped later.
+	CacheValidQueries bool
+	// QueryTags are the default tags of the queries, to which the ones set by SetQueryTags are added.
+	// StartQueryExecution has no tags, so they're prepended to the queries as a comment,
+	// e.g. `/* tags: app=etl, team=data */`, which is shown and searchable in the query history.
+	// The DDL statements except CTAS aren't tagged.
+	QueryTags map[string]string
+	// DownloadNonSelect lets non-SELECT queries producing rows, e.g. SHOW and DESCRIBE,
+	// use DL mode instead of always falling back to API mode.
+	DownloadNonSelect bool
+
+	// UnconvertibleValueMode is the behavior for a value which cannot be converted
+	// to the Go type of its column.
+	UnconvertibleValueMode UnconvertibleValueMode
+	// ValueConverter converts the values of query results before the driver, e.g. for custom types.
+	ValueConverter ValueConverter
+	// TinyintAsBool converts the values of all `tinyint` columns encoding booleans as 0 or 1 to bool,
+	// and TinyintAsBoolColumns converts only the columns in it.
+	TinyintAsBool        bool
+	TinyintAsBoolColumns []string
+	// ResultEncoding transcodes string values from UTF-8 to it, e.g. japanese.ShiftJIS for legacy systems.
+	// Values which can't be encoded are handled by UnconvertibleValueMode. Strings are UTF-8 if it's nil.
+	ResultEncoding encoding.Encoding
+	// TrimFields trims the surrounding whitespace of the values of string columns.
+	TrimFields bool
+	// TruncatedValueLength treats the values of API mode at least this many bytes long as truncated, if it's positive.
+	// GetQueryResults returns the values as strings without reporting whether they were cut off, and its API reference
+	// documents no maximum length of them, so it's the length the values of large string or complex columns are
+	// observed to be cut off at, see doc/result_mode.md. Truncated values are handled by UnconvertibleValueMode
+	// with ErrTruncatedValue, and logged to Logger as warnings. The result objects read in the other modes aren't truncated.
+	TruncatedValueLength int
+	// DecimalAsString returns the values of decimal columns as exact strings, e.g. "1.50" of `decimal(10,2)`,
+	// instead of float64, which loses the digits beyond its precision.
+	DecimalAsString bool
+	// ParseComplexTypes parses the values of array, map and row columns of API and DL mode, e.g. "[1, 2]"
+	// and "{a=1}", into []interface{} and map[string]interface{} of strings instead of returning the strings.
+	// Athena doesn't quote strings in them, so items containing ", ", "[", "]", "{", "}" or "=" are split,
+	// and an item "null" is nil. GZIP DL mode doesn't need it, since its objects delimit the items.
+	ParseComplexTypes bool
+	// NullString is the string of NULL in the objects of the CTAS table of GZIP DL mode, which defaults to `\N`,
+	// and the fields of the CSV of DL mode equal to it are also NULL if it's set.
+	NullString string
+
+	// DuplicateColumnMode is how duplicate column names are returned by Columns.
+	DuplicateColumnMode DuplicateColumnMode
+
+	// DownloadConcurrency is the maximum number of S3 objects downloaded concurrently
+	// in GZIP DL mode. Zero means the default of 8.
+	DownloadConcurrency int
+	// DownloadPrefetch is the maximum number of S3 objects downloaded ahead of reading rows
+	// in GZIP DL mode, which bounds memory for slow readers. Zero means DownloadConcurrency.
+	DownloadPrefetch int
+	// DownloadTimeout limits downloading the result in DL and GZIP DL mode instead of QueryTimeout,
+	// e.g. to allow big results a longer download without extending waiting for the query.
+	// In GZIP and PARQUET DL mode, it limits reading the manifest and each download of an S3 object,
+	// not reading all rows, so that slow readers don't fail. Zero means the timeout of the query.
+	DownloadTimeout time.Duration
+	// DownloadRetries is the number of times a failed S3 object is downloaded again in GZIP DL mode,
+	// so that a read resumes from the failed object instead of failing after the objects before it
+	// are downloaded. Zero means no retries.
+	DownloadRetries int
+	// ValidateETag makes GZIP DL mode fail with ErrResultObjectChanged when a result object
+	// is rewritten after the result is located.
+	ValidateETag bool
+	// CTASFormat is the format of the CTAS table of GZIP DL mode, "TEXTFILE" (default) or "PARQUET",
+	// and CTASCompression is its write_compression, e.g. "GZIP" or "NONE" for TEXTFILE,
+	// and "SNAPPY" (default), "GZIP" or "NONE" for PARQUET. TEXTFILE is compressed by Athena's default, GZIP,
+	// if it's empty. PARQUET DL mode always uses PARQUET.
+	CTASFormat      string
+	CTASCompression string
+	// CTASFieldDelimiter is the field_delimiter of the CTAS table of TEXTFILE, a tab or a punctuation,
+	// which is Athena's default, '\001', if it's empty. Values containing it aren't escaped.
+	CTASFieldDelimiter string
+	// CTASDatabase is the database the CTAS table of GZIP DL mode is created in, e.g. a scratch database
+	// for users who can't create tables in the database of their queries. The database of the query is used if it's empty.
+	// CTASTablePrefix is the prefix of the name of the CTAS table, "tmp_ctas_" if it's empty.
+	CTASDatabase    string
+	CTASTablePrefix string
+	// KeepCTASTableOnAbort keeps the CTAS table of GZIP DL mode when the rows are closed
+	// before all rows are read, so that the result can be read again with SetQueryExecutionID.
+	KeepCTASTableOnAbort bool
+
+	// QueryRewriter rewrites every query before it's run.
+	QueryRewriter QueryRewriter
+	// Logger receives the messages logged by the driver. Nothing is logged if it's nil.
+	Logger Logger
+	// AutoLimit appends `LIMIT AutoLimit` to SELECT queries which don't end with a LIMIT,
+	// e.g. for query editors. It's disabled if it's zero.
+	AutoLimit int
+	// MaxPages limits the number of pages of GetQueryResults read in API mode to bound its memory and time.
+	// Reading more pages fails with ErrMaxPagesExceeded, or ends the rows with TruncateAtMaxPages.
+	// It's unlimited if it's zero.
+	MaxPages           int
+	TruncateAtMaxPages bool
+	// MaxQueueTime stops queries which stay queued longer than it with ErrQueueTimeout.
+	// Queries can be queued until the timeout if it's zero.
+	MaxQueueTime time.Duration
+	// ScanWarningRatio warns through Logger about SELECT queries which scanned more than
+	// this many times the size of their result, which often means a missing partition filter.
+	// It's disabled if it's zero.
+	ScanWarningRatio float64
+	// Shadow runs SELECT queries also in another workgroup and reports whether the results match,
+	// e.g. to validate an engine version migration. It's disabled if it's nil.
+	Shadow *ShadowConfig
+	// ResultReuseMaxAge decides how old results of SELECT queries Athena may reuse.
+	// Results are never reused if it's nil.
+	ResultReuseMaxAge ResultReusePolicy
+
+	// CacheDir is the local directory to cache the results of SELECT queries in.
+	// The cache is disabled if it's empty.
+	CacheDir string
+	// CacheTTL is how long cached results are used. Zero means they never expire.
+	CacheTTL time.Duration
 }
 
+// ResultReusePolicy returns the maximum age of the results Athena may reuse for a query, e.g.
+// a minute for near-real-time dashboards and a day for reports. Zero disables the reuse.
+// The age is rounded up to minutes. Results are not reused in GZIP DL mode, which runs a CTAS query.
+type ResultReusePolicy func(ctx context.Context, query string) time.Duration
+
+// QueryRewriter rewrites a query before it's run, e.g. to add a LIMIT guard,
+// or to route table names to the environment. Returning an error aborts the query.
+// The query is passed without trailing semicolons.
+type QueryRewriter func(ctx context.Context, query string) (string, error)
+
 func configFromConnectionString(connStr string) (*Config, error) {
 	args, err := url.ParseQuery(connStr)
 	if err != nil {
@@ -160,11 +460,15 @@ func configFromConnectionString(connStr string) (*Config, error) {
 
 	var cfg Config
 
-	var acfg []*aws.Config
+	opts := session.Options{
+		Profile: args.Get("profile"),
+		// load ~/.aws/config so that SSO and assume role profiles can be used.
+		SharedConfigState: session.SharedConfigEnable,
+	}
 	if region := args.Get("region"); region != "" {
-		acfg = append(acfg, &aws.Config{Region: aws.String(region)})
+		opts.Config.Region = aws.String(region)
 	}
-	cfg.Session, err = session.NewSession(acfg...)
+	cfg.Session, err = session.NewSessionWithOptions(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -191,12 +495,219 @@ func configFromConnectionString(connStr string) (*Config, error) {
 		cfg.ResultMode = ResultModeDL
 	case modeValue == "gzip":
 		cfg.ResultMode = ResultModeGzipDL
+	case modeValue == "parquet":
+		cfg.ResultMode = ResultModeParquetDL
+	}
+
+	switch uv := strings.ToLower(args.Get("unconvertible_value")); uv {
+	case "", "error":
+		cfg.UnconvertibleValueMode = UnconvertibleValueModeError
+	case "raw":
+		cfg.UnconvertibleValueMode = UnconvertibleValueModeRawString
+	case "nil":
+		cfg.UnconvertibleValueMode = UnconvertibleValueModeNil
+	default:
+		return nil, fmt.Errorf("invalid unconvertible_value parameter: %s", uv)
+	}
+
+	if re := args.Get("result_encoding"); re != "" {
+		cfg.ResultEncoding, err = htmlindex.Get(re)
+		if err != nil {
+			return nil, fmt.Errorf("invalid result_encoding parameter: %s", re)
+		}
+	}
+
+	cfg.NullString = args.Get("null_string")
+
+	if tf := args.Get("trim_fields"); tf != "" {
+		cfg.TrimFields, err = strconv.ParseBool(tf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trim_fields parameter: %s", tf)
+		}
+	}
+
+	if tvl := args.Get("truncated_value_length"); tvl != "" {
+		cfg.TruncatedValueLength, err = strconv.Atoi(tvl)
+		if err != nil || cfg.TruncatedValueLength < 0 {
+			return nil, fmt.Errorf("invalid truncated_value_length parameter: %s", tvl)
+		}
+	}
+
+	if das := args.Get("decimal_as_string"); das != "" {
+		cfg.DecimalAsString, err = strconv.ParseBool(das)
+		if err != nil {
+			return nil, fmt.Errorf("invalid decimal_as_string parameter: %s", das)
+		}
+	}
+
+	if pct := args.Get("parse_complex_types"); pct != "" {
+		cfg.ParseComplexTypes, err = strconv.ParseBool(pct)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parse_complex_types parameter: %s", pct)
+		}
+	}
+
+	if tb := args.Get("tinyint_as_bool"); tb != "" {
+		if all, err := strconv.ParseBool(tb); err == nil {
+			cfg.TinyintAsBool = all
+		} else {
+			cfg.TinyintAsBoolColumns = strings.Split(tb, ",")
+		}
+	}
+
+	switch dc := strings.ToLower(args.Get("duplicate_columns")); dc {
+	case "", "keep":
+		cfg.DuplicateColumnMode = DuplicateColumnModeKeep
+	case "suffix":
+		cfg.DuplicateColumnMode = DuplicateColumnModeSuffix
+	case "qualify":
+		cfg.DuplicateColumnMode = DuplicateColumnModeQualify
+	default:
+		return nil, fmt.Errorf("invalid duplicate_columns parameter: %s", dc)
+	}
+
+	if dc := args.Get("download_concurrency"); dc != "" {
+		cfg.DownloadConcurrency, err = strconv.Atoi(dc)
+		if err != nil || cfg.DownloadConcurrency <= 0 {
+			return nil, fmt.Errorf("invalid download_concurrency parameter: %s", dc)
+		}
+	}
+
+	if dp := args.Get("download_prefetch"); dp != "" {
+		cfg.DownloadPrefetch, err = strconv.Atoi(dp)
+		if err != nil || cfg.DownloadPrefetch <= 0 {
+			return nil, fmt.Errorf("invalid download_prefetch parameter: %s", dp)
+		}
+	}
+
+	if ve := args.Get("validate_etag"); ve != "" {
+		cfg.ValidateETag, err = strconv.ParseBool(ve)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validate_etag parameter: %s", ve)
+		}
+	}
+
+	if kc := args.Get("keep_ctas_on_abort"); kc != "" {
+		cfg.KeepCTASTableOnAbort, err = strconv.ParseBool(kc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keep_ctas_on_abort parameter: %s", kc)
+		}
+	}
+
+	cfg.CTASFormat = args.Get("ctas_format")
+	cfg.CTASCompression = args.Get("ctas_compression")
+	cfg.CTASFieldDelimiter = args.Get("ctas_field_delimiter")
+	if _, err := newCTASFormat(cfg.CTASFormat, cfg.CTASCompression, cfg.CTASFieldDelimiter); err != nil {
+		return nil, fmt.Errorf("invalid ctas_format, ctas_compression or ctas_field_delimiter parameter: %w", err)
+	}
+	cfg.CTASDatabase = args.Get("ctas_database")
+	cfg.CTASTablePrefix = args.Get("ctas_table_prefix")
+	if _, err := newCTASTableOptions(cfg.CTASDatabase, cfg.CTASTablePrefix); err != nil {
+		return nil, fmt.Errorf("invalid ctas_database or ctas_table_prefix parameter: %w", err)
+	}
+
+	cfg.CacheDir = args.Get("cache_dir")
+	if ttl := args.Get("cache_ttl"); ttl != "" {
+		cfg.CacheTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache_ttl parameter: %s", ttl)
+		}
+	}
+
+	if strict := args.Get("strict_result_mode"); strict != "" {
+		cfg.StrictResultMode, err = strconv.ParseBool(strict)
+		if err != nil {
+			return nil, fmt.Errorf("invalid strict_result_mode parameter: %s", strict)
+		}
+	}
+
+	if ro := args.Get("read_only"); ro != "" {
+		cfg.ReadOnly, err = strconv.ParseBool(ro)
+		if err != nil {
+			return nil, fmt.Errorf("invalid read_only parameter: %s", ro)
+		}
+	}
+
+	if hc := args.Get("health_check"); hc != "" {
+		cfg.HealthCheck, err = strconv.ParseBool(hc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid health_check parameter: %s", hc)
+		}
+	}
+
+	if cv := args.Get("cache_valid_queries"); cv != "" {
+		cfg.CacheValidQueries, err = strconv.ParseBool(cv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache_valid_queries parameter: %s", cv)
+		}
+	}
+
+	if qt := args.Get("query_tags"); qt != "" {
+		cfg.QueryTags = make(map[string]string)
+		for _, pair := range strings.Split(qt, ",") {
+			k, v, ok := strings.Cut(pair, ":")
+			if !ok || k == "" {
+				return nil, fmt.Errorf("invalid query_tags parameter: %s", qt)
+			}
+			cfg.QueryTags[k] = v
+		}
+	}
+
+	if dns := args.Get("download_non_select"); dns != "" {
+		cfg.DownloadNonSelect, err = strconv.ParseBool(dns)
+		if err != nil {
+			return nil, fmt.Errorf("invalid download_non_select parameter: %s", dns)
+		}
 	}
 
-	cfg.Timeout = timeOutLimitDefault
 	if tm := args.Get("timeout"); tm != "" {
-		if timeout, err := strconv.ParseUint(tm, 10, 32); err != nil {
-			cfg.Timeout = uint(timeout)
+		// seconds for compatibility, or a duration
+		if sec, err := strconv.ParseUint(tm, 10, 32); err == nil {
+			cfg.QueryTimeout = time.Duration(sec) * time.Second
+		} else if cfg.QueryTimeout, err = time.ParseDuration(tm); err != nil {
+			return nil, fmt.Errorf("invalid timeout parameter: %s", tm)
+		}
+	}
+
+	if dt := args.Get("download_timeout"); dt != "" {
+		cfg.DownloadTimeout, err = time.ParseDuration(dt)
+		if err != nil || cfg.DownloadTimeout < 0 {
+			return nil, fmt.Errorf("invalid download_timeout parameter: %s", dt)
+		}
+	}
+
+	if dr := args.Get("download_retries"); dr != "" {
+		cfg.DownloadRetries, err = strconv.Atoi(dr)
+		if err != nil || cfg.DownloadRetries < 0 {
+			return nil, fmt.Errorf("invalid download_retries parameter: %s", dr)
+		}
+	}
+
+	if al := args.Get("auto_limit"); al != "" {
+		cfg.AutoLimit, err = strconv.Atoi(al)
+		if err != nil || cfg.AutoLimit < 0 {
+			return nil, fmt.Errorf("invalid auto_limit parameter: %s", al)
+		}
+	}
+
+	if mp := args.Get("max_pages"); mp != "" {
+		cfg.MaxPages, err = strconv.Atoi(mp)
+		if err != nil || cfg.MaxPages < 0 {
+			return nil, fmt.Errorf("invalid max_pages parameter: %s", mp)
+		}
+	}
+
+	if tr := args.Get("truncate_at_max_pages"); tr != "" {
+		cfg.TruncateAtMaxPages, err = strconv.ParseBool(tr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid truncate_at_max_pages parameter: %s", tr)
+		}
+	}
+
+	if mq := args.Get("max_queue_time"); mq != "" {
+		cfg.MaxQueueTime, err = time.ParseDuration(mq)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_queue_time parameter: %s", mq)
 		}
 	}
 
@@ -208,6 +719,14 @@ func configFromConnectionString(connStr string) (*Config, error) {
 	return &cfg, nil
 }
 
+// queryTimeout returns QueryTimeout, falling back to Timeout. Zero means no limit is set.
+func (c *Config) queryTimeout() time.Duration {
+	if c.QueryTimeout > 0 {
+		return c.QueryTimeout
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
 // checkOutputLocation is to check if outputLocation should be obtained from workgroup.
 func checkOutputLocation(resultMode ResultMode, outputLocation string) bool {
 	return resultMode != ResultModeAPI && outputLocation == ""
@@ -0,0 +1,116 @@
+package athena
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_configFromConnectionString_Timeout(t *testing.T) {
+	tests := []struct {
+		timeout  string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"90", 90 * time.Second},
+		{"2h", 2 * time.Hour},
+	}
+	for _, test := range tests {
+		cfg, err := configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&timeout=" + test.timeout)
+		require.NoError(t, err, test.timeout)
+		assert.Equal(t, test.expected, cfg.queryTimeout(), test.timeout)
+	}
+
+	_, err := configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&timeout=abc")
+	assert.Error(t, err)
+}
+
+func TestConfig_queryTimeout(t *testing.T) {
+	assert.Equal(t, time.Duration(0), (&Config{}).queryTimeout())
+	assert.Equal(t, 10*time.Second, (&Config{Timeout: 10}).queryTimeout())
+	assert.Equal(t, time.Minute, (&Config{Timeout: 10, QueryTimeout: time.Minute}).queryTimeout())
+}
+
+func Test_configFromConnectionString_CTASFormat(t *testing.T) {
+	cfg, err := configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&ctas_format=parquet&ctas_compression=gzip")
+	require.NoError(t, err)
+	assert.Equal(t, "parquet", cfg.CTASFormat)
+	assert.Equal(t, "gzip", cfg.CTASCompression)
+
+	_, err = configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&ctas_format=orc")
+	assert.Error(t, err)
+
+	cfg, err = configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&ctas_field_delimiter=%09")
+	require.NoError(t, err)
+	assert.Equal(t, "\t", cfg.CTASFieldDelimiter)
+
+	_, err = configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&ctas_format=parquet&ctas_field_delimiter=,")
+	assert.Error(t, err)
+}
+
+func Test_configFromConnectionString_CTASTable(t *testing.T) {
+	cfg, err := configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&ctas_database=scratch&ctas_table_prefix=tmp_go_")
+	require.NoError(t, err)
+	assert.Equal(t, "scratch", cfg.CTASDatabase)
+	assert.Equal(t, "tmp_go_", cfg.CTASTablePrefix)
+
+	_, err = configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&ctas_database=scratch;drop")
+	assert.Error(t, err)
+}
+
+func Test_configFromConnectionString_DecimalAsString(t *testing.T) {
+	cfg, err := configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&decimal_as_string=true")
+	require.NoError(t, err)
+	assert.True(t, cfg.DecimalAsString)
+
+	_, err = configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&decimal_as_string=yes")
+	assert.Error(t, err)
+}
+
+func Test_configFromConnectionString_ParseComplexTypes(t *testing.T) {
+	cfg, err := configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&parse_complex_types=true")
+	require.NoError(t, err)
+	assert.True(t, cfg.ParseComplexTypes)
+
+	_, err = configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&parse_complex_types=yes")
+	assert.Error(t, err)
+}
+
+func Test_configFromConnectionString_TruncatedValueLength(t *testing.T) {
+	cfg, err := configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&truncated_value_length=32767")
+	require.NoError(t, err)
+	assert.Equal(t, 32767, cfg.TruncatedValueLength)
+
+	_, err = configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&truncated_value_length=-1")
+	assert.Error(t, err)
+}
+
+func Test_configFromConnectionString_QueryTags(t *testing.T) {
+	cfg, err := configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&query_tags=team:data,app:etl")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "data", "app": "etl"}, cfg.QueryTags)
+
+	_, err = configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&query_tags=team")
+	assert.Error(t, err)
+}
+
+func Test_configFromConnectionString_DownloadRetries(t *testing.T) {
+	cfg, err := configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&download_retries=3")
+	require.NoError(t, err)
+	assert.Equal(t, 3, cfg.DownloadRetries)
+
+	_, err = configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&download_retries=-1")
+	assert.Error(t, err)
+}
+
+func Test_configFromConnectionString_DownloadTimeout(t *testing.T) {
+	cfg, err := configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&timeout=60&download_timeout=2h")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, cfg.QueryTimeout)
+	assert.Equal(t, 2*time.Hour, cfg.DownloadTimeout)
+
+	_, err = configFromConnectionString("db=db&output_location=s3://bucket&region=us-east-1&download_timeout=60")
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+package athena
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrResultModeMismatch is returned in strict result mode when the result mode
+	// requested in context cannot be used for the query.
+	ErrResultModeMismatch = errors.New("result mode is not supported for this query")
+
+	// ErrMultipleStatements is returned when a query has multiple statements separated by semicolons,
+	// since Athena runs only a single statement per execution.
+	ErrMultipleStatements = errors.New("multiple statements are not supported")
+
+	// ErrReadOnly is returned in read-only mode when a query may modify data or metadata.
+	ErrReadOnly = errors.New("query is not allowed in read-only mode")
+
+	// ErrQueueTimeout is returned when a query stays queued longer than the max queue time,
+	// e.g. when the concurrency of the workgroup is saturated. The query is stopped.
+	ErrQueueTimeout = errors.New("query was queued too long")
+
+	// ErrBytesScannedCutoffExceeded is matched by errors.Is for BytesScannedCutoffExceededError.
+	ErrBytesScannedCutoffExceeded = errors.New("bytes scanned cutoff per query exceeded")
+
+	// ErrResultObjectChanged is returned with ETag validation when a result object was rewritten
+	// or removed after the result was located.
+	ErrResultObjectChanged = errors.New("result object changed while reading")
+
+	// ErrMaxPagesExceeded is returned in API mode when the result has more pages than Config.MaxPages.
+	ErrMaxPagesExceeded = errors.New("result has more pages than the max pages")
+
+	// ErrTruncatedValue is the error of a value of API mode which is as long as Config.TruncatedValueLength,
+	// and may have been cut off by GetQueryResults.
+	ErrTruncatedValue = errors.New("value may be truncated")
+)
+
+// BytesScannedCutoffExceededError is returned when Athena cancels a query because it
+// scanned more data than BytesScannedCutoffPerQuery of the workgroup.
+type BytesScannedCutoffExceededError struct {
+	QueryID string
+	// Cutoff is BytesScannedCutoffPerQuery of the workgroup. It's zero if it couldn't be obtained.
+	Cutoff int64
+	Reason string
+}
+
+func (e *BytesScannedCutoffExceededError) Error() string {
+	return fmt.Sprintf("%s (cutoff: %d bytes): %s", ErrBytesScannedCutoffExceeded, e.Cutoff, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrBytesScannedCutoffExceeded) true.
+func (e *BytesScannedCutoffExceededError) Is(target error) bool {
+	return target == ErrBytesScannedCutoffExceeded
+}
@@ -1,9 +1,17 @@
 module github.com/speee/go-athena
 
-go 1.14
+go 1.19
 
 require (
-	github.com/aws/aws-sdk-go v1.35.20
+	github.com/aws/aws-sdk-go v1.55.8
 	github.com/satori/go.uuid v1.2.0
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/text v0.22.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
@@ -0,0 +1,406 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// errNotAthenaConn is returned when a helper is called with a *sql.DB which isn't opened by this driver.
+var errNotAthenaConn = errors.New("the connection is not an athena connection")
+
+// withConn calls fn with the athena connection held by db.
+func withConn(ctx context.Context, db *sql.DB, fn func(c *conn) error) error {
+	sqlConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer sqlConn.Close()
+
+	return sqlConn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*conn)
+		if !ok {
+			return errNotAthenaConn
+		}
+		return fn(c)
+	})
+}
+
+// QueryCount runs a query returning a single scalar, e.g. `SELECT COUNT(*) FROM t WHERE ...`,
+// and returns it as int64. Since the result is a single row, the query is always run in API mode.
+// It returns an error if the scalar is NULL, e.g. `SUM` of no rows, or isn't an integer in the range of int64,
+// instead of returning a count which isn't the result; use `COALESCE` for the count of NULL.
+func QueryCount(ctx context.Context, db *sql.DB, query string) (int64, error) {
+	var val interface{}
+	if err := db.QueryRowContext(SetAPIMode(ctx), query).Scan(&val); err != nil {
+		return 0, err
+	}
+	return countValue(val)
+}
+
+// countValue converts the scalar of QueryCount to int64.
+func countValue(val interface{}) (int64, error) {
+	switch v := val.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		// -2^63 and 2^63 are exact as float64, and the latter is out of the range.
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= -math.MinInt64 {
+			return 0, fmt.Errorf("cannot convert %v to count", v)
+		}
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case nil:
+		return 0, errors.New("count is NULL")
+	default:
+		return 0, fmt.Errorf("cannot convert %T to count", val)
+	}
+}
+
+// QueryAll runs a query and returns all of its rows converted as by Next, with the column names.
+// It reads the driver rows directly, without the Scan of database/sql for every row,
+// e.g. for bulk ingestion processing the rows in its own loop. All of the rows are held in memory.
+func QueryAll(ctx context.Context, db *sql.DB, query string) ([][]driver.Value, []string, error) {
+	var ret [][]driver.Value
+	var columns []string
+	err := withConn(ctx, db, func(c *conn) error {
+		rows, err := c.QueryContext(ctx, query, nil)
+		if err != nil {
+			return err
+		}
+
+		columns = rows.Columns()
+		for {
+			dest := make([]driver.Value, len(columns))
+			err := rows.Next(dest)
+			if err == io.EOF {
+				return rows.Close()
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			ret = append(ret, dest)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ret, columns, nil
+}
+
+// ValidateQuery checks that a query compiles by running EXPLAIN of it, which doesn't scan data.
+// It returns nil if the query is valid, or the error reported by Athena.
+// No result rows are fetched.
+// With Config.CacheValidQueries, a query found valid isn't run again in the same catalog and database.
+// The query is rewritten by Config.QueryRewriter first, as the one run by db.Query is.
+func ValidateQuery(ctx context.Context, db *sql.DB, query string) error {
+	return withConn(ctx, db, func(c *conn) error {
+		query, err := c.rewriteQuery(ctx, query)
+		if err != nil {
+			return err
+		}
+		catalog, database := c.getQueryContext(ctx)
+		hash := validQueryHash(catalog, database, query)
+		if c.validQueries.contains(hash) {
+			return nil
+		}
+
+		queryID, err := c.startQuery(ctx, "EXPLAIN "+query, startQueryOptions{})
+		if err != nil {
+			return err
+		}
+
+		if _, err = c.waitOnQueryTimeout(ctx, queryID, c.getTimeout(ctx), nil); err != nil {
+			return err
+		}
+		c.validQueries.add(hash)
+		return nil
+	})
+}
+
+// StartOrReattachQuery starts the query with the client request token, or returns the ID of the query
+// already started with the token, e.g. to reattach to it after the process restarted.
+// The query is started as it is, only with the tags of the connection and ctx, and it isn't rewritten
+// by Config.QueryRewriter, auto_limit or GZIP DL mode as queries of db.Query are.
+//
+// Athena doesn't report the token of query executions, so the query is looked up by starting it
+// with the same token again, which is idempotent as long as the query is the same. So queries to be
+// reattached should be started by StartOrReattachQuery in the first place, since the query sent by db.Query
+// with SetClientRequestToken differs from the one of the user, and Athena fails with the token of another query.
+// If no query was started with the token, the query is started.
+func StartOrReattachQuery(ctx context.Context, db *sql.DB, query, token string) (string, error) {
+	if token == "" {
+		return "", errors.New("client request token is empty")
+	}
+
+	var queryID string
+	err := withConn(ctx, db, func(c *conn) error {
+		if err := c.checkReadOnly(normalizeQuery(query)); err != nil {
+			return err
+		}
+
+		var err error
+		queryID, err = c.startQuery(ctx, normalizeQuery(query), startQueryOptions{clientRequestToken: token})
+		return err
+	})
+	return queryID, err
+}
+
+var insertQueryRegex = regexp.MustCompile(`(?i)^INSERT\s+INTO\s`)
+
+// InsertResult is the result of InsertInto.
+type InsertResult struct {
+	QueryID     string
+	RowsWritten int64
+	// DataManifestLocation is the S3 location of the manifest file listing the files written by the query.
+	DataManifestLocation string
+	DataScannedInBytes   int64
+}
+
+// InsertInto runs an `INSERT INTO target SELECT ...` query, waits for it,
+// and returns the number of rows written and the files written by it.
+// The query is rewritten by Config.QueryRewriter first, as the one run by db.Exec is.
+func InsertInto(ctx context.Context, db *sql.DB, query string) (*InsertResult, error) {
+	var ret *InsertResult
+	err := withConn(ctx, db, func(c *conn) error {
+		query, err := c.rewriteQuery(ctx, query)
+		if err != nil {
+			return err
+		}
+		if !insertQueryRegex.MatchString(stripLeadingComments(query)) {
+			return errors.New("query is not INSERT INTO")
+		}
+		if err := c.checkReadOnly(query); err != nil {
+			return err
+		}
+
+		queryID, err := c.startQuery(ctx, query, startQueryOptions{clientRequestToken: getClientRequestToken(ctx)})
+		if err != nil {
+			return err
+		}
+
+		qe, err := c.waitOnQueryTimeout(ctx, queryID, c.getTimeout(ctx), nil)
+		if err != nil {
+			return err
+		}
+
+		// the number of rows written is reported only by GetQueryResults.
+		out, err := c.athena.GetQueryResults(&athena.GetQueryResultsInput{
+			QueryExecutionId: aws.String(queryID),
+			MaxResults:       aws.Int64(1),
+		})
+		if err != nil {
+			return err
+		}
+
+		var stats QueryStats
+		stats.setQueryExecution(qe)
+		ret = &InsertResult{
+			QueryID:              queryID,
+			RowsWritten:          aws.Int64Value(out.UpdateCount),
+			DataManifestLocation: stats.DataManifestLocation,
+			DataScannedInBytes:   stats.DataScannedInBytes,
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// UnloadResult is the result of Unload.
+type UnloadResult struct {
+	QueryID string
+	// DataManifestLocation is the S3 location of the manifest file listing the objects written by the query.
+	DataManifestLocation string
+	// Objects are the S3 locations of the objects written by the query, e.g. Parquet files.
+	Objects            []string
+	DataScannedInBytes int64
+}
+
+// Unload runs an `UNLOAD (SELECT ...) TO 's3://...' WITH (...)` query, waits for it,
+// and returns the objects written by it, which are read from its manifest.
+// The query is rewritten by Config.QueryRewriter first, as the one run by db.Exec is.
+func Unload(ctx context.Context, db *sql.DB, query string) (*UnloadResult, error) {
+	var ret *UnloadResult
+	err := withConn(ctx, db, func(c *conn) error {
+		query, err := c.rewriteQuery(ctx, query)
+		if err != nil {
+			return err
+		}
+		if getQueryType(query) != queryTypeUnload {
+			return errors.New("query is not UNLOAD")
+		}
+		if err := c.checkReadOnly(query); err != nil {
+			return err
+		}
+
+		queryID, err := c.startQuery(ctx, query, startQueryOptions{clientRequestToken: getClientRequestToken(ctx)})
+		if err != nil {
+			return err
+		}
+
+		qe, err := c.waitOnQueryTimeout(ctx, queryID, c.getTimeout(ctx), nil)
+		if err != nil {
+			return err
+		}
+
+		var stats QueryStats
+		stats.setQueryExecution(qe)
+		objects, err := readManifest(ctx, c.s3, stats.DataManifestLocation)
+		if err != nil {
+			return err
+		}
+
+		ret = &UnloadResult{
+			QueryID:              queryID,
+			DataManifestLocation: stats.DataManifestLocation,
+			Objects:              objects,
+			DataScannedInBytes:   stats.DataScannedInBytes,
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// QueryExecutionDetails is the record of a query execution.
+// It's a flattened view of athena.QueryExecution which doesn't depend on the SDK types.
+type QueryExecutionDetails struct {
+	QueryID       string
+	Query         string
+	StatementType string
+	// SubstatementType is the type of the statement, e.g. SELECT, INSERT or CREATE_TABLE_AS_SELECT.
+	SubstatementType string
+
+	State             string
+	StateChangeReason string
+	SubmittedAt       time.Time
+	// CompletedAt is zero while the query is running.
+	CompletedAt time.Time
+
+	Catalog        string
+	Database       string
+	WorkGroup      string
+	OutputLocation string
+	EngineVersion  string
+
+	// ResultReused reports whether the result of a previous execution was reused.
+	ResultReused bool
+	Stats        QueryStats
+}
+
+// GetQueryExecutionDetails returns the record of the query execution queryID.
+func GetQueryExecutionDetails(ctx context.Context, db *sql.DB, queryID string) (*QueryExecutionDetails, error) {
+	var ret *QueryExecutionDetails
+	err := withConn(ctx, db, func(c *conn) error {
+		out, err := c.athena.GetQueryExecutionWithContext(ctx, &athena.GetQueryExecutionInput{
+			QueryExecutionId: aws.String(queryID),
+		})
+		if err != nil {
+			return err
+		}
+		ret = newQueryExecutionDetails(out.QueryExecution)
+		return nil
+	})
+	return ret, err
+}
+
+// ResultColumn is the metadata of a column of a query result.
+// It's a flattened view of athena.ColumnInfo which doesn't depend on the SDK types.
+type ResultColumn struct {
+	Name  string
+	Label string
+	// Type is the Athena type of the column, e.g. varchar, bigint or decimal.
+	Type string
+	// Precision and Scale are those of decimal columns, and Precision is also the length of the others, e.g. varchar.
+	Precision int64
+	Scale     int64
+	// Nullable is NOT_NULL, NULLABLE or UNKNOWN.
+	Nullable      string
+	CaseSensitive bool
+
+	CatalogName string
+	SchemaName  string
+	TableName   string
+}
+
+// Computed is whether the column is a computed expression rather than a column of a table,
+// which is told by the missing table and schema of its ColumnInfo.
+func (c ResultColumn) Computed() bool {
+	return c.TableName == "" && c.SchemaName == ""
+}
+
+// GetResultMetadata returns the columns of the result of the completed query execution queryID
+// without reading its rows, e.g. to show the schema of a result in a query editor before it's fetched.
+// Only the first page of a single row is requested from GetQueryResults.
+func GetResultMetadata(ctx context.Context, db *sql.DB, queryID string) ([]ResultColumn, error) {
+	var ret []ResultColumn
+	err := withConn(ctx, db, func(c *conn) error {
+		out, err := c.athena.GetQueryResults(&athena.GetQueryResultsInput{
+			QueryExecutionId: aws.String(queryID),
+			MaxResults:       aws.Int64(1),
+		})
+		if err != nil {
+			return err
+		}
+		if out.ResultSet == nil || out.ResultSet.ResultSetMetadata == nil {
+			return nil
+		}
+
+		for _, col := range out.ResultSet.ResultSetMetadata.ColumnInfo {
+			ret = append(ret, ResultColumn{
+				Name:          aws.StringValue(col.Name),
+				Label:         aws.StringValue(col.Label),
+				Type:          aws.StringValue(col.Type),
+				Precision:     aws.Int64Value(col.Precision),
+				Scale:         aws.Int64Value(col.Scale),
+				Nullable:      aws.StringValue(col.Nullable),
+				CaseSensitive: aws.BoolValue(col.CaseSensitive),
+				CatalogName:   aws.StringValue(col.CatalogName),
+				SchemaName:    aws.StringValue(col.SchemaName),
+				TableName:     aws.StringValue(col.TableName),
+			})
+		}
+		return nil
+	})
+	return ret, err
+}
+
+func newQueryExecutionDetails(qe *athena.QueryExecution) *QueryExecutionDetails {
+	d := &QueryExecutionDetails{
+		QueryID:          aws.StringValue(qe.QueryExecutionId),
+		Query:            aws.StringValue(qe.Query),
+		StatementType:    aws.StringValue(qe.StatementType),
+		SubstatementType: aws.StringValue(qe.SubstatementType),
+		WorkGroup:        aws.StringValue(qe.WorkGroup),
+		OutputLocation:   resultLocation(qe),
+	}
+	if st := qe.Status; st != nil {
+		d.State = aws.StringValue(st.State)
+		d.StateChangeReason = aws.StringValue(st.StateChangeReason)
+		d.SubmittedAt = aws.TimeValue(st.SubmissionDateTime)
+		d.CompletedAt = aws.TimeValue(st.CompletionDateTime)
+	}
+	if ec := qe.QueryExecutionContext; ec != nil {
+		d.Catalog = aws.StringValue(ec.Catalog)
+		d.Database = aws.StringValue(ec.Database)
+	}
+	if ev := qe.EngineVersion; ev != nil {
+		d.EngineVersion = aws.StringValue(ev.EffectiveEngineVersion)
+	}
+	if st := qe.Statistics; st != nil && st.ResultReuseInformation != nil {
+		d.ResultReused = aws.BoolValue(st.ResultReuseInformation.ReusedPreviousResult)
+	}
+	d.Stats.setQueryExecution(qe)
+	return d
+}
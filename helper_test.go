@@ -0,0 +1,297 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockConnector is a driver.Connector which always returns c.
+type mockConnector struct {
+	c *conn
+}
+
+func (m *mockConnector) Connect(context.Context) (driver.Conn, error) {
+	return m.c, nil
+}
+
+func (m *mockConnector) Driver() driver.Driver {
+	return &Driver{}
+}
+
+func openMockDB(t *testing.T, c *conn) *sql.DB {
+	db := sql.OpenDB(&mockConnector{c: c})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestQueryCount(t *testing.T) {
+	db := openMockDB(t, &conn{
+		athena:     &mockAthenaConnClient{queryID: "count"},
+		resultMode: ResultModeGzipDL,
+	})
+
+	cnt, err := QueryCount(context.Background(), db, "SELECT COUNT(*) FROM foo")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), cnt)
+}
+
+func Test_countValue(t *testing.T) {
+	for _, val := range []interface{}{int64(42), float64(42), "42"} {
+		cnt, err := countValue(val)
+		require.NoError(t, err)
+		assert.Equal(t, int64(42), cnt)
+	}
+	cnt, err := countValue(float64(-1 << 63))
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MinInt64), cnt)
+
+	// it isn't truncated or overflowed, and NULL isn't 0.
+	for _, val := range []interface{}{1.5, float64(1 << 63), math.Inf(1), math.NaN(), nil, true} {
+		_, err := countValue(val)
+		assert.Error(t, err, "%v", val)
+	}
+}
+
+func TestQueryAll(t *testing.T) {
+	db := openMockDB(t, &conn{athena: &mockAthenaConnClient{queryID: "select"}})
+
+	rows, columns, err := QueryAll(context.Background(), db, "SELECT * FROM foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first_name", "last_name"}, columns)
+	require.Len(t, rows, 9)
+	for _, row := range rows {
+		assert.Len(t, row, 2)
+	}
+
+	_, _, err = QueryAll(context.Background(), openMockDB(t, &conn{athena: &mockAthenaConnClient{state: athena.QueryExecutionStateFailed}}), "SELECT * FROM foo")
+	assert.Error(t, err)
+}
+
+func TestValidateQuery(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "explain"}
+	db := openMockDB(t, &conn{athena: client})
+
+	require.NoError(t, ValidateQuery(context.Background(), db, "SELECT * FROM foo;"))
+	require.Len(t, client.started, 1)
+	assert.Equal(t, "EXPLAIN SELECT * FROM foo", *client.started[0].QueryString)
+
+	client.state = athena.QueryExecutionStateFailed
+	client.reason = "SYNTAX_ERROR: line 1:15: Table awsdatacatalog.default.foo does not exist"
+	err := ValidateQuery(context.Background(), db, "SELECT * FROM foo")
+	assert.EqualError(t, err, client.reason)
+}
+
+func TestValidateQuery_CacheValidQueries(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "explain"}
+	db := openMockDB(t, &conn{athena: client, db: "analytics", validQueries: newValidQueries()})
+
+	// a valid query is run once in each database.
+	require.NoError(t, ValidateQuery(context.Background(), db, "SELECT * FROM foo"))
+	require.NoError(t, ValidateQuery(context.Background(), db, "SELECT * FROM foo;"))
+	assert.Len(t, client.started, 1)
+	require.NoError(t, ValidateQuery(SetQueryContext(context.Background(), "", "sales"), db, "SELECT * FROM foo"))
+	assert.Len(t, client.started, 2)
+
+	// an invalid query is run again.
+	client.state = athena.QueryExecutionStateFailed
+	assert.Error(t, ValidateQuery(context.Background(), db, "SELECT * FROM bar"))
+	assert.Error(t, ValidateQuery(context.Background(), db, "SELECT * FROM bar"))
+	assert.Len(t, client.started, 4)
+}
+
+func TestStartOrReattachQuery(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "explain"}
+	db := openMockDB(t, &conn{athena: client, autoLimit: 10, queryTags: map[string]string{"app": "test"}})
+
+	_, err := StartOrReattachQuery(context.Background(), db, "SELECT 1", "")
+	assert.Error(t, err)
+
+	// the query is sent in the same way every time, so that the token matches it.
+	token := "0123456789abcdef0123456789abcdef"
+	for i := 0; i < 2; i++ {
+		queryID, err := StartOrReattachQuery(context.Background(), db, "SELECT * FROM foo;", token)
+		require.NoError(t, err)
+		assert.Equal(t, "explain", queryID)
+	}
+	require.Len(t, client.started, 2)
+	for _, started := range client.started {
+		assert.Equal(t, token, *started.ClientRequestToken)
+		assert.Equal(t, "/* tags: app=test */\nSELECT * FROM foo", *started.QueryString)
+	}
+}
+
+func TestClientRequestToken(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "count"}
+	db := openMockDB(t, &conn{athena: client})
+
+	token := "0123456789abcdef0123456789abcdef"
+	_, err := QueryCount(SetClientRequestToken(context.Background(), token), db, "SELECT COUNT(*) FROM foo")
+	require.NoError(t, err)
+	_, err = QueryCount(context.Background(), db, "SELECT COUNT(*) FROM foo")
+	require.NoError(t, err)
+
+	require.Len(t, client.started, 2)
+	assert.Equal(t, token, *client.started[0].ClientRequestToken)
+	assert.Nil(t, client.started[1].ClientRequestToken)
+}
+
+func TestInsertInto(t *testing.T) {
+	queryToResultsGenMap["insert"] = func(string) (*athena.GetQueryResultsOutput, error) {
+		return &athena.GetQueryResultsOutput{
+			ResultSet:   &athena.ResultSet{ResultSetMetadata: &athena.ResultSetMetadata{}},
+			UpdateCount: aws.Int64(123),
+		}, nil
+	}
+	defer delete(queryToResultsGenMap, "insert")
+
+	client := &mockAthenaConnClient{
+		queryID: "insert",
+		statistics: &athena.QueryExecutionStatistics{
+			DataScannedInBytes:   aws.Int64(2048),
+			DataManifestLocation: aws.String("s3://bucket/insert-manifest.csv"),
+		},
+	}
+	db := openMockDB(t, &conn{athena: client})
+
+	_, err := InsertInto(context.Background(), db, "SELECT 1")
+	assert.Error(t, err)
+	assert.Empty(t, client.started)
+
+	res, err := InsertInto(context.Background(), db, "INSERT INTO target SELECT * FROM source;")
+	require.NoError(t, err)
+	assert.Equal(t, &InsertResult{
+		QueryID:              "insert",
+		RowsWritten:          123,
+		DataManifestLocation: "s3://bucket/insert-manifest.csv",
+		DataScannedInBytes:   2048,
+	}, res)
+	assert.Equal(t, "INSERT INTO target SELECT * FROM source", *client.started[0].QueryString)
+}
+
+func TestInsertInto_Timeout(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "insert", state: athena.QueryExecutionStateRunning}
+	db := openMockDB(t, &conn{athena: client, pollFrequency: time.Millisecond, timeout: 20 * time.Millisecond})
+
+	_, err := InsertInto(context.Background(), db, "INSERT INTO target SELECT * FROM source")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Len(t, client.stopped, 1)
+}
+
+func TestUnload(t *testing.T) {
+	client := &mockAthenaConnClient{
+		queryID: "show",
+		statistics: &athena.QueryExecutionStatistics{
+			DataScannedInBytes:   aws.Int64(2048),
+			DataManifestLocation: aws.String("s3://bucket/show-manifest.csv"),
+		},
+	}
+	s3Client := &mockS3Client{objects: map[string][]byte{
+		"bucket/show-manifest.csv": []byte("s3://bucket/unload/a.parquet\ns3://bucket/unload/b.parquet\n"),
+	}}
+	db := openMockDB(t, &conn{athena: client, s3: s3Client})
+
+	_, err := Unload(context.Background(), db, "SELECT 1")
+	assert.Error(t, err)
+	assert.Empty(t, client.started)
+
+	query := "UNLOAD (SELECT * FROM source) TO 's3://bucket/unload/' WITH (format='PARQUET')"
+	res, err := Unload(context.Background(), db, query+";")
+	require.NoError(t, err)
+	assert.Equal(t, &UnloadResult{
+		QueryID:              "show",
+		DataManifestLocation: "s3://bucket/show-manifest.csv",
+		Objects:              []string{"s3://bucket/unload/a.parquet", "s3://bucket/unload/b.parquet"},
+		DataScannedInBytes:   2048,
+	}, res)
+	assert.Equal(t, query, *client.started[0].QueryString)
+}
+
+func TestHelpers_QueryRewriter(t *testing.T) {
+	queryToResultsGenMap["insert"] = func(string) (*athena.GetQueryResultsOutput, error) {
+		return &athena.GetQueryResultsOutput{ResultSet: &athena.ResultSet{ResultSetMetadata: &athena.ResultSetMetadata{}}}, nil
+	}
+	defer delete(queryToResultsGenMap, "insert")
+
+	client := &mockAthenaConnClient{
+		queryID:    "insert",
+		statistics: &athena.QueryExecutionStatistics{DataManifestLocation: aws.String("s3://bucket/manifest.csv")},
+	}
+	db := openMockDB(t, &conn{
+		athena: client,
+		s3:     &mockS3Client{objects: map[string][]byte{"bucket/manifest.csv": []byte("")}},
+		queryRewriter: func(_ context.Context, query string) (string, error) {
+			return strings.ReplaceAll(query, "FROM source", "FROM prod.source"), nil
+		},
+	})
+
+	require.NoError(t, ValidateQuery(context.Background(), db, "SELECT * FROM source"))
+	_, err := InsertInto(context.Background(), db, "INSERT INTO target SELECT * FROM source")
+	require.NoError(t, err)
+	_, err = Unload(context.Background(), db, "UNLOAD (SELECT * FROM source) TO 's3://bucket/unload/' WITH (format='PARQUET')")
+	require.NoError(t, err)
+
+	require.Len(t, client.started, 3)
+	assert.Equal(t, "EXPLAIN SELECT * FROM prod.source", *client.started[0].QueryString)
+	assert.Equal(t, "INSERT INTO target SELECT * FROM prod.source", *client.started[1].QueryString)
+	assert.Equal(t, "UNLOAD (SELECT * FROM prod.source) TO 's3://bucket/unload/' WITH (format='PARQUET')", *client.started[2].QueryString)
+}
+
+func TestGetQueryExecutionDetails(t *testing.T) {
+	client := &mockAthenaConnClient{
+		query:    "SELECT * FROM source",
+		location: "s3://bucket/done.csv",
+		statistics: &athena.QueryExecutionStatistics{
+			DataScannedInBytes:     aws.Int64(4096),
+			ResultReuseInformation: &athena.ResultReuseInformation{ReusedPreviousResult: aws.Bool(true)},
+		},
+	}
+	db := openMockDB(t, &conn{athena: client})
+
+	d, err := GetQueryExecutionDetails(context.Background(), db, "done")
+	require.NoError(t, err)
+	assert.Equal(t, "done", d.QueryID)
+	assert.Equal(t, "SELECT * FROM source", d.Query)
+	assert.Equal(t, athena.QueryExecutionStateSucceeded, d.State)
+	assert.Equal(t, "s3://bucket/done.csv", d.OutputLocation)
+	assert.True(t, d.ResultReused)
+	assert.Equal(t, "done", d.Stats.QueryID)
+	assert.Equal(t, int64(4096), d.Stats.DataScannedInBytes)
+	assert.True(t, d.CompletedAt.IsZero())
+}
+
+func TestGetResultMetadata(t *testing.T) {
+	queryToResultsGenMap["metadata"] = func(string) (*athena.GetQueryResultsOutput, error) {
+		return &athena.GetQueryResultsOutput{
+			ResultSet: &athena.ResultSet{
+				ResultSetMetadata: &athena.ResultSetMetadata{ColumnInfo: []*athena.ColumnInfo{
+					{Name: aws.String("id"), Label: aws.String("id"), Type: aws.String("bigint"), Precision: aws.Int64(19), Nullable: aws.String("UNKNOWN")},
+					{Name: aws.String("price"), Type: aws.String("decimal"), Precision: aws.Int64(10), Scale: aws.Int64(2), TableName: aws.String("items")},
+				}},
+				Rows: []*athena.Row{{Data: []*athena.Datum{{VarCharValue: aws.String("id")}, {VarCharValue: aws.String("price")}}}},
+			},
+			NextToken: aws.String("page_1"),
+		}, nil
+	}
+	defer delete(queryToResultsGenMap, "metadata")
+
+	db := openMockDB(t, &conn{athena: &mockAthenaConnClient{}})
+	columns, err := GetResultMetadata(context.Background(), db, "metadata")
+	require.NoError(t, err)
+	assert.Equal(t, []ResultColumn{
+		{Name: "id", Label: "id", Type: "bigint", Precision: 19, Nullable: "UNKNOWN"},
+		{Name: "price", Type: "decimal", Precision: 10, Scale: 2, TableName: "items"},
+	}, columns)
+	assert.True(t, columns[0].Computed())
+	assert.False(t, columns[1].Computed())
+}
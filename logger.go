@@ -0,0 +1,39 @@
+package athena
+
+import "context"
+
+// LogLevel is the severity of a message logged by the driver.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "DEBUG"
+	case LogLevelInfo:
+		return "INFO"
+	case LogLevelWarn:
+		return "WARN"
+	case LogLevelError:
+		return "ERROR"
+	}
+	return "UNKNOWN"
+}
+
+// Logger receives the messages logged by the driver, e.g. warnings about queries.
+// keyvals are alternating keys and values, e.g. "query_id", "abc".
+// It's called synchronously from the goroutine running the query, so it should return quickly.
+type Logger func(ctx context.Context, level LogLevel, msg string, keyvals ...interface{})
+
+// log calls the logger of the connection if it's set.
+func (c *conn) log(ctx context.Context, level LogLevel, msg string, keyvals ...interface{}) {
+	if c.logger != nil {
+		c.logger(ctx, level, msg, keyvals...)
+	}
+}
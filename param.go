@@ -0,0 +1,250 @@
+package athena
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TypedParam is a query parameter tagged with the Athena type it's used as.
+// Its literal is typed explicitly, e.g. `DATE '2023-01-01'`, instead of relying on
+// Athena to infer the type from the representation.
+type TypedParam struct {
+	// Type is the Athena type, e.g. `date`, `timestamp`, `decimal(10, 2)` or `array(varchar)`.
+	// It's written into the query as it is, so Literal rejects the ones which aren't a type name.
+	Type  string
+	Value interface{}
+}
+
+// Typed tags v with the Athena type.
+func Typed(athenaType string, v interface{}) TypedParam {
+	return TypedParam{Type: athenaType, Value: v}
+}
+
+// Date tags t as an Athena `date`.
+func Date(t time.Time) TypedParam {
+	return Typed("date", t)
+}
+
+// Timestamp tags t as an Athena `timestamp`.
+func Timestamp(t time.Time) TypedParam {
+	return Typed("timestamp", t)
+}
+
+// Literal returns the SQL literal of the parameter.
+// `date`, `timestamp`, `time` and `decimal` are written as typed literals, e.g. `DATE '2023-01-01'`,
+// and the other types as `CAST(<value> AS <type>)`. NULL is written as `CAST(NULL AS <type>)`.
+func (p TypedParam) Literal() (string, error) {
+	typ := strings.ToLower(strings.TrimSpace(p.Type))
+	if typ == "" {
+		return "", fmt.Errorf("type of parameter %v is empty", p.Value)
+	}
+	if !isValidType(typ) {
+		return "", fmt.Errorf("invalid type %q of parameter %v", p.Type, p.Value)
+	}
+	if p.Value == nil {
+		return fmt.Sprintf("CAST(NULL AS %s)", typ), nil
+	}
+
+	switch {
+	case typ == "date":
+		if t, ok := p.Value.(time.Time); ok {
+			return "DATE " + quoteString(t.Format(DateLayout)), nil
+		}
+		return typedLiteral("DATE", p.Value)
+	case typ == "timestamp":
+		if t, ok := p.Value.(time.Time); ok {
+			return "TIMESTAMP " + quoteString(t.Format(TimestampLayout)), nil
+		}
+		return typedLiteral("TIMESTAMP", p.Value)
+	case typ == "time":
+		if t, ok := p.Value.(time.Time); ok {
+			return "TIME " + quoteString(t.Format(TimeLayout)), nil
+		}
+		return typedLiteral("TIME", p.Value)
+	case strings.HasPrefix(typ, "decimal"):
+		l, err := typedLiteral("DECIMAL", p.Value)
+		if err != nil || typ == "decimal" {
+			return l, err
+		}
+		// keep precision and scale of the type.
+		return fmt.Sprintf("CAST(%s AS %s)", l, typ), nil
+	}
+
+	l, err := literal(p.Value)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("CAST(%s AS %s)", l, typ), nil
+}
+
+// simpleTypeRegex matches a lower-cased type name with optional parameters,
+// e.g. `bigint`, `varchar(10)`, `decimal(10, 2)` or `timestamp with time zone`.
+var simpleTypeRegex = regexp.MustCompile(`^[a-z][a-z0-9_ ]*(\(\d+(\s*,\s*\d+)?\))?$`)
+
+// rowFieldRegex matches a field of a row type, e.g. `id bigint`.
+var rowFieldRegex = regexp.MustCompile(`^([a-z_][a-z0-9_]*)\s+(.+)$`)
+
+// isValidType reports whether typ is a lower-cased type name, including the nested ones of
+// `array(<type>)`, `map(<type>, <type>)` and `row(<name> <type>, ...)`.
+func isValidType(typ string) bool {
+	typ = strings.TrimSpace(typ)
+	open := strings.IndexByte(typ, '(')
+	if open < 0 || !strings.HasSuffix(typ, ")") {
+		return simpleTypeRegex.MatchString(typ)
+	}
+
+	args, ok := splitTypeArguments(typ[open+1 : len(typ)-1])
+	if !ok {
+		return false
+	}
+	switch strings.TrimSpace(typ[:open]) {
+	case "array":
+		return len(args) == 1 && isValidType(args[0])
+	case "map":
+		return len(args) == 2 && isValidType(args[0]) && isValidType(args[1])
+	case "row":
+		for _, arg := range args {
+			m := rowFieldRegex.FindStringSubmatch(strings.TrimSpace(arg))
+			if m == nil || !isValidType(m[2]) {
+				return false
+			}
+		}
+		return true
+	}
+	return simpleTypeRegex.MatchString(typ)
+}
+
+// splitTypeArguments splits the arguments of a type by the commas outside of parentheses.
+// It returns false if the parentheses aren't balanced.
+func splitTypeArguments(s string) ([]string, bool) {
+	var args []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, false
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, false
+	}
+	return append(args, s[start:]), true
+}
+
+// numberLiteralRegex matches the literals of the integers and the floats written by literal.
+var numberLiteralRegex = regexp.MustCompile(`^[+-]?\d+(\.\d+)?([eE][+-]?\d+)?$`)
+
+// typedLiteral returns the typed literal of v, e.g. `DECIMAL '1.5'`, whose literal must be
+// a number or a string. The others, e.g. booleans and timestamps, can't be the string of a typed literal.
+func typedLiteral(keyword string, v interface{}) (string, error) {
+	l, err := literal(v)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case numberLiteralRegex.MatchString(l):
+		l = quoteString(l)
+	case strings.HasPrefix(l, "'"):
+	default:
+		return "", fmt.Errorf("parameter %v of type %T can't be a %s literal", v, v, keyword)
+	}
+	return keyword + " " + l, nil
+}
+
+// CheckNamedValue accepts the positional arguments of the types literal supports, e.g. TypedParam,
+// which are passed to Athena as execution parameters. The others are converted by the default converter.
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nv.Name != "" {
+		return fmt.Errorf("named parameter %s is not supported, use ? placeholders", nv.Name)
+	}
+	if _, err := literal(nv.Value); err != nil {
+		return driver.ErrSkip
+	}
+	return nil
+}
+
+var _ driver.NamedValueChecker = (*conn)(nil)
+
+// withArgs sets the literals of the arguments of a query as its execution parameters in context.
+func withArgs(ctx context.Context, args []driver.NamedValue) (context.Context, error) {
+	if len(args) == 0 {
+		return ctx, nil
+	}
+
+	params := make([]string, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("named parameter %s is not supported, use ? placeholders", arg.Name)
+		}
+		l, err := literal(arg.Value)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %d: %w", arg.Ordinal, err)
+		}
+		params[i] = l
+	}
+	return withExecutionParameters(ctx, params), nil
+}
+
+// literal returns the SQL literal of v.
+func literal(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case TypedParam:
+		return v.Literal()
+	case string:
+		return quoteString(v), nil
+	case []byte:
+		return fmt.Sprintf("X'%X'", v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case time.Time:
+		return "TIMESTAMP " + quoteString(v.Format(TimestampLayout)), nil
+	default:
+		return "", fmt.Errorf("unsupported parameter type %T", v)
+	}
+}
+
+// quoteString quotes s as a SQL string literal.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
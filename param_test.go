@@ -0,0 +1,114 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypedParam_Literal(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 600000000, time.UTC)
+	tests := []struct {
+		param    TypedParam
+		expected string
+	}{
+		{Date(ts), "DATE '2023-01-02'"},
+		{Typed("date", "2023-01-01"), "DATE '2023-01-01'"},
+		{Timestamp(ts), "TIMESTAMP '2023-01-02 03:04:05.6'"},
+		{Typed("time", "12:00:00"), "TIME '12:00:00'"},
+		{Typed("time", ts), "TIME '03:04:05.6'"},
+		{Typed("time", time.Date(0, 1, 1, 12, 0, 0, 0, time.UTC)), "TIME '12:00:00'"},
+		{Typed("decimal", "1.50"), "DECIMAL '1.50'"},
+		{Typed("decimal", 42), "DECIMAL '42'"},
+		{Typed("decimal", -1e21), "DECIMAL '-1e+21'"},
+		{Typed("decimal(10, 2)", 1.5), "CAST(DECIMAL '1.5' AS decimal(10, 2))"},
+		{Typed("decimal(10, 2)", "1.50"), "CAST(DECIMAL '1.50' AS decimal(10, 2))"},
+		{Typed("bigint", "42"), "CAST('42' AS bigint)"},
+		{Typed("VARCHAR", "it's"), "CAST('it''s' AS varchar)"},
+		{Typed("integer", 1), "CAST(1 AS integer)"},
+		{Typed("date", nil), "CAST(NULL AS date)"},
+		{Typed("timestamp with time zone", nil), "CAST(NULL AS timestamp with time zone)"},
+		{Typed("array(varchar)", nil), "CAST(NULL AS array(varchar))"},
+		{Typed("map(varchar, array(decimal(10, 2)))", nil), "CAST(NULL AS map(varchar, array(decimal(10, 2))))"},
+		{Typed("row(id bigint, tags map(varchar, varchar))", nil), "CAST(NULL AS row(id bigint, tags map(varchar, varchar)))"},
+	}
+	for _, test := range tests {
+		actual, err := test.param.Literal()
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, actual)
+	}
+}
+
+func TestTypedParam_LiteralError(t *testing.T) {
+	_, err := Typed("", 1).Literal()
+	assert.Error(t, err)
+
+	_, err = Typed("varchar", struct{}{}).Literal()
+	assert.Error(t, err)
+
+	// the values whose literal isn't a number or a string can't be typed literals.
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, param := range []TypedParam{
+		Typed("decimal", ts),
+		Typed("decimal(10, 2)", ts),
+		Typed("decimal", true),
+		Typed("decimal", []byte("1")),
+		Typed("decimal", math.NaN()),
+		Typed("decimal", Typed("integer", 1)),
+		Typed("time", true),
+	} {
+		_, err = param.Literal()
+		assert.Error(t, err, param)
+	}
+
+	// the type is written into the query, so anything but a type name is rejected.
+	for _, typ := range []string{
+		"varchar) OR (1=1",
+		"varchar(1) OR 1=1 --",
+		"array(varchar) OR (1=1)",
+		"array(varchar)) OR ((1",
+		"map(varchar)",
+		"row(bigint)",
+		"varchar; DROP TABLE t",
+	} {
+		_, err = Typed(typ, "x").Literal()
+		assert.Error(t, err, typ)
+	}
+}
+
+type testUserID int
+
+func TestConn_ExecutionParameters(t *testing.T) {
+	client := &mockAthenaConnClient{queryID: "select"}
+	db := openMockDB(t, &conn{athena: client})
+
+	day := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	rows, err := db.QueryContext(context.Background(),
+		"SELECT * FROM users WHERE id = ? AND name = ? AND deleted_at IS NOT DISTINCT FROM ? AND day = ? AND group_id = ?",
+		42, "it's", nil, Date(day), testUserID(7))
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	require.Len(t, client.started, 1)
+	assert.Equal(t, []string{"42", "'it''s'", "NULL", "DATE '2023-01-02'", "7"}, aws.StringValueSlice(client.started[0].ExecutionParameters))
+	assert.Equal(t, "SELECT * FROM users WHERE id = ? AND name = ? AND deleted_at IS NOT DISTINCT FROM ? AND day = ? AND group_id = ?",
+		*client.started[0].QueryString)
+
+	// queries without arguments have no parameters.
+	rows, err = db.QueryContext(context.Background(), "SELECT * FROM users")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	assert.Nil(t, client.started[1].ExecutionParameters)
+
+	_, err = db.QueryContext(context.Background(), "SELECT * FROM users WHERE id = @id", sql.Named("id", 1))
+	assert.Error(t, err)
+	_, err = db.QueryContext(context.Background(), "SELECT * FROM users WHERE id = ?", struct{}{})
+	assert.Error(t, err)
+	assert.Len(t, client.started, 2)
+}
@@ -0,0 +1,890 @@
+package athena
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file is a minimal reader of the Parquet objects written by the CTAS query of PARQUET DL mode.
+// It supports flat schemas, which are all of the CTAS tables of results without arrays, maps and rows,
+// the PLAIN and dictionary encodings, and the UNCOMPRESSED, SNAPPY and GZIP codecs.
+// Values are decoded into their Go types, and NULL is nil, since they aren't text which can be confused
+// with the value of NULL. testdata/v0.7.1.parquet, written by parquet-cpp, checks the reader against an
+// object which isn't written by the tests, and FuzzDecodeParquetRecords checks that corrupted objects are
+// errors instead of panics.
+// See https://github.com/apache/parquet-format for the format.
+
+const parquetMagic = "PAR1"
+
+// physical types of Parquet
+const (
+	parquetBoolean           = 0
+	parquetInt32             = 1
+	parquetInt64             = 2
+	parquetInt96             = 3
+	parquetFloat             = 4
+	parquetDouble            = 5
+	parquetByteArray         = 6
+	parquetFixedLenByteArray = 7
+)
+
+// page types of Parquet
+const (
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+)
+
+// encodings of Parquet
+const (
+	parquetEncodingPlain           = 0
+	parquetEncodingPlainDictionary = 2
+	parquetEncodingRLE             = 3
+	parquetEncodingRLEDictionary   = 8
+)
+
+// codecs of Parquet
+const (
+	parquetCodecUncompressed = 0
+	parquetCodecSnappy       = 1
+	parquetCodecGzip         = 2
+)
+
+// julianDayOfUnixEpoch is the Julian day of 1970-01-01, which INT96 timestamps are based on.
+const julianDayOfUnixEpoch = 2440588
+
+// parquetColumn is a column of a flat Parquet schema.
+type parquetColumn struct {
+	name       string
+	typ        int64
+	typeLength int
+	optional   bool
+
+	// how values are formatted, by the converted and logical types of the column.
+	utf8          bool
+	date          bool
+	decimal       bool
+	scale         int
+	timestampUnit time.Duration // zero if it's not a timestamp of INT64
+}
+
+// parquetDecimal is a decimal value in its exact text form, e.g. "-0.05",
+// which the converter returns as float64 or string by Config.DecimalAsString.
+type parquetDecimal string
+
+// decodeParquetRecords decodes the records of a Parquet object into values of their types, which are
+// nil for NULL: bool, int64, float64, string, []byte, time.Time of timestamps and dates, and parquetDecimal.
+// The values are in the order of the columns of the object, which is the order of the CTAS table.
+func decodeParquetRecords(data []byte) ([][]driver.Value, error) {
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		return nil, errors.New("parquet: not a parquet object")
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLength > len(data)-12 {
+		return nil, errors.New("parquet: invalid footer length")
+	}
+	footer := &thriftReader{buf: data[len(data)-8-footerLength : len(data)-8]}
+	meta, err := footer.readStruct()
+	if err != nil {
+		return nil, fmt.Errorf("parquet: invalid metadata: %w", err)
+	}
+
+	columns, err := parquetColumns(meta.list(2))
+	if err != nil {
+		return nil, err
+	}
+
+	// the counts of the metadata aren't trusted for allocations, since a corrupted object can have any counts.
+	if meta.int(3) < 0 {
+		return nil, fmt.Errorf("parquet: invalid number of rows %d", meta.int(3))
+	}
+	var records [][]driver.Value
+	for _, rg := range meta.list(4) {
+		rowGroup, _ := rg.(thriftStruct)
+		chunks := rowGroup.list(1)
+		if len(chunks) != len(columns) {
+			return nil, fmt.Errorf("parquet: row group has %d columns for %d columns", len(chunks), len(columns))
+		}
+		numRows := int(rowGroup.int(3))
+		if numRows < 0 || len(records)+numRows > int(meta.int(3)) {
+			return nil, fmt.Errorf("parquet: row group has %d rows for %d rows", numRows, meta.int(3))
+		}
+
+		fields := make([][]driver.Value, len(columns))
+		for i, cc := range chunks {
+			chunk, _ := cc.(thriftStruct)
+			fields[i], err = readParquetColumnChunk(data, columns[i], chunk, numRows)
+			if err != nil {
+				return nil, fmt.Errorf("parquet: column %s: %w", columns[i].name, err)
+			}
+			if len(fields[i]) != numRows {
+				return nil, fmt.Errorf("parquet: column %s has %d values for %d rows", columns[i].name, len(fields[i]), numRows)
+			}
+		}
+
+		for row := 0; row < numRows; row++ {
+			record := make([]driver.Value, len(columns))
+			for i := range columns {
+				record[i] = fields[i][row]
+			}
+			records = append(records, record)
+		}
+	}
+	if len(records) != int(meta.int(3)) {
+		return nil, fmt.Errorf("parquet: row groups have %d rows for %d rows", len(records), meta.int(3))
+	}
+	return records, nil
+}
+
+// parquetColumns returns the columns of a schema, which must be flat.
+func parquetColumns(schema []interface{}) ([]parquetColumn, error) {
+	if len(schema) == 0 {
+		return nil, errors.New("parquet: empty schema")
+	}
+
+	columns := make([]parquetColumn, 0, len(schema)-1)
+	for _, e := range schema[1:] {
+		elem, _ := e.(thriftStruct)
+		col := parquetColumn{
+			name:       elem.str(4),
+			typ:        elem.int(1),
+			typeLength: int(elem.int(2)),
+			optional:   elem.int(3) == 1,
+			scale:      int(elem.int(7)),
+		}
+		if elem.int(5) > 0 || elem.int(3) == 2 {
+			return nil, fmt.Errorf("parquet: column %s is nested, which isn't supported in PARQUET DL mode", col.name)
+		}
+
+		if elem.has(6) {
+			switch elem.int(6) {
+			case 0, 4, 19: // UTF8, ENUM, JSON
+				col.utf8 = true
+			case 5: // DECIMAL
+				col.decimal = true
+			case 6: // DATE
+				col.date = true
+			case 9: // TIMESTAMP_MILLIS
+				col.timestampUnit = time.Millisecond
+			case 10: // TIMESTAMP_MICROS
+				col.timestampUnit = time.Microsecond
+			}
+		}
+		if logical := elem.structure(10); logical != nil {
+			switch {
+			case logical.has(1), logical.has(4), logical.has(12): // STRING, ENUM, JSON
+				col.utf8 = true
+			case logical.has(5):
+				col.decimal = true
+				col.scale = int(logical.structure(5).int(1))
+			case logical.has(6):
+				col.date = true
+			case logical.has(8):
+				unit := logical.structure(8).structure(2)
+				switch {
+				case unit.has(1):
+					col.timestampUnit = time.Millisecond
+				case unit.has(2):
+					col.timestampUnit = time.Microsecond
+				case unit.has(3):
+					col.timestampUnit = time.Nanosecond
+				}
+			}
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// readParquetColumnChunk returns the values of a column chunk of a row group of numRows rows, which are nil for NULL.
+func readParquetColumnChunk(data []byte, col parquetColumn, chunk thriftStruct, numRows int) ([]driver.Value, error) {
+	if chunk.str(1) != "" {
+		return nil, errors.New("external column chunks aren't supported")
+	}
+	meta := chunk.structure(3)
+	codec := meta.int(4)
+	numValues := int(meta.int(5))
+	if numValues != numRows {
+		return nil, fmt.Errorf("column chunk has %d values for %d rows", numValues, numRows)
+	}
+
+	start := meta.int(9)
+	if dict := meta.int(11); meta.has(11) && dict > 0 && dict < start {
+		start = dict
+	}
+	end := start + meta.int(7)
+	if start < 4 || end > int64(len(data)) || start > end {
+		return nil, errors.New("invalid column chunk offsets")
+	}
+
+	var dict, values []driver.Value
+	pos := int(start)
+	for len(values) < numValues && pos < int(end) {
+		r := &thriftReader{buf: data[pos:end]}
+		header, err := r.readStruct()
+		if err != nil {
+			return nil, fmt.Errorf("invalid page header: %w", err)
+		}
+		pos += r.pos
+		size := int(header.int(3))
+		if size < 0 || pos+size > int(end) {
+			return nil, errors.New("invalid page size")
+		}
+		page := data[pos : pos+size]
+		pos += size
+
+		switch header.int(1) {
+		case parquetDictionaryPage:
+			page, err = decompressParquet(codec, page)
+			if err != nil {
+				return nil, err
+			}
+			n := int(header.structure(7).int(1))
+			if n < 0 {
+				return nil, fmt.Errorf("invalid number of dictionary values %d", n)
+			}
+			dict, _, err = decodeParquetPlain(col, page, n)
+			if err != nil {
+				return nil, err
+			}
+		case parquetDataPage:
+			page, err = decompressParquet(codec, page)
+			if err != nil {
+				return nil, err
+			}
+			h := header.structure(5)
+			n := int(h.int(1))
+			if n < 0 || len(values)+n > numValues {
+				return nil, fmt.Errorf("page has %d values for %d values", n, numValues-len(values))
+			}
+			var defs []int
+			if col.optional {
+				if len(page) < 4 {
+					return nil, errors.New("invalid definition levels")
+				}
+				length := int(binary.LittleEndian.Uint32(page))
+				if 4+length > len(page) {
+					return nil, errors.New("invalid definition levels")
+				}
+				if defs, err = decodeRLEHybrid(page[4:4+length], 1, n); err != nil {
+					return nil, err
+				}
+				page = page[4+length:]
+			}
+			if values, err = appendParquetValues(values, col, h.int(2), page, n, defs, dict); err != nil {
+				return nil, err
+			}
+		case parquetDataPageV2:
+			h := header.structure(8)
+			n := int(h.int(1))
+			if n < 0 || len(values)+n > numValues {
+				return nil, fmt.Errorf("page has %d values for %d values", n, numValues-len(values))
+			}
+			levelsLength := int(h.int(5) + h.int(6))
+			if h.int(5) < 0 || h.int(6) < 0 || levelsLength > len(page) {
+				return nil, errors.New("invalid levels")
+			}
+			var defs []int
+			if col.optional {
+				if defs, err = decodeRLEHybrid(page[h.int(6):levelsLength], 1, n); err != nil {
+					return nil, err
+				}
+			}
+			page = page[levelsLength:]
+			if h.bool(7, true) {
+				if page, err = decompressParquet(codec, page); err != nil {
+					return nil, err
+				}
+			}
+			if values, err = appendParquetValues(values, col, h.int(4), page, n, defs, dict); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(values) != numValues {
+		return nil, fmt.Errorf("%d values are read for %d values", len(values), numValues)
+	}
+	return values, nil
+}
+
+// appendParquetValues appends n values of a data page, which are nil if their definition levels are 0.
+func appendParquetValues(values []driver.Value, col parquetColumn, encoding int64, page []byte, n int, defs []int, dict []driver.Value) ([]driver.Value, error) {
+	count := n
+	if defs != nil {
+		count = 0
+		for _, d := range defs {
+			count += d
+		}
+	}
+
+	var decoded []driver.Value
+	var err error
+	switch encoding {
+	case parquetEncodingPlain:
+		decoded, _, err = decodeParquetPlain(col, page, count)
+	case parquetEncodingPlainDictionary, parquetEncodingRLEDictionary:
+		if len(page) == 0 {
+			if count > 0 {
+				return nil, errors.New("invalid dictionary indices")
+			}
+			break
+		}
+		var indices []int
+		indices, err = decodeRLEHybrid(page[1:], int(page[0]), count)
+		for _, i := range indices {
+			if i >= len(dict) {
+				return nil, fmt.Errorf("dictionary index %d is out of %d values", i, len(dict))
+			}
+			decoded = append(decoded, dict[i])
+		}
+	case parquetEncodingRLE:
+		// booleans of data pages v2 are RLE encoded with the length.
+		if col.typ != parquetBoolean || len(page) < 4 {
+			return nil, errors.New("invalid RLE values")
+		}
+		var bits []int
+		bits, err = decodeRLEHybrid(page[4:], 1, count)
+		for _, b := range bits {
+			decoded = append(decoded, b == 1)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %d", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) != count {
+		return nil, fmt.Errorf("page has %d values for %d values", len(decoded), count)
+	}
+
+	if defs == nil {
+		return append(values, decoded...), nil
+	}
+	next := 0
+	for _, d := range defs {
+		if d == 0 {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, decoded[next])
+		next++
+	}
+	return values, nil
+}
+
+// decodeParquetPlain decodes count PLAIN encoded values, and returns them with the number of bytes read.
+func decodeParquetPlain(col parquetColumn, buf []byte, count int) ([]driver.Value, int, error) {
+	var values []driver.Value
+	pos := 0
+	need := func(n int) error {
+		if n < 0 || pos+n > len(buf) {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	}
+
+	for i := 0; i < count; i++ {
+		switch col.typ {
+		case parquetBoolean:
+			if err := need(i/8 + 1 - pos); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, buf[i/8]>>(i%8)&1 == 1)
+			continue
+		case parquetInt32:
+			if err := need(4); err != nil {
+				return nil, 0, err
+			}
+			v := int32(binary.LittleEndian.Uint32(buf[pos:]))
+			pos += 4
+			switch {
+			case col.date:
+				values = append(values, time.Unix(int64(v)*24*60*60, 0).UTC())
+			case col.decimal:
+				values = append(values, parquetDecimal(formatDecimal(big.NewInt(int64(v)), col.scale)))
+			default:
+				values = append(values, int64(v))
+			}
+		case parquetInt64:
+			if err := need(8); err != nil {
+				return nil, 0, err
+			}
+			v := int64(binary.LittleEndian.Uint64(buf[pos:]))
+			pos += 8
+			switch {
+			case col.timestampUnit > 0:
+				values = append(values, parquetTimestamp(v, col.timestampUnit))
+			case col.decimal:
+				values = append(values, parquetDecimal(formatDecimal(big.NewInt(v), col.scale)))
+			default:
+				values = append(values, v)
+			}
+		case parquetInt96:
+			if err := need(12); err != nil {
+				return nil, 0, err
+			}
+			nanos := int64(binary.LittleEndian.Uint64(buf[pos:]))
+			days := int64(binary.LittleEndian.Uint32(buf[pos+8:])) - julianDayOfUnixEpoch
+			pos += 12
+			values = append(values, time.Unix(days*24*60*60, nanos).UTC())
+		case parquetFloat:
+			if err := need(4); err != nil {
+				return nil, 0, err
+			}
+			v := math.Float32frombits(binary.LittleEndian.Uint32(buf[pos:]))
+			pos += 4
+			values = append(values, float64(v))
+		case parquetDouble:
+			if err := need(8); err != nil {
+				return nil, 0, err
+			}
+			v := math.Float64frombits(binary.LittleEndian.Uint64(buf[pos:]))
+			pos += 8
+			values = append(values, v)
+		case parquetByteArray, parquetFixedLenByteArray:
+			length := col.typeLength
+			if col.typ == parquetByteArray {
+				if err := need(4); err != nil {
+					return nil, 0, err
+				}
+				length = int(binary.LittleEndian.Uint32(buf[pos:]))
+				pos += 4
+			}
+			if err := need(length); err != nil {
+				return nil, 0, err
+			}
+			b := buf[pos : pos+length]
+			pos += length
+			switch {
+			case col.decimal:
+				values = append(values, parquetDecimal(formatDecimal(decodeBigEndianInt(b), col.scale)))
+			case col.utf8:
+				values = append(values, string(b))
+			default:
+				// the page can be in the object buffer, which is reused.
+				values = append(values, append([]byte{}, b...))
+			}
+		default:
+			return nil, 0, fmt.Errorf("unsupported type %d", col.typ)
+		}
+	}
+	if col.typ == parquetBoolean {
+		pos = (count + 7) / 8
+	}
+	return values, pos, nil
+}
+
+// parquetTimestamp returns the time of an INT64 timestamp in unit since the Unix epoch,
+// which is decoded without a time.Duration since milliseconds and microseconds can exceed its range.
+func parquetTimestamp(v int64, unit time.Duration) time.Time {
+	switch unit {
+	case time.Millisecond:
+		return time.UnixMilli(v).UTC()
+	case time.Microsecond:
+		return time.UnixMicro(v).UTC()
+	default:
+		return time.Unix(0, v).UTC()
+	}
+}
+
+// formatParquetValue formats a value of a column of athenaType decoded by decodeParquetRecords
+// in the text of CTAS TEXTFILE, e.g. base64 for binary, for the value converter and raw unconvertible values.
+func formatParquetValue(athenaType string, v driver.Value) string {
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if athenaType == "float" {
+			return strconv.FormatFloat(v, 'g', -1, 32)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return v
+	case parquetDecimal:
+		return string(v)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case time.Time:
+		if athenaType == "date" {
+			return v.Format(DateLayout)
+		}
+		// the fraction isn't lost.
+		return v.Format("2006-01-02 15:04:05.999999999")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formatDecimal formats the unscaled value of a decimal.
+func formatDecimal(unscaled *big.Int, scale int) string {
+	s := new(big.Int).Abs(unscaled).String()
+	if scale > 0 {
+		if len(s) <= scale {
+			s = strings.Repeat("0", scale-len(s)+1) + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+	}
+	if unscaled.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// decodeBigEndianInt decodes a big-endian two's complement integer.
+func decodeBigEndianInt(b []byte) *big.Int {
+	v := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return v
+}
+
+// decodeRLEHybrid decodes count values of the RLE/bit-packing hybrid encoding.
+func decodeRLEHybrid(buf []byte, bitWidth, count int) ([]int, error) {
+	if bitWidth > 32 {
+		return nil, fmt.Errorf("invalid bit width %d", bitWidth)
+	}
+	var values []int
+	byteWidth := (bitWidth + 7) / 8
+	pos := 0
+	for len(values) < count {
+		header, n := binary.Uvarint(buf[pos:])
+		if n <= 0 || header>>1 == 0 {
+			return nil, errors.New("invalid RLE run")
+		}
+		pos += n
+
+		if header&1 == 0 {
+			// RLE run
+			if pos+byteWidth > len(buf) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			v := 0
+			for i := 0; i < byteWidth; i++ {
+				v |= int(buf[pos+i]) << (8 * i)
+			}
+			pos += byteWidth
+			for i := 0; i < int(header>>1) && len(values) < count; i++ {
+				values = append(values, v)
+			}
+			continue
+		}
+
+		// bit-packed run of groups of 8 values, whose last group can be truncated.
+		length := int(header>>1) * bitWidth
+		if pos+length > len(buf) {
+			length = len(buf) - pos
+		}
+		packed := buf[pos : pos+length]
+		pos += length
+		for i := 0; i < int(header>>1)*8 && len(values) < count; i++ {
+			if (i+1)*bitWidth > len(packed)*8 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			v := 0
+			for b := 0; b < bitWidth; b++ {
+				bit := i*bitWidth + b
+				v |= int(packed[bit/8]>>(bit%8)&1) << b
+			}
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// decompressParquet decompresses a page by the codec of its column chunk.
+func decompressParquet(codec int64, data []byte) ([]byte, error) {
+	switch codec {
+	case parquetCodecUncompressed:
+		return data, nil
+	case parquetCodecSnappy:
+		return decodeSnappy(data)
+	case parquetCodecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %d", codec)
+	}
+}
+
+// decodeSnappy decodes a block of the Snappy format, which Parquet uses without framing.
+func decodeSnappy(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	// a copy of 3 bytes is at most 64 bytes, which is the largest expansion of Snappy,
+	// so the length can't exceed it and isn't allocated beyond it.
+	if n <= 0 || length > math.MaxInt32 || length > uint64(len(src))*22 {
+		return nil, errors.New("snappy: invalid length")
+	}
+	dst := make([]byte, 0, length)
+	for pos := n; pos < len(src); {
+		tag := src[pos]
+		pos++
+
+		var offset, size int
+		switch tag & 3 {
+		case 0: // literal
+			size = int(tag>>2) + 1
+			if size > 60 {
+				extra := size - 60
+				if pos+extra > len(src) {
+					return nil, io.ErrUnexpectedEOF
+				}
+				size = 0
+				for i := 0; i < extra; i++ {
+					size |= int(src[pos+i]) << (8 * i)
+				}
+				size++
+				pos += extra
+			}
+			if size <= 0 || pos+size > len(src) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			dst = append(dst, src[pos:pos+size]...)
+			pos += size
+			continue
+		case 1: // copy with 1 byte offset
+			if pos+1 > len(src) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			size = int(tag>>2&7) + 4
+			offset = int(tag>>5)<<8 | int(src[pos])
+			pos++
+		case 2: // copy with 2 bytes offset
+			if pos+2 > len(src) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint16(src[pos:]))
+			pos += 2
+		case 3: // copy with 4 bytes offset
+			if pos+4 > len(src) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint32(src[pos:]))
+			pos += 4
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, errors.New("snappy: invalid copy offset")
+		}
+		// copies can overlap their output, so they're done byte by byte.
+		for i := 0; i < size; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, errors.New("snappy: invalid length")
+	}
+	return dst, nil
+}
+
+// thriftStruct is a struct of the Thrift compact protocol, which Parquet metadata is encoded in,
+// keyed by the field ids. Integers are int64, binaries []byte, lists []interface{}.
+type thriftStruct map[int16]interface{}
+
+func (s thriftStruct) has(id int16) bool {
+	_, ok := s[id]
+	return ok
+}
+
+func (s thriftStruct) int(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func (s thriftStruct) bool(id int16, def bool) bool {
+	if v, ok := s[id].(bool); ok {
+		return v
+	}
+	return def
+}
+
+func (s thriftStruct) str(id int16) string {
+	v, _ := s[id].([]byte)
+	return string(v)
+}
+
+func (s thriftStruct) list(id int16) []interface{} {
+	v, _ := s[id].([]interface{})
+	return v
+}
+
+func (s thriftStruct) structure(id int16) thriftStruct {
+	v, _ := s[id].(thriftStruct)
+	return v
+}
+
+// thriftReader reads values of the Thrift compact protocol.
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+// types of the Thrift compact protocol
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStructure = 12
+)
+
+func (r *thriftReader) readByte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) readVarint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) readZigzag() (int64, error) {
+	v, err := r.readVarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *thriftReader) readStruct() (thriftStruct, error) {
+	s := make(thriftStruct)
+	var id int16
+	for {
+		b, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return s, nil
+		}
+
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := r.readZigzag()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+
+		switch typ := b & 0x0f; typ {
+		case thriftBoolTrue:
+			s[id] = true
+		case thriftBoolFalse:
+			s[id] = false
+		default:
+			if s[id], err = r.readValue(typ); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (r *thriftReader) readValue(typ byte) (interface{}, error) {
+	switch typ {
+	case thriftBoolTrue, thriftBoolFalse:
+		// booleans in lists are a byte each.
+		b, err := r.readByte()
+		return b == thriftBoolTrue, err
+	case thriftByte:
+		b, err := r.readByte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.readZigzag()
+	case thriftDouble:
+		if r.pos+8 > len(r.buf) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.buf[r.pos:]))
+		r.pos += 8
+		return v, nil
+	case thriftBinary:
+		n, err := r.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(r.buf)-r.pos) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		v := r.buf[r.pos : r.pos+int(n)]
+		r.pos += int(n)
+		return v, nil
+	case thriftList, thriftSet:
+		header, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = r.readVarint(); err != nil {
+				return nil, err
+			}
+		}
+		if size > uint64(len(r.buf)-r.pos) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			if list[i], err = r.readValue(header & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case thriftMap:
+		// maps aren't used by the fields read, so they're skipped.
+		size, err := r.readVarint()
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		types, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if size > uint64(len(r.buf)-r.pos) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		for i := uint64(0); i < size; i++ {
+			if _, err := r.readValue(types >> 4); err != nil {
+				return nil, err
+			}
+			if _, err := r.readValue(types & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case thriftStructure:
+		return r.readStruct()
+	default:
+		return nil, fmt.Errorf("unknown thrift type %d", typ)
+	}
+}
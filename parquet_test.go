@@ -0,0 +1,547 @@
+package athena
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// thriftWriter writes the Thrift compact protocol for the Parquet fixtures.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // the last field id of each struct being written
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) int(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) str(id int16, v string) {
+	w.field(id, thriftBinary)
+	w.varint(uint64(len(v)))
+	w.buf.WriteString(v)
+}
+
+func (w *thriftWriter) list(id int16, elemType byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | elemType)
+	} else {
+		w.buf.WriteByte(0xf0 | elemType)
+		w.varint(uint64(n))
+	}
+}
+
+// begin starts a struct of field id, or an element of a list if id is zero.
+func (w *thriftWriter) begin(id int16) {
+	if id != 0 {
+		w.field(id, thriftStructure)
+	}
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) end() {
+	w.buf.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+// parquetTestColumn is a column of a Parquet fixture, whose values are nil for NULL.
+type parquetTestColumn struct {
+	name      string
+	typ       int64
+	converted int64 // -1 if it has no converted type
+	scale     int64
+	optional  bool
+	dict      bool
+	values    []interface{}
+
+	timestampUnit int16 // the field id of the unit of the logical TIMESTAMP type, 0 if it's not a timestamp
+}
+
+func plainParquetValue(buf *bytes.Buffer, typ int64, v interface{}) {
+	switch typ {
+	case parquetInt32:
+		binary.Write(buf, binary.LittleEndian, int32(v.(int)))
+	case parquetInt64:
+		binary.Write(buf, binary.LittleEndian, int64(v.(int)))
+	case parquetInt96:
+		// nanoseconds of the day and the Julian day
+		t := v.(time.Time)
+		day := t.Unix() / (24 * 60 * 60)
+		binary.Write(buf, binary.LittleEndian, uint64(t.UnixNano()-day*24*60*60*int64(time.Second)))
+		binary.Write(buf, binary.LittleEndian, uint32(day+julianDayOfUnixEpoch))
+	case parquetDouble:
+		binary.Write(buf, binary.LittleEndian, math.Float64bits(v.(float64)))
+	case parquetByteArray:
+		binary.Write(buf, binary.LittleEndian, uint32(len(v.(string))))
+		buf.WriteString(v.(string))
+	}
+}
+
+// genParquetObject returns a Parquet object of a row group with a data page v1 for each column.
+func genParquetObject(t testing.TB, columns []parquetTestColumn, codec int64) []byte {
+	compress := func(data []byte) []byte {
+		if codec != parquetCodecGzip {
+			return data
+		}
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	writePage := func(out *bytes.Buffer, page []byte, pageType int64, encoding int64, n int) {
+		compressed := compress(page)
+		h := &thriftWriter{}
+		h.begin(0)
+		h.int(1, pageType)
+		h.int(2, int64(len(page)))
+		h.int(3, int64(len(compressed)))
+		if pageType == parquetDictionaryPage {
+			h.begin(7)
+		} else {
+			h.begin(5)
+		}
+		h.int(1, int64(n))
+		h.int(2, encoding)
+		h.end()
+		h.end()
+		out.Write(h.buf.Bytes())
+		out.Write(compressed)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(parquetMagic)
+
+	type chunk struct {
+		dictOffset, dataOffset, size int
+	}
+	chunks := make([]chunk, len(columns))
+	numRows := len(columns[0].values)
+	for i, col := range columns {
+		var page, values bytes.Buffer
+		if col.optional {
+			// definition levels as a bit-packed run
+			levels := []byte{byte((numRows+7)/8)<<1 | 1}
+			packed := make([]byte, (numRows+7)/8)
+			for j, v := range col.values {
+				if v != nil {
+					packed[j/8] |= 1 << (j % 8)
+				}
+			}
+			levels = append(levels, packed...)
+			binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+			page.Write(levels)
+		}
+
+		encoding := int64(parquetEncodingPlain)
+		if col.dict {
+			// dictionary indices as RLE runs of 8 bits
+			var dict bytes.Buffer
+			var n int
+			indices := make(map[interface{}]int)
+			values.WriteByte(8)
+			for _, v := range col.values {
+				if v == nil {
+					continue
+				}
+				if _, ok := indices[v]; !ok {
+					indices[v] = n
+					n++
+					plainParquetValue(&dict, col.typ, v)
+				}
+				values.Write([]byte{1 << 1, byte(indices[v])})
+			}
+			chunks[i].dictOffset = out.Len()
+			writePage(&out, dict.Bytes(), parquetDictionaryPage, parquetEncodingPlain, n)
+			encoding = parquetEncodingRLEDictionary
+		} else {
+			for _, v := range col.values {
+				if v != nil {
+					plainParquetValue(&values, col.typ, v)
+				}
+			}
+		}
+		page.Write(values.Bytes())
+
+		chunks[i].dataOffset = out.Len()
+		writePage(&out, page.Bytes(), parquetDataPage, encoding, numRows)
+		start := chunks[i].dataOffset
+		if col.dict {
+			start = chunks[i].dictOffset
+		}
+		chunks[i].size = out.Len() - start
+	}
+
+	w := &thriftWriter{}
+	w.begin(0)
+	w.int(1, 1)
+	w.list(2, thriftStructure, len(columns)+1)
+	w.begin(0)
+	w.str(4, "schema")
+	w.int(5, int64(len(columns)))
+	w.end()
+	for _, col := range columns {
+		w.begin(0)
+		w.int(1, col.typ)
+		if col.optional {
+			w.int(3, 1)
+		} else {
+			w.int(3, 0)
+		}
+		w.str(4, col.name)
+		if col.converted >= 0 {
+			w.int(6, col.converted)
+		}
+		if col.scale > 0 {
+			w.int(7, col.scale)
+		}
+		if col.timestampUnit > 0 {
+			w.begin(10) // LogicalType
+			w.begin(8)  // TimestampType
+			w.begin(2)  // TimeUnit
+			w.begin(col.timestampUnit)
+			w.end()
+			w.end()
+			w.end()
+			w.end()
+		}
+		w.end()
+	}
+	w.int(3, int64(numRows))
+	w.list(4, thriftStructure, 1)
+	w.begin(0)
+	w.list(1, thriftStructure, len(columns))
+	for i, col := range columns {
+		w.begin(0)
+		w.int(2, int64(chunks[i].dataOffset))
+		w.begin(3)
+		w.int(1, col.typ)
+		w.list(2, thriftI32, 1)
+		if col.dict {
+			w.zigzag(parquetEncodingRLEDictionary)
+		} else {
+			w.zigzag(parquetEncodingPlain)
+		}
+		w.list(3, thriftBinary, 1)
+		w.varint(uint64(len(col.name)))
+		w.buf.WriteString(col.name)
+		w.int(4, codec)
+		w.int(5, int64(numRows))
+		w.int(6, int64(chunks[i].size))
+		w.int(7, int64(chunks[i].size))
+		w.int(9, int64(chunks[i].dataOffset))
+		if col.dict {
+			w.int(11, int64(chunks[i].dictOffset))
+		}
+		w.end()
+		w.end()
+	}
+	w.int(3, int64(numRows))
+	w.end()
+	w.end()
+
+	out.Write(w.buf.Bytes())
+	binary.Write(&out, binary.LittleEndian, uint32(w.buf.Len()))
+	out.WriteString(parquetMagic)
+	return out.Bytes()
+}
+
+// genParquetTestColumns returns the columns of a fixture with types written by CTAS PARQUET.
+func genParquetTestColumns() []parquetTestColumn {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	return []parquetTestColumn{
+		{name: "id", typ: parquetInt64, converted: -1, values: []interface{}{1, 2, 3}},
+		{name: "name", typ: parquetByteArray, converted: 0, optional: true, dict: true, values: []interface{}{"foo", nil, "foo"}},
+		{name: "price", typ: parquetInt64, converted: 5, scale: 2, optional: true, values: []interface{}{12345, -5, nil}},
+		{name: "ratio", typ: parquetDouble, converted: -1, optional: true, values: []interface{}{0.1, nil, 1e21}},
+		{name: "day", typ: parquetInt32, converted: 6, values: []interface{}{19359, 0, -1}},
+		{name: "ts", typ: parquetInt96, converted: -1, values: []interface{}{ts, time.Unix(0, 0), ts}},
+		{name: "payload", typ: parquetByteArray, converted: -1, values: []interface{}{"hello", "", "\x00\xff"}},
+	}
+}
+
+func TestDecodeParquetRecords(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	day := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	expected := [][]driver.Value{
+		{int64(1), "foo", parquetDecimal("123.45"), 0.1, day, ts, []byte("hello")},
+		{int64(2), nil, parquetDecimal("-0.05"), nil, time.Unix(0, 0).UTC(), time.Unix(0, 0).UTC(), []byte{}},
+		{int64(3), "foo", nil, 1e21, time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC), ts, []byte{0x00, 0xff}},
+	}
+
+	for _, codec := range []int64{parquetCodecUncompressed, parquetCodecGzip} {
+		records, err := decodeParquetRecords(genParquetObject(t, genParquetTestColumns(), codec))
+		require.NoError(t, err)
+		assert.Equal(t, expected, records, codec)
+	}
+}
+
+// testdata/v0.7.1.parquet is written by parquet-cpp 1.3.2-SNAPSHOT of pyarrow, which is the example
+// of the parquet_reader of Apache Arrow (https://github.com/apache/arrow-go/tree/main/parquet/cmd/parquet_reader),
+// with SNAPPY, the dictionary encoding and optional columns, as Athena writes.
+func TestDecodeParquetRecords_External(t *testing.T) {
+	data, err := os.ReadFile("testdata/v0.7.1.parquet")
+	require.NoError(t, err)
+
+	records, err := decodeParquetRecords(data)
+	require.NoError(t, err)
+	require.Len(t, records, 10)
+	// carat, cut, color, clarity, depth, table, price, x, y, z and the index of pandas.
+	assert.Equal(t, []driver.Value{0.23, "Ideal", "E", "SI2", 61.5, 55.0, int64(326), 3.95, 3.98, 2.43, int64(0)}, records[0])
+	assert.Equal(t, []driver.Value{0.23, "Very Good", "H", "VS1", 59.4, 61.0, int64(338), 4.0, 4.05, 2.39, int64(9)}, records[9])
+}
+
+func TestDecodeParquetRecords_Timestamp(t *testing.T) {
+	sentinel := time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	// nanoseconds of int64 end in 2262.
+	maxNanos := time.Unix(0, math.MaxInt64).UTC()
+	columns := []parquetTestColumn{
+		{name: "millis", typ: parquetInt64, converted: 9, values: []interface{}{int(sentinel.UnixMilli())}},
+		{name: "micros", typ: parquetInt64, converted: 10, values: []interface{}{int(sentinel.UnixMicro())}},
+		{name: "logical_millis", typ: parquetInt64, converted: -1, timestampUnit: 1, values: []interface{}{int(sentinel.UnixMilli())}},
+		{name: "logical_micros", typ: parquetInt64, converted: -1, timestampUnit: 2, values: []interface{}{int(sentinel.UnixMicro())}},
+		{name: "logical_nanos", typ: parquetInt64, converted: -1, timestampUnit: 3, values: []interface{}{math.MaxInt64}},
+	}
+
+	records, err := decodeParquetRecords(genParquetObject(t, columns, parquetCodecUncompressed))
+	require.NoError(t, err)
+	assert.Equal(t, [][]driver.Value{{sentinel, sentinel, sentinel, sentinel, maxNanos}}, records)
+}
+
+// genParquetFooter returns a Parquet object of a column without pages, whose footer has the counts,
+// and no row groups if rowGroupRows is nil.
+func genParquetFooter(numRows int64, rowGroupRows *int64, numValues int64) []byte {
+	w := &thriftWriter{}
+	w.begin(0)
+	w.int(1, 1)
+	w.list(2, thriftStructure, 2)
+	w.begin(0)
+	w.str(4, "schema")
+	w.int(5, 1)
+	w.end()
+	w.begin(0)
+	w.int(1, parquetInt64)
+	w.int(3, 0)
+	w.str(4, "id")
+	w.end()
+	w.int(3, numRows)
+	if rowGroupRows != nil {
+		w.list(4, thriftStructure, 1)
+		w.begin(0)
+		w.list(1, thriftStructure, 1)
+		w.begin(0)
+		w.int(2, 4)
+		w.begin(3)
+		w.int(1, parquetInt64)
+		w.int(4, parquetCodecUncompressed)
+		w.int(5, numValues)
+		w.int(7, 0)
+		w.int(9, 4)
+		w.end()
+		w.end()
+		w.int(3, *rowGroupRows)
+		w.end()
+	}
+	w.end()
+
+	var out bytes.Buffer
+	out.WriteString(parquetMagic)
+	out.Write(w.buf.Bytes())
+	binary.Write(&out, binary.LittleEndian, uint32(w.buf.Len()))
+	out.WriteString(parquetMagic)
+	return out.Bytes()
+}
+
+func TestDecodeParquetRecords_Invalid(t *testing.T) {
+	_, err := decodeParquetRecords([]byte("not a parquet object"))
+	assert.Error(t, err)
+
+	data := genParquetObject(t, genParquetTestColumns(), parquetCodecUncompressed)
+	_, err = decodeParquetRecords(data[:len(data)-20])
+	assert.Error(t, err)
+
+	rows := func(n int64) *int64 { return &n }
+	huge := int64(1) << 62
+	for name, data := range map[string][]byte{
+		"negative num_rows":           genParquetFooter(-1, nil, 0),
+		"huge num_rows":               genParquetFooter(huge, nil, 0),
+		"negative row group num_rows": genParquetFooter(0, rows(-1), -1),
+		"huge row group num_rows":     genParquetFooter(huge, rows(huge), huge),
+		"row group num_rows over":     genParquetFooter(1, rows(2), 2),
+		"negative num_values":         genParquetFooter(1, rows(1), -1),
+		"huge num_values":             genParquetFooter(1, rows(1), huge),
+	} {
+		assert.NotPanics(t, func() {
+			_, err = decodeParquetRecords(data)
+		}, name)
+		assert.Error(t, err, name)
+	}
+}
+
+// FuzzDecodeParquetRecords checks that corrupted objects are errors of decodeParquetRecords, not panics.
+func FuzzDecodeParquetRecords(f *testing.F) {
+	f.Add(genParquetObject(f, genParquetTestColumns(), parquetCodecUncompressed))
+	f.Add(genParquetObject(f, genParquetTestColumns(), parquetCodecGzip))
+	f.Add(genParquetFooter(1, nil, 0))
+	if data, err := os.ReadFile("testdata/v0.7.1.parquet"); err == nil {
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		records, err := decodeParquetRecords(data)
+		if err != nil {
+			assert.Nil(t, records)
+		}
+	})
+}
+
+func Test_decodeSnappy(t *testing.T) {
+	// a literal "abc" and a copy of 5 bytes at offset 3, which overlaps its output.
+	data, err := decodeSnappy([]byte{8, 2 << 2, 'a', 'b', 'c', 1 | (5-4)<<2, 3})
+	require.NoError(t, err)
+	assert.Equal(t, "abcabcab", string(data))
+
+	_, err = decodeSnappy([]byte{9, 2 << 2, 'a', 'b', 'c', 1 | (5-4)<<2, 3})
+	assert.Error(t, err)
+	_, err = decodeSnappy([]byte{8, 1 | (5-4)<<2, 3})
+	assert.Error(t, err)
+	// a length of 2GB for 7 bytes
+	_, err = decodeSnappy([]byte{0xff, 0xff, 0xff, 0xff, 0x07, 0 << 2, 'a'})
+	assert.Error(t, err)
+}
+
+func Test_decodeRLEHybrid(t *testing.T) {
+	// a RLE run of 3 values of 5, and a bit-packed group of 1, 2, 3 in 2 bits.
+	values, err := decodeRLEHybrid([]byte{3 << 1, 5, 1<<1 | 1, 1 | 2<<2 | 3<<4, 0}, 2, 6)
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 5, 5, 1, 2, 3}, values)
+}
+
+func Test_formatDecimal(t *testing.T) {
+	for _, c := range []struct {
+		unscaled int64
+		scale    int
+		expected string
+	}{
+		{12345, 2, "123.45"},
+		{-5, 2, "-0.05"},
+		{0, 3, "0.000"},
+		{42, 0, "42"},
+	} {
+		assert.Equal(t, c.expected, formatDecimal(big.NewInt(c.unscaled), c.scale))
+	}
+	assert.Equal(t, "-1.28", formatDecimal(decodeBigEndianInt([]byte{0xff, 0x80}), 2))
+}
+
+func TestConn_ParquetDL(t *testing.T) {
+	client := &mockAthenaConnClient{
+		queryID:  "select",
+		location: "s3://bucket/tables/select",
+		tableColumns: []*athena.Column{
+			genTableColumn("id", "bigint"),
+			genTableColumn("name", "string"),
+			genTableColumn("price", "decimal(10,2)"),
+			genTableColumn("ratio", "double"),
+			genTableColumn("day", "date"),
+			genTableColumn("ts", "timestamp"),
+			genTableColumn("payload", "binary"),
+		},
+	}
+	c := &conn{
+		athena: client,
+		s3: &mockS3Client{objects: map[string][]byte{
+			"bucket/tables/select-manifest.csv": []byte("s3://bucket/tables/select/00000\n"),
+			"bucket/tables/select/00000":        genParquetObject(t, genParquetTestColumns(), parquetCodecUncompressed),
+		}},
+		OutputLocation: "s3://bucket",
+		resultMode:     ResultModeParquetDL,
+		timeout:        10 * time.Second,
+	}
+
+	rows, err := c.runQuery(context.Background(), "SELECT * FROM foo")
+	require.NoError(t, err)
+	require.Len(t, client.started, 1)
+	assert.True(t, strings.Contains(*client.started[0].QueryString, "WITH (format='PARQUET', write_compression='SNAPPY') AS SELECT * FROM foo"))
+
+	ret := readAllRows(t, rows)
+	require.Len(t, ret, 3)
+	assert.Equal(t, []interface{}{int64(1), "foo", 123.45, 0.1}, []interface{}{ret[0][0], ret[0][1], ret[0][2], ret[0][3]})
+	assert.Equal(t, time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), ret[0][4])
+	assert.Equal(t, time.Date(2023, 1, 2, 3, 4, 5, 678000000, time.UTC), ret[0][5])
+	assert.Equal(t, []byte("hello"), ret[0][6])
+	assert.Nil(t, ret[1][1])
+	assert.Nil(t, ret[1][3])
+	assert.Nil(t, ret[2][2])
+}
+
+func TestConverter_convertRowFromParquet(t *testing.T) {
+	columns := []*athena.Column{
+		genTableColumn("name", "string"),
+		genTableColumn("price", "decimal(10,2)"),
+		genTableColumn("flag", "tinyint"),
+		genTableColumn("payload", "binary"),
+	}
+	in := []driver.Value{"\\N", parquetDecimal("1.50"), int64(1), []byte("hi")}
+	ret := make([]driver.Value, len(columns))
+
+	// a string `\N` isn't NULL, which is nil.
+	require.NoError(t, newConverter(&Config{}).convertRowFromParquet(columns, in, ret, nil))
+	assert.Equal(t, []driver.Value{"\\N", 1.5, int64(1), []byte("hi")}, ret)
+	require.NoError(t, newConverter(&Config{}).convertRowFromParquet(columns, []driver.Value{nil, nil, nil, nil}, ret, nil))
+	assert.Equal(t, []driver.Value{nil, nil, nil, nil}, ret)
+
+	require.NoError(t, newConverter(&Config{DecimalAsString: true, TinyintAsBool: true}).convertRowFromParquet(columns, in, ret, nil))
+	assert.Equal(t, []driver.Value{"\\N", "1.50", true, []byte("hi")}, ret)
+
+	// the value converter gets the text of CTAS TEXTFILE.
+	var raws []string
+	c := newConverter(&Config{ValueConverter: func(columnType, raw string) (driver.Value, bool, error) {
+		raws = append(raws, raw)
+		return nil, false, nil
+	}})
+	require.NoError(t, c.convertRowFromParquet(columns, in, ret, []bool{false, true, true, true}))
+	assert.Equal(t, []string{"1.50", "1", "aGk="}, raws)
+	assert.Equal(t, []driver.Value{nil, 1.5, int64(1), []byte("hi")}, ret)
+
+	// unconvertible values are the text.
+	c = newConverter(&Config{TinyintAsBool: true, UnconvertibleValueMode: UnconvertibleValueModeRawString})
+	require.NoError(t, c.convertRowFromParquet(columns, []driver.Value{"a", nil, int64(2), nil}, ret, nil))
+	assert.Equal(t, []driver.Value{"a", nil, "2", nil}, ret)
+
+	assert.Error(t, newConverter(&Config{}).convertRowFromParquet(columns, in[:2], ret, nil))
+}
@@ -0,0 +1,29 @@
+package athena
+
+import (
+	"database/sql/driver"
+	"errors"
+)
+
+// result is driver.Result of Exec. RowsAffected is the number of rows affected by DML statements,
+// e.g. INSERT INTO and DELETE, which Athena reports as UpdateCount of the results in API mode.
+// It's zero for the other statements.
+type result struct {
+	rowsAffected int64
+}
+
+func newResult(rows driver.Rows) result {
+	var r result
+	if api, ok := rows.(*rowsAPI); ok {
+		r.rowsAffected = api.updateCount
+	}
+	return r
+}
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("Athena doesn't support LastInsertId")
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
@@ -1,9 +1,32 @@
 package athena
 
 import (
+	"bufio"
 	"database/sql/driver"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// DuplicateColumnMode is how duplicate column names, e.g. two `id` columns of a join,
+// are returned by Columns.
+type DuplicateColumnMode int
+
+const (
+	// DuplicateColumnModeKeep returns the names as they are (default).
+	DuplicateColumnModeKeep DuplicateColumnMode = 0
+	// DuplicateColumnModeSuffix appends a suffix to the second and later duplicates, e.g. `id`, `id_2`.
+	DuplicateColumnModeSuffix DuplicateColumnMode = 1
+	// DuplicateColumnModeQualify qualifies duplicates by their table, e.g. `users.id`, `orders.id`,
+	// falling back to the suffix when Athena doesn't report the table.
+	DuplicateColumnModeQualify DuplicateColumnMode = 2
 )
 
 type rowsConfig struct {
@@ -11,18 +34,70 @@ type rowsConfig struct {
 	QueryID        string
 	SkipHeader     bool
 	ResultMode     ResultMode
-	Session        *session.Session
+	S3             s3iface.S3API
 	OutputLocation string
-	Timeout        uint
+	Timeout        time.Duration
 	AfterDownload  func() error
 	CTASTable      string
 	DB             string
 	Catalog        string
+	Converter      converter
+
+	// ResultLocation is the S3 location of the query result reported by Athena,
+	// which includes the path Athena adds to OutputLocation, e.g. "<OutputLocation>/<QueryID>.csv".
+	ResultLocation string
+	// ManifestLocation is the S3 location of the manifest of the files written by the query.
+	ManifestLocation string
+
+	DownloadConcurrency int
+	DownloadPrefetch    int
+	ProjectedColumns    []string
+	ValidateETag        bool
+	DuplicateColumnMode DuplicateColumnMode
+	// KeepCTASTableOnAbort keeps the CTAS table when the rows are closed before all rows are read.
+	KeepCTASTableOnAbort bool
+	// CTASFormat is the format of the CTAS table, e.g. "TEXTFILE".
+	CTASFormat string
+	// CTASFieldDelimiter is the field delimiter of the CTAS table of TEXTFILE. It's '\001' if it's empty.
+	CTASFieldDelimiter string
+	// CTASDatabase is the database of the CTAS table. It's DB if it's empty.
+	CTASDatabase string
+	// DownloadTimeout limits downloading the result instead of Timeout if it's positive.
+	DownloadTimeout time.Duration
+	// DownloadRetries is the number of times a failed object of GZIP DL mode is downloaded again.
+	DownloadRetries int
+	// MaxPages limits the pages read in API mode if it's positive, see Config.MaxPages.
+	MaxPages           int
+	TruncateAtMaxPages bool
+}
+
+// downloadTimeout returns the limit of downloading the result, which is Athena's default limit
+// of the queries unless a timeout is set, since the download of a result can't wait forever.
+func (cfg rowsConfig) downloadTimeout() time.Duration {
+	switch {
+	case cfg.DownloadTimeout > 0:
+		return cfg.DownloadTimeout
+	case cfg.Timeout > 0:
+		return cfg.Timeout
+	default:
+		return time.Duration(timeOutLimitDefault) * time.Second
+	}
+}
+
+// maxLineSize is the max size of a line of the downloaded results, which is a row.
+// The buffer of the lines grows up to it only as long lines are read.
+const maxLineSize = 1 << 30
+
+// newLineScanner returns a scanner of the lines of the downloaded results. Rows can be much wider than
+// the default max token size of bufio.Scanner, 64KB, e.g. with long JSON columns.
+func newLineScanner(reader io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+	return scanner
 }
 
 type downloadedRows struct {
 	cursor int
-	data   [][]string        // for gzip dl
 	field  [][]downloadField // for csv dl
 }
 
@@ -31,13 +106,87 @@ type downloadField struct {
 	isNil bool
 }
 
+// columnNames returns the names of the columns, handling duplicates by mode.
+func columnNames(columns []*athena.ColumnInfo, mode DuplicateColumnMode) []string {
+	names := make([]string, len(columns))
+	counts := make(map[string]int)
+	for i, col := range columns {
+		names[i] = aws.StringValue(col.Name)
+		counts[names[i]]++
+	}
+	if mode == DuplicateColumnModeKeep {
+		return names
+	}
+
+	if mode == DuplicateColumnModeQualify {
+		for i, col := range columns {
+			if counts[names[i]] > 1 && aws.StringValue(col.TableName) != "" {
+				names[i] = aws.StringValue(col.TableName) + "." + names[i]
+			}
+		}
+	}
+
+	used := make(map[string]bool)
+	for i, name := range names {
+		for n := 2; used[names[i]]; n++ {
+			names[i] = fmt.Sprintf("%s_%d", name, n)
+		}
+		used[names[i]] = true
+	}
+	return names
+}
+
+// columnInfoPrecisionScale returns the precision and scale of a decimal column.
+func columnInfoPrecisionScale(col *athena.ColumnInfo) (precision, scale int64, ok bool) {
+	if !strings.HasPrefix(aws.StringValue(col.Type), "decimal") {
+		return 0, 0, false
+	}
+	if col.Precision != nil {
+		return aws.Int64Value(col.Precision), aws.Int64Value(col.Scale), true
+	}
+	return parseDecimalType(aws.StringValue(col.Type))
+}
+
+// columnInfoNullable returns whether a column is nullable, unless Athena reports it as UNKNOWN.
+func columnInfoNullable(col *athena.ColumnInfo) (nullable, ok bool) {
+	switch aws.StringValue(col.Nullable) {
+	case athena.ColumnNullableNullable:
+		return true, true
+	case athena.ColumnNullableNotNull:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// parseDecimalType returns the precision and scale of a decimal type with them, e.g. `decimal(10,2)`.
+func parseDecimalType(athenaType string) (precision, scale int64, ok bool) {
+	params := strings.ReplaceAll(athenaType, " ", "")
+	if !strings.HasPrefix(params, "decimal(") || !strings.HasSuffix(params, ")") {
+		return 0, 0, false
+	}
+	params = strings.TrimSuffix(strings.TrimPrefix(params, "decimal("), ")")
+	p, s, found := strings.Cut(params, ",")
+	if !found {
+		s = "0"
+	}
+	var err error
+	if precision, err = strconv.ParseInt(p, 10, 64); err != nil {
+		return 0, 0, false
+	}
+	if scale, err = strconv.ParseInt(s, 10, 64); err != nil {
+		return 0, 0, false
+	}
+	return precision, scale, true
+}
+
 func newRows(cfg rowsConfig) (driver.Rows, error) {
 	var r driver.Rows
 	var err error
 	switch cfg.ResultMode {
 	case ResultModeDL:
 		r, err = newRowsDL(cfg)
-	case ResultModeGzipDL:
+	case ResultModeGzipDL, ResultModeParquetDL:
 		r, err = newRowsGzipDL(cfg)
 	default:
 		r, err = newRowsAPI(cfg)
@@ -45,3 +194,35 @@ func newRows(cfg rowsConfig) (driver.Rows, error) {
 
 	return r, err
 }
+
+// Every rows fetches its column metadata while it's constructed,
+// so that column types are available before the first Next call in all result modes.
+var (
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rowsAPI)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rowsDL)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rowsGzipDL)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rowsCached)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rowsCacheRecorder)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rowsShadow)(nil)
+
+	_ driver.RowsColumnTypePrecisionScale = (*rowsAPI)(nil)
+	_ driver.RowsColumnTypePrecisionScale = (*rowsDL)(nil)
+	_ driver.RowsColumnTypePrecisionScale = (*rowsGzipDL)(nil)
+	_ driver.RowsColumnTypePrecisionScale = (*rowsCached)(nil)
+	_ driver.RowsColumnTypePrecisionScale = (*rowsCacheRecorder)(nil)
+	_ driver.RowsColumnTypePrecisionScale = (*rowsShadow)(nil)
+
+	_ driver.RowsColumnTypeScanType = (*rowsAPI)(nil)
+	_ driver.RowsColumnTypeScanType = (*rowsDL)(nil)
+	_ driver.RowsColumnTypeScanType = (*rowsGzipDL)(nil)
+	_ driver.RowsColumnTypeScanType = (*rowsCached)(nil)
+	_ driver.RowsColumnTypeScanType = (*rowsCacheRecorder)(nil)
+	_ driver.RowsColumnTypeScanType = (*rowsShadow)(nil)
+
+	_ driver.RowsColumnTypeNullable = (*rowsAPI)(nil)
+	_ driver.RowsColumnTypeNullable = (*rowsDL)(nil)
+	_ driver.RowsColumnTypeNullable = (*rowsGzipDL)(nil)
+	_ driver.RowsColumnTypeNullable = (*rowsCached)(nil)
+	_ driver.RowsColumnTypeNullable = (*rowsCacheRecorder)(nil)
+	_ driver.RowsColumnTypeNullable = (*rowsShadow)(nil)
+)
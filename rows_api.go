@@ -3,21 +3,35 @@ package athena
 import (
 	"database/sql/driver"
 	"io"
+	"reflect"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
 )
 
+// rowsAPI reads the result by GetQueryResults. Pages are fetched lazily by Next when the rows
+// of the previous page are read, so only a page of up to 1000 rows is held at a time.
 type rowsAPI struct {
 	athena     athenaiface.AthenaAPI
 	queryID    string
 	resultMode ResultMode
+	converter  converter
+
+	duplicateColumnMode DuplicateColumnMode
 
 	// use only api mode
 	done          bool
 	skipHeaderRow bool
 	out           *athena.GetQueryResultsOutput
+
+	// updateCount is the number of rows affected by DML statements, e.g. INSERT INTO.
+	updateCount int64
+
+	// pages is the number of pages fetched, which is limited by maxPages if it's positive.
+	pages              int
+	maxPages           int
+	truncateAtMaxPages bool
 }
 
 func newRowsAPI(cfg rowsConfig) (*rowsAPI, error) {
@@ -26,6 +40,11 @@ func newRowsAPI(cfg rowsConfig) (*rowsAPI, error) {
 		queryID:       cfg.QueryID,
 		skipHeaderRow: cfg.SkipHeader,
 		resultMode:    cfg.ResultMode,
+		converter:     cfg.Converter,
+
+		duplicateColumnMode: cfg.DuplicateColumnMode,
+		maxPages:            cfg.MaxPages,
+		truncateAtMaxPages:  cfg.TruncateAtMaxPages,
 	}
 	err := r.init(cfg)
 	return r, err
@@ -36,6 +55,7 @@ func (r *rowsAPI) init(cfg rowsConfig) error {
 	if err != nil {
 		return err
 	}
+	r.updateCount = aws.Int64Value(r.out.UpdateCount)
 
 	r.done = !shouldContinue
 	return nil
@@ -50,6 +70,7 @@ func (r *rowsAPI) fetchNextPage(token *string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	r.pages++
 
 	var rowOffset = 0
 	// First row of the first page contains header if the query is not DDL.
@@ -78,6 +99,12 @@ func (r *rowsAPI) nextAPI(dest []driver.Value) error {
 		if r.out.NextToken == nil || *r.out.NextToken == "" {
 			return io.EOF
 		}
+		if r.maxPages > 0 && r.pages >= r.maxPages {
+			if r.truncateAtMaxPages {
+				return io.EOF
+			}
+			return ErrMaxPagesExceeded
+		}
 
 		cont, err := r.fetchNextPage(r.out.NextToken)
 		if err != nil {
@@ -92,7 +119,7 @@ func (r *rowsAPI) nextAPI(dest []driver.Value) error {
 	// Shift to next row
 	cur := r.out.ResultSet.Rows[0]
 	columns := r.out.ResultSet.ResultSetMetadata.ColumnInfo
-	if err := convertRow(columns, cur.Data, dest); err != nil {
+	if err := r.converter.convertRow(columns, cur.Data, dest); err != nil {
 		return err
 	}
 
@@ -101,12 +128,7 @@ func (r *rowsAPI) nextAPI(dest []driver.Value) error {
 }
 
 func (r *rowsAPI) Columns() []string {
-	var columns []string
-	for _, colInfo := range r.out.ResultSet.ResultSetMetadata.ColumnInfo {
-		columns = append(columns, *colInfo.Name)
-	}
-
-	return columns
+	return columnNames(r.out.ResultSet.ResultSetMetadata.ColumnInfo, r.duplicateColumnMode)
 }
 
 func (r *rowsAPI) ColumnTypeDatabaseTypeName(index int) string {
@@ -117,6 +139,19 @@ func (r *rowsAPI) ColumnTypeDatabaseTypeName(index int) string {
 	return ""
 }
 
+func (r *rowsAPI) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	return columnInfoPrecisionScale(r.out.ResultSet.ResultSetMetadata.ColumnInfo[index])
+}
+
+func (r *rowsAPI) ColumnTypeScanType(index int) reflect.Type {
+	colInfo := r.out.ResultSet.ResultSetMetadata.ColumnInfo[index]
+	return r.converter.scanType(aws.StringValue(colInfo.Name), aws.StringValue(colInfo.Type))
+}
+
+func (r *rowsAPI) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return columnInfoNullable(r.out.ResultSet.ResultSetMetadata.ColumnInfo[index])
+}
+
 func (r *rowsAPI) Next(dest []driver.Value) error {
 	return r.nextAPI(dest)
 }
@@ -1,19 +1,18 @@
 package athena
 
 import (
-	"bufio"
 	"context"
 	"database/sql/driver"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"io"
+	"reflect"
 	"strings"
-	"time"
 	"unicode/utf8"
 )
 
@@ -21,8 +20,11 @@ type rowsDL struct {
 	athena         athenaiface.AthenaAPI
 	queryID        string
 	resultMode     ResultMode
+	converter      converter
 	out            *athena.GetQueryResultsOutput
 	downloadedRows *downloadedRows
+
+	duplicateColumnMode DuplicateColumnMode
 }
 
 func newRowsDL(cfg rowsConfig) (*rowsDL, error) {
@@ -30,6 +32,9 @@ func newRowsDL(cfg rowsConfig) (*rowsDL, error) {
 		athena:     cfg.Athena,
 		queryID:    cfg.QueryID,
 		resultMode: cfg.ResultMode,
+		converter:  cfg.Converter,
+
+		duplicateColumnMode: cfg.DuplicateColumnMode,
 	}
 	err := r.init(cfg)
 	return r, err
@@ -37,13 +42,13 @@ func newRowsDL(cfg rowsConfig) (*rowsDL, error) {
 
 func (r *rowsDL) init(cfg rowsConfig) error {
 	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, cfg.downloadTimeout())
 	defer cancel()
 
 	err := make(chan error, 2)
 
 	// download and set in memory
-	go r.downloadCsvAsync(ctx, err, cfg.Session, cfg.OutputLocation)
+	go r.downloadCsvAsync(ctx, err, cfg.S3, csvLocation(cfg), cfg.SkipHeader)
 
 	// get table metadata
 	go r.getQueryResultsAsyncForCsv(ctx, err)
@@ -64,20 +69,34 @@ func (r *rowsDL) init(cfg rowsConfig) error {
 func (r *rowsDL) downloadCsvAsync(
 	ctx context.Context,
 	errCh chan error,
-	sess *session.Session,
+	s3Client s3iface.S3API,
 	location string,
+	skipHeader bool,
 ) {
-	errCh <- r.downloadCsv(sess, location)
+	errCh <- r.downloadCsv(ctx, s3Client, location, skipHeader)
 }
 
-func (r *rowsDL) downloadCsv(sess *session.Session, location string) error {
-	// remove the first 5 characters "s3://" from location
-	bucketName := location[5:]
-	objectKey := fmt.Sprintf("%s.csv", r.queryID)
+// csvLocation returns the S3 location of the csv file of the query result.
+func csvLocation(cfg rowsConfig) string {
+	if cfg.ResultLocation != "" {
+		return cfg.ResultLocation
+	}
+
+	location := strings.TrimSuffix(cfg.OutputLocation, "/")
+	return fmt.Sprintf("%s/%s.csv", location, cfg.QueryID)
+}
+
+// downloadCsv downloads the result file. Results of DDL queries, e.g. SHOW TABLES, have no header.
+// The download is stopped when ctx is done, e.g. by the download timeout.
+func (r *rowsDL) downloadCsv(ctx context.Context, s3Client s3iface.S3API, location string, skipHeader bool) error {
+	bucketName, objectKey, err := parseS3URI(location)
+	if err != nil {
+		return err
+	}
 
 	buff := &aws.WriteAtBuffer{}
-	downloader := s3manager.NewDownloader(sess)
-	_, err := downloader.Download(buff, &s3.GetObjectInput{
+	downloader := s3manager.NewDownloaderWithClient(s3Client)
+	_, err = downloader.DownloadWithContext(ctx, buff, &s3.GetObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(objectKey),
 	})
@@ -91,8 +110,11 @@ func (r *rowsDL) downloadCsv(sess *session.Session, location string) error {
 	if err != nil {
 		return err
 	}
+	if skipHeader && len(fields) > 0 {
+		fields = fields[1:]
+	}
 	r.downloadedRows = &downloadedRows{
-		field: fields[1:],
+		field: fields,
 	}
 
 	return nil
@@ -113,7 +135,7 @@ func (r *rowsDL) nextDownload(dest []driver.Value) error {
 	}
 	row := r.downloadedRows.field[r.downloadedRows.cursor]
 	columns := r.out.ResultSet.ResultSetMetadata.ColumnInfo
-	if err := convertRowFromCsv(columns, row, dest); err != nil {
+	if err := r.converter.convertRowFromCsv(columns, row, dest); err != nil {
 		return err
 	}
 
@@ -122,12 +144,7 @@ func (r *rowsDL) nextDownload(dest []driver.Value) error {
 }
 
 func (r *rowsDL) Columns() []string {
-	var columns []string
-	for _, colInfo := range r.out.ResultSet.ResultSetMetadata.ColumnInfo {
-		columns = append(columns, *colInfo.Name)
-	}
-
-	return columns
+	return columnNames(r.out.ResultSet.ResultSetMetadata.ColumnInfo, r.duplicateColumnMode)
 }
 
 func (r *rowsDL) ColumnTypeDatabaseTypeName(index int) string {
@@ -138,6 +155,19 @@ func (r *rowsDL) ColumnTypeDatabaseTypeName(index int) string {
 	return ""
 }
 
+func (r *rowsDL) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	return columnInfoPrecisionScale(r.out.ResultSet.ResultSetMetadata.ColumnInfo[index])
+}
+
+func (r *rowsDL) ColumnTypeScanType(index int) reflect.Type {
+	colInfo := r.out.ResultSet.ResultSetMetadata.ColumnInfo[index]
+	return r.converter.scanType(aws.StringValue(colInfo.Name), aws.StringValue(colInfo.Type))
+}
+
+func (r *rowsDL) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return columnInfoNullable(r.out.ResultSet.ResultSetMetadata.ColumnInfo[index])
+}
+
 func (r *rowsDL) Next(dest []driver.Value) error {
 	return r.nextDownload(dest)
 }
@@ -149,17 +179,15 @@ func (r *rowsDL) Close() error {
 func getRecordsForDL(reader io.Reader) ([][]downloadField, error) {
 	records := make([][]downloadField, 0)
 
-	scanner := bufio.NewScanner(reader)
+	scanner := newLineScanner(reader)
 
 	// read line by line
 	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
 		b := scanner.Bytes()
 		useDoubleQuote := false
 		delimiter := false
-		field := ""
+		// field is built by bytes, since appending to a string copies it for every rune of wide rows.
+		var field []byte
 		record := make([]downloadField, 0)
 		for {
 			r, width := utf8.DecodeRune(b)
@@ -171,7 +199,7 @@ func getRecordsForDL(reader io.Reader) ([][]downloadField, error) {
 				delimiter = true
 				if useDoubleQuote {
 					delimiter = false
-					if len(field) > 0 && field[len(field)-1:] == string('"') {
+					if len(field) > 0 && field[len(field)-1] == '"' {
 						field = field[1 : len(field)-1]
 						delimiter = true
 					}
@@ -182,24 +210,24 @@ func getRecordsForDL(reader io.Reader) ([][]downloadField, error) {
 				isNil := !useDoubleQuote && len(field) == 0
 				row := downloadField{
 					isNil: isNil,
-					val:   field,
+					val:   string(field),
 				}
 				record = append(record, row)
-				field = ""
+				field = field[:0]
 				delimiter = false
 			} else {
-				field += string(r)
+				field = utf8.AppendRune(field, r)
 			}
 			if width >= len(b) {
 				if useDoubleQuote {
-					if len(field) > 0 && field[len(field)-1:] == string('"') {
+					if len(field) > 0 && field[len(field)-1] == '"' {
 						field = field[1 : len(field)-1]
 					}
 				}
 				isNil := !useDoubleQuote && len(field) == 0
 				row := downloadField{
 					isNil: isNil,
-					val:   field,
+					val:   string(field),
 				}
 				record = append(record, row)
 				break
@@ -209,6 +237,9 @@ func getRecordsForDL(reader io.Reader) ([][]downloadField, error) {
 
 		records = append(records, record)
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
 	return records, nil
 }
@@ -2,39 +2,69 @@ package athena
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
 	"database/sql/driver"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"io"
+	"net/http"
+	"path"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
-	"unicode/utf8"
 )
 
 const (
 	CATALOG_AWS_DATA_CATALOG string = "AwsDataCatalog"
+
+	// downloadConcurrencyDefault is the default number of objects downloaded concurrently
+	downloadConcurrencyDefault int = 8
 )
 
 type rowsGzipDL struct {
 	athena     athenaiface.AthenaAPI
 	queryID    string
 	resultMode ResultMode
+	converter  converter
 
 	// use download
-	downloadedRows *downloadedRows
+	stream       *gzipShardStream
+	records      ctasRecords // records of the current object
+	cursor       int
+	validateETag bool
+	// downloadRetries is the number of times a failed object is downloaded again.
+	downloadRetries int
+
+	// cancel stops the download running in the background
+	cancel        context.CancelFunc
+	afterDownload func() error
+	finishOnce    sync.Once
+	finishErr     error
+	// keepOnAbort keeps the CTAS table when the rows are closed before all rows are read,
+	// so that the result can be read again with SetQueryExecutionID.
+	keepOnAbort bool
 
 	// ctas table
 	ctasTable        string
+	ctasFormat       string
+	fieldDelimiter   string
 	db               string
 	catalog          string
 	ctasTableColumns []*athena.Column
+
+	// projection is whether each column is converted. nil means all.
+	projectedColumns []string
+	projection       []bool
 }
 
 func newRowsGzipDL(cfg rowsConfig) (*rowsGzipDL, error) {
@@ -42,117 +72,565 @@ func newRowsGzipDL(cfg rowsConfig) (*rowsGzipDL, error) {
 		athena:     cfg.Athena,
 		queryID:    cfg.QueryID,
 		resultMode: cfg.ResultMode,
+		converter:  cfg.Converter,
 		ctasTable:  cfg.CTASTable,
+		ctasFormat: cfg.CTASFormat,
 		db:         cfg.DB,
 		catalog:    cfg.Catalog,
+
+		projectedColumns: cfg.ProjectedColumns,
+		validateETag:     cfg.ValidateETag,
+		keepOnAbort:      cfg.KeepCTASTableOnAbort,
+		downloadRetries:  cfg.DownloadRetries,
 	}
+	if cfg.CTASDatabase != "" {
+		r.db = cfg.CTASDatabase
+	}
+	r.fieldDelimiter = cfg.CTASFieldDelimiter
 	err := r.init(cfg)
 	return r, err
 }
 
 func (r *rowsGzipDL) init(cfg rowsConfig) error {
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
-	defer cancel()
+	// The objects are downloaded in the background while the rows are read,
+	// so ctx lives until all rows are read or the rows are closed. The download timeout limits
+	// reading the manifest and each download of an object, not reading the rows, which can be slow.
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	timeout := cfg.downloadTimeout()
+	initCtx, initCancel := context.WithTimeout(ctx, timeout)
+	defer initCancel()
 
 	err := make(chan error, 2)
 
-	// download and set in memory
-	go r.downloadCompressedDataAsync(ctx, err, cfg.Session, cfg.OutputLocation)
+	// start downloading
+	go r.downloadCompressedDataAsync(initCtx, ctx, err, cfg.S3, gzipManifestLocation(cfg), cfg.DownloadConcurrency, cfg.DownloadPrefetch, timeout)
 
 	// get table metadata
-	go r.getTableAsync(ctx, err)
+	go r.getTableAsync(initCtx, err)
 
 	for i := 0; i < 2; i++ {
 		select {
-		case <-ctx.Done():
-			return ctx.Err()
+		case <-initCtx.Done():
+			r.cancel()
+			return initCtx.Err()
 		case e := <-err:
 			if e != nil {
+				r.cancel()
 				return e
 			}
 		}
 	}
 
-	// drop ctas table
-	if cfg.AfterDownload != nil {
-		if e := cfg.AfterDownload(); e != nil {
-			return e
-		}
+	if e := r.setProjection(); e != nil {
+		r.cancel()
+		return e
 	}
 
+	// drop ctas table after all objects are downloaded
+	r.afterDownload = cfg.AfterDownload
+
 	return nil
 }
 
 func (r *rowsGzipDL) downloadCompressedDataAsync(
 	ctx context.Context,
+	streamCtx context.Context,
 	errCh chan error,
-	sess *session.Session,
-	location string,
+	s3Client s3iface.S3API,
+	manifest string,
+	concurrency int,
+	prefetch int,
+	timeout time.Duration,
 ) {
-	errCh <- r.downloadCompressedData(sess, location)
+	errCh <- r.downloadCompressedData(ctx, streamCtx, s3Client, manifest, concurrency, prefetch, timeout)
 }
 
-func (r *rowsGzipDL) downloadCompressedData(sess *session.Session, location string) error {
-	if location[len(location)-1:] == "/" {
-		location = location[:len(location)-1]
+// gzipManifestLocation returns the S3 location of the manifest of CTAS table.
+func gzipManifestLocation(cfg rowsConfig) string {
+	if cfg.ManifestLocation != "" {
+		return cfg.ManifestLocation
+	}
+	if cfg.ResultLocation != "" {
+		// the result location of CTAS query is "<OutputLocation>/tables/<QueryID>"
+		return cfg.ResultLocation + "-manifest.csv"
 	}
 
-	// remove the first 5 characters "s3://" from location
-	bucketName := location[5:]
+	location := strings.TrimSuffix(cfg.OutputLocation, "/")
+	return fmt.Sprintf("%s/tables/%s-manifest.csv", location, cfg.QueryID)
+}
+
+// downloadCompressedData reads the manifest of CTAS table by ctx, and starts downloading
+// the objects listed in it in the background until streamCtx is done.
+// Each download of an object is limited by timeout if it's positive.
+func (r *rowsGzipDL) downloadCompressedData(
+	ctx context.Context,
+	streamCtx context.Context,
+	s3Client s3iface.S3API,
+	manifest string,
+	concurrency int,
+	prefetch int,
+	timeout time.Duration,
+) error {
+	bucketName, manifestKey, err := parseS3URI(manifest)
+	if err != nil {
+		return err
+	}
 
 	// get gz file path
 	buff := &aws.WriteAtBuffer{}
 
-	downloader := s3manager.NewDownloader(sess)
-	_, err := downloader.Download(buff, &s3.GetObjectInput{
+	downloader := s3manager.NewDownloaderWithClient(s3Client)
+	_, err = downloader.DownloadWithContext(ctx, buff, &s3.GetObjectInput{
 		Bucket: aws.String(bucketName),
-		Key:    aws.String(fmt.Sprintf("tables/%s-manifest.csv", r.queryID)),
+		Key:    aws.String(manifestKey),
 	})
 	if err != nil {
 		return err
 	}
 
-	start := len(location) + 1 // the path is "location/objectKey"
-	objectKeys, err := getObjectKeysForGzip(strings.NewReader(string(buff.Bytes())), start)
+	// objects are listed by their full URIs, which can be in other buckets than the manifest.
+	locations, err := getObjectKeysForGzip(strings.NewReader(string(buff.Bytes())))
 	if err != nil {
 		return err
 	}
-
-	for _, objectKey := range objectKeys {
-		buff := &aws.WriteAtBuffer{}
-
-		_, err := downloader.Download(buff, &s3.GetObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(objectKey),
-		})
+	objects := make([]s3Object, 0, len(locations))
+	for _, location := range locations {
+		if location == "" {
+			continue
+		}
+		bucket, key, err := parseS3URI(location)
 		if err != nil {
 			return err
 		}
+		objects = append(objects, s3Object{bucket: bucket, key: key})
+	}
 
-		bfData := buff.Bytes()
+	var etags map[string]string
+	if r.validateETag && len(objects) > 0 {
+		etags = make(map[string]string)
+		listed := make(map[string]bool)
+		for _, obj := range objects {
+			prefix := path.Dir(obj.key) + "/"
+			if listed[obj.bucket+"/"+prefix] {
+				continue
+			}
+			listed[obj.bucket+"/"+prefix] = true
 
-		// decompress gzip
-		gzipReader, err := gzip.NewReader(strings.NewReader(string(bfData)))
-		if err != nil {
-			return err
+			objectETags, err := listObjectETags(ctx, s3Client, obj.bucket, prefix)
+			if err != nil {
+				return err
+			}
+			for key, etag := range objectETags {
+				etags[obj.bucket+"/"+key] = etag
+			}
 		}
+	}
 
-		datas, err := getRecordsFromGzip(gzipReader)
-		if err != nil {
-			return err
+	delim := r.fieldDelimiter
+	if delim == "" {
+		delim = textfileFieldDelimiter
+	}
+	decode := func(data []byte) (ctasRecords, error) {
+		fields, err := decodeGzipRecords(data, delim)
+		return ctasRecords{fields: fields}, err
+	}
+	if r.ctasFormat == ctasFormatParquet {
+		decode = func(data []byte) (ctasRecords, error) {
+			values, err := decodeParquetRecords(data)
+			return ctasRecords{values: values}, err
+		}
+	}
+	r.stream = newGzipShardStream(streamCtx, downloader, objects, etags, concurrency, prefetch, r.downloadRetries, timeout, decode)
+	return nil
+}
+
+// listObjectETags returns the ETags of the objects under prefix by their keys.
+func listObjectETags(ctx context.Context, s3Client s3iface.S3API, bucketName, prefix string) (map[string]string, error) {
+	etags := make(map[string]string)
+	err := s3Client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			etags[aws.StringValue(obj.Key)] = aws.StringValue(obj.ETag)
+		}
+		return true
+	})
+	return etags, err
+}
+
+// s3Object is the location of an S3 object.
+type s3Object struct {
+	bucket string
+	key    string
+}
+
+// ctasRecords are the records of an object of CTAS table, which are the fields of TEXTFILE,
+// or the values of PARQUET decoded in their types.
+type ctasRecords struct {
+	fields [][]string
+	values [][]driver.Value
+}
+
+func (r ctasRecords) len() int {
+	if r.values != nil {
+		return len(r.values)
+	}
+	return len(r.fields)
+}
+
+// gzipShard is the records of a downloaded object of CTAS table.
+type gzipShard struct {
+	records ctasRecords
+	err     error
+}
+
+// gzipShardStream downloads the objects of CTAS table concurrently in the background,
+// and decodes their records by decode, which depends on the format of the table.
+// Objects are always returned in the order of the manifest regardless of the concurrency,
+// and at most prefetch objects are held ahead of the reader, so that memory stays bounded
+// even if the rows are read slowly.
+//
+// When etags is not nil, each object is downloaded only if it still has the ETag, keyed by
+// "<bucket>/<key>", listed when the manifest was read, so that results rewritten in the meantime are detected.
+//
+// Each download of an object is limited by timeout if it's positive, so that the reader can take as long as
+// it needs between objects.
+//
+// A failed object is downloaded again by itself up to retries times, instead of restarting the whole read.
+// When an object still fails, the downloads of the objects after it are cancelled, since the reader fails
+// at it, and the objects before it are still returned.
+type gzipShardStream struct {
+	ctx     context.Context
+	shards  []chan gzipShard
+	window  chan struct{}
+	next    int
+	err     error // the error returned by nextShard, which is returned again by the following calls
+	etags   map[string]string
+	retries int
+	timeout time.Duration
+	decode  func(data []byte) (ctasRecords, error)
+
+	mu sync.Mutex
+	// failed is the lowest index of the failed objects, or the number of objects.
+	failed int
+	// cancels stops the downloads running by the indexes of their objects.
+	cancels map[int]context.CancelFunc
+}
+
+func newGzipShardStream(
+	ctx context.Context,
+	downloader *s3manager.Downloader,
+	objects []s3Object,
+	etags map[string]string,
+	concurrency int,
+	prefetch int,
+	retries int,
+	timeout time.Duration,
+	decode func(data []byte) (ctasRecords, error),
+) *gzipShardStream {
+	if concurrency <= 0 {
+		concurrency = downloadConcurrencyDefault
+	}
+	if prefetch <= 0 {
+		prefetch = concurrency
+	}
+
+	s := &gzipShardStream{
+		ctx:     ctx,
+		shards:  make([]chan gzipShard, len(objects)),
+		window:  make(chan struct{}, prefetch),
+		etags:   etags,
+		retries: retries,
+		timeout: timeout,
+		decode:  decode,
+
+		failed:  len(objects),
+		cancels: make(map[int]context.CancelFunc),
+	}
+	for i := range s.shards {
+		s.shards[i] = make(chan gzipShard, 1)
+	}
+
+	go s.run(downloader, objects, concurrency)
+	return s
+}
+
+func (s *gzipShardStream) run(downloader *s3manager.Downloader, objects []s3Object, concurrency int) {
+	sem := make(chan struct{}, concurrency)
+	for i, obj := range objects {
+		// wait for the reader to consume objects
+		select {
+		case s.window <- struct{}{}:
+		case <-s.ctx.Done():
+			return
+		}
+
+		var ifMatch *string
+		if s.etags != nil {
+			etag, ok := s.etags[obj.bucket+"/"+obj.key]
+			if !ok {
+				s.fail(i)
+				s.shards[i] <- gzipShard{err: fmt.Errorf("%w: s3://%s/%s is not found", ErrResultObjectChanged, obj.bucket, obj.key)}
+				return
+			}
+			ifMatch = aws.String(etag)
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-s.ctx.Done():
+			return
+		}
+
+		ctx, ok := s.start(i)
+		if !ok {
+			// the reader fails at an object before it.
+			return
+		}
+		go func(i int, obj s3Object, ifMatch *string) {
+			defer func() { <-sem }()
+			defer s.done(i)
+			data, err := s.download(ctx, downloader, obj, ifMatch)
+			var records ctasRecords
+			if err == nil {
+				records, err = s.decode(data)
+				putObjectBuffer(data)
+			}
+			if err != nil {
+				s.fail(i)
+			}
+			s.shards[i] <- gzipShard{records: records, err: err}
+		}(i, obj, ifMatch)
+	}
+}
+
+// download downloads an object, retrying it up to s.retries times unless the result is rewritten
+// or the download is cancelled.
+func (s *gzipShardStream) download(ctx context.Context, downloader *s3manager.Downloader, obj s3Object, ifMatch *string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		data, err := s.downloadOnce(ctx, downloader, obj, ifMatch)
+		if err == nil || attempt >= s.retries || ctx.Err() != nil || errors.Is(err, ErrResultObjectChanged) {
+			return data, err
+		}
+	}
+}
+
+// downloadOnce downloads an object once within s.timeout.
+func (s *gzipShardStream) downloadOnce(ctx context.Context, downloader *s3manager.Downloader, obj s3Object, ifMatch *string) ([]byte, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	return downloadObject(ctx, downloader, obj.bucket, obj.key, ifMatch)
+}
+
+// start returns the context of downloading the i-th object, or false if an object before it failed.
+func (s *gzipShardStream) start(i int) (context.Context, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i > s.failed {
+		return nil, false
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.cancels[i] = cancel
+	return ctx, true
+}
+
+// done releases the context of downloading the i-th object.
+func (s *gzipShardStream) done(i int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.cancels[i]; ok {
+		cancel()
+		delete(s.cancels, i)
+	}
+}
+
+// fail cancels the downloads of the objects after the i-th object, which failed.
+func (s *gzipShardStream) fail(i int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i >= s.failed {
+		return
+	}
+	s.failed = i
+	for j, cancel := range s.cancels {
+		if j > i {
+			cancel()
+		}
+	}
+}
+
+// nextShard returns the records of the next object, or io.EOF when all objects are read.
+func (s *gzipShardStream) nextShard() (ctasRecords, error) {
+	if s.err != nil {
+		return ctasRecords{}, s.err
+	}
+	if s.next >= len(s.shards) {
+		return ctasRecords{}, io.EOF
+	}
+
+	select {
+	case shard := <-s.shards[s.next]:
+		s.next++
+		<-s.window
+		s.err = shard.err
+		return shard.records, shard.err
+	case <-s.ctx.Done():
+		s.err = s.ctx.Err()
+		return ctasRecords{}, s.err
+	}
+}
+
+// downloadGzipObject downloads a gzip object of CTAS table and returns its records, whose fields are delimited by delim.
+// When ifMatch is not nil, the download fails with ErrResultObjectChanged if the ETag differs.
+func downloadGzipObject(
+	ctx context.Context,
+	downloader *s3manager.Downloader,
+	bucketName string,
+	objectKey string,
+	ifMatch *string,
+	delim string,
+) ([][]string, error) {
+	data, err := downloadObject(ctx, downloader, bucketName, objectKey, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	defer putObjectBuffer(data)
+	return decodeGzipRecords(data, delim)
+}
+
+// downloadObject downloads an object of CTAS table.
+// The returned data is in a pooled buffer, which the caller releases by putObjectBuffer once it's decoded.
+// When ifMatch is not nil, the download fails with ErrResultObjectChanged if the ETag differs.
+func downloadObject(
+	ctx context.Context,
+	downloader *s3manager.Downloader,
+	bucketName string,
+	objectKey string,
+	ifMatch *string,
+) ([]byte, error) {
+	buff := aws.NewWriteAtBuffer(getObjectBuffer())
+
+	_, err := downloader.DownloadWithContext(ctx, buff, &s3.GetObjectInput{
+		Bucket:  aws.String(bucketName),
+		Key:     aws.String(objectKey),
+		IfMatch: ifMatch,
+	})
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusPreconditionFailed {
+		return nil, fmt.Errorf("%w: s3://%s/%s", ErrResultObjectChanged, bucketName, objectKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// textfileFieldDelimiter is Athena's default field delimiter of CTAS TEXTFILE.
+const textfileFieldDelimiter = "\001"
+
+// decodeGzipRecords decodes the records of an object of CTAS TEXTFILE, whose fields are delimited by delim.
+// Objects written with write_compression='NONE' aren't compressed, which is told by the magic of gzip.
+func decodeGzipRecords(data []byte, delim string) ([][]string, error) {
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		return getRecordsFromGzip(bytes.NewReader(data), delim)
+	}
+
+	// decompress gzip
+	gzipReader, err := getGzipReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReaderPool.Put(gzipReader)
+
+	return getRecordsFromGzip(gzipReader, delim)
+}
+
+// Buffers of the downloaded objects, gzip readers and the buffers of the lines are reused among the objects
+// of all queries, since allocating them for every object pressures GC of high-throughput services.
+// The records don't refer to them, since they are copied from the lines.
+var (
+	objectBufferPool sync.Pool // *[]byte
+	gzipReaderPool   sync.Pool // *gzip.Reader
+	lineBufferPool   = sync.Pool{
+		New: func() interface{} {
+			return &lineReader{reader: bufio.NewReaderSize(nil, bufio.MaxScanTokenSize)}
+		},
+	}
+)
+
+// lineReader reads the lines of an object like bufio.Scanner, but keeps the buffer of the lines wider
+// than the buffer of its reader, so the buffer grown by wide rows is reused by lineBufferPool too.
+type lineReader struct {
+	reader *bufio.Reader
+	line   []byte
+}
+
+// readLine returns the next line without its line terminator, which is valid until the next call, or io.EOF.
+func (lr *lineReader) readLine() ([]byte, error) {
+	lr.line = lr.line[:0]
+	for {
+		chunk, err := lr.reader.ReadSlice('\n')
+		if len(lr.line)+len(chunk) > maxLineSize {
+			return nil, bufio.ErrTooLong
 		}
-		if r.downloadedRows == nil {
-			r.downloadedRows = &downloadedRows{
-				data: make([][]string, 0, len(datas)*len(objectKeys)),
+		switch err {
+		case nil:
+			// a line within the buffer of the reader isn't copied.
+			line := chunk
+			if len(lr.line) > 0 {
+				lr.line = append(lr.line, chunk...)
+				line = lr.line
+			}
+			return bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'}), nil
+		case bufio.ErrBufferFull:
+			lr.line = append(lr.line, chunk...)
+		case io.EOF:
+			// the last line may not end with a line terminator.
+			lr.line = append(lr.line, chunk...)
+			if len(lr.line) == 0 {
+				return nil, io.EOF
 			}
+			return bytes.TrimSuffix(lr.line, []byte{'\r'}), nil
+		default:
+			return nil, err
 		}
-		r.downloadedRows.data = append(r.downloadedRows.data, datas...)
 	}
+}
 
+// getObjectBuffer returns an empty buffer of a downloaded object.
+func getObjectBuffer() []byte {
+	if buf, ok := objectBufferPool.Get().(*[]byte); ok {
+		return (*buf)[:0]
+	}
 	return nil
 }
 
+// putObjectBuffer releases the buffer of a downloaded object.
+func putObjectBuffer(data []byte) {
+	if cap(data) == 0 {
+		return
+	}
+	data = data[:0]
+	objectBufferPool.Put(&data)
+}
+
+// getGzipReader returns a gzip reader of r, which is released by gzipReaderPool.Put.
+func getGzipReader(r io.Reader) (*gzip.Reader, error) {
+	gzipReader, ok := gzipReaderPool.Get().(*gzip.Reader)
+	if !ok {
+		return gzip.NewReader(r)
+	}
+	if err := gzipReader.Reset(r); err != nil {
+		gzipReaderPool.Put(gzipReader)
+		return nil, err
+	}
+	return gzipReader, nil
+}
+
 func (r *rowsGzipDL) getTableAsync(ctx context.Context, errCh chan error) {
 	data, err := r.athena.GetTableMetadata(&athena.GetTableMetadataInput{
 		CatalogName:  aws.String(r.catalog),
@@ -164,24 +642,83 @@ func (r *rowsGzipDL) getTableAsync(ctx context.Context, errCh chan error) {
 		return
 	}
 
+	// the CTAS table has no partitions, so the columns are in the order of the SELECT projection,
+	// the same as the other modes and the fields of the objects.
 	r.ctasTableColumns = data.TableMetadata.Columns
 	errCh <- nil
 }
 
+func (r *rowsGzipDL) setProjection() error {
+	if len(r.projectedColumns) == 0 {
+		return nil
+	}
+
+	r.projection = make([]bool, len(r.ctasTableColumns))
+	for _, name := range r.projectedColumns {
+		found := false
+		for i, col := range r.ctasTableColumns {
+			if strings.EqualFold(*col.Name, name) {
+				r.projection[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("projected column `%s` is not in the result", name)
+		}
+	}
+	return nil
+}
+
 func (r *rowsGzipDL) nextCTAS(dest []driver.Value) error {
-	if r.downloadedRows.cursor >= len(r.downloadedRows.data) {
-		return io.EOF
+	for r.cursor >= r.records.len() {
+		if r.stream == nil {
+			return io.EOF
+		}
+
+		records, err := r.stream.nextShard()
+		if err == io.EOF {
+			if err := r.finish(true); err != nil {
+				return err
+			}
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+
+		r.records = records
+		r.cursor = 0
 	}
 
-	row := r.downloadedRows.data[r.downloadedRows.cursor]
-	if err := convertRowFromTableInfo(r.ctasTableColumns, row, dest); err != nil {
+	if r.records.values != nil {
+		if err := r.converter.convertRowFromParquet(r.ctasTableColumns, r.records.values[r.cursor], dest, r.projection); err != nil {
+			return err
+		}
+	} else if err := r.converter.convertRowFromTableInfo(r.ctasTableColumns, r.records.fields[r.cursor], dest, r.projection); err != nil {
 		return err
 	}
 
-	r.downloadedRows.cursor++
+	r.cursor++
 	return nil
 }
 
+// finish stops the download and drops the CTAS table. It runs only once.
+// completed is whether all rows are read.
+func (r *rowsGzipDL) finish(completed bool) error {
+	r.finishOnce.Do(func() {
+		if r.cancel != nil {
+			r.cancel()
+		}
+		if !completed && r.keepOnAbort {
+			return
+		}
+		if r.afterDownload != nil {
+			r.finishErr = r.afterDownload()
+		}
+	})
+	return r.finishErr
+}
+
 func (r *rowsGzipDL) columnTypeDatabaseTypeNameForCTAS(index int) string {
 	column := r.ctasTableColumns[index]
 	if column == nil || column.Type == nil {
@@ -204,64 +741,66 @@ func (r *rowsGzipDL) ColumnTypeDatabaseTypeName(index int) string {
 	return r.columnTypeDatabaseTypeNameForCTAS(index)
 }
 
+func (r *rowsGzipDL) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	// the types of the CTAS table are reported by Glue with the parameters, e.g. `decimal(10,2)`.
+	return parseDecimalType(r.columnTypeDatabaseTypeNameForCTAS(index))
+}
+
+func (r *rowsGzipDL) ColumnTypeScanType(index int) reflect.Type {
+	return r.converter.scanType(aws.StringValue(r.ctasTableColumns[index].Name), r.columnTypeDatabaseTypeNameForCTAS(index))
+}
+
+// ColumnTypeNullable doesn't know the nullability, since the columns of the CTAS table don't have it.
+func (r *rowsGzipDL) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return false, false
+}
+
 func (r *rowsGzipDL) Next(dest []driver.Value) error {
 	return r.nextCTAS(dest)
 }
 
 func (r *rowsGzipDL) Close() error {
-	return nil
+	return r.finish(false)
 }
 
-func getObjectKeysForGzip(reader io.Reader, start int) ([]string, error) {
+func getObjectKeysForGzip(reader io.Reader) ([]string, error) {
 
 	keys := make([]string, 0)
-	scanner := bufio.NewScanner(reader)
+	scanner := newLineScanner(reader)
 
 	// read line by line
 	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
-		k := scanner.Text()
-		if start > 0 && len(k) > start {
-			k = k[start:]
-		}
-		keys = append(keys, k)
+		keys = append(keys, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
 
 	return keys, nil
 }
 
-func getRecordsFromGzip(reader io.Reader) ([][]string, error) {
+func getRecordsFromGzip(reader io.Reader, delim string) ([][]string, error) {
 	records := make([][]string, 0)
 
-	scanner := bufio.NewScanner(reader)
+	lr := lineBufferPool.Get().(*lineReader)
+	lr.reader.Reset(reader)
+	defer func() {
+		// the reader isn't kept by the pool.
+		lr.reader.Reset(nil)
+		lineBufferPool.Put(lr)
+	}()
 
 	// read line by line
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
+	for {
+		line, err := lr.readLine()
+		if err == io.EOF {
+			return records, nil
 		}
-		b := scanner.Bytes()
-		field := ""
-		record := make([]string, 0)
-		for {
-			r, width := utf8.DecodeRune(b)
-			if r == '\001' {
-				record = append(record, field)
-				field = ""
-			} else {
-				field += string(r)
-			}
-			if width >= len(b) {
-				record = append(record, field)
-				break
-			}
-			b = b[width:]
+		if err != nil {
+			return nil, err
 		}
-
+		// fields are split by bytes, since the delimiter is ASCII and fields may not be valid UTF-8.
+		record := strings.Split(string(line), delim)
 		records = append(records, record)
 	}
-
-	return records, nil
 }
@@ -0,0 +1,633 @@
+package athena
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockS3Client struct {
+	s3iface.S3API
+
+	objects map[string][]byte // key is "bucket/key"
+	delay   time.Duration
+	delays  map[string]time.Duration // delay per "bucket/key", overriding delay
+	etags   map[string]string        // ETag per "bucket/key", checked against IfMatch
+	// failures is the number of times each "bucket/key" fails before it's returned.
+	failures map[string]int
+
+	mu          sync.Mutex
+	requests    int
+	inFlight    int
+	maxInFlight int
+}
+
+func (m *mockS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	m.requests++
+	m.inFlight++
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.inFlight--
+		m.mu.Unlock()
+	}()
+
+	delay := m.delay
+	if d, ok := m.delays[*input.Bucket+"/"+*input.Key]; ok {
+		delay = d
+	}
+	if delay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	m.mu.Lock()
+	failed := m.failures[*input.Bucket+"/"+*input.Key] > 0
+	if failed {
+		m.failures[*input.Bucket+"/"+*input.Key]--
+	}
+	m.mu.Unlock()
+	if failed {
+		return nil, awserr.New(request.ErrCodeRequestError, "connection reset", nil)
+	}
+
+	body, ok := m.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found: "+*input.Key, nil)
+	}
+	m.mu.Lock()
+	etag := m.etags[*input.Bucket+"/"+*input.Key]
+	m.mu.Unlock()
+	if input.IfMatch != nil && *input.IfMatch != etag {
+		return nil, awserr.NewRequestFailure(awserr.New("PreconditionFailed", "precondition failed", nil), http.StatusPreconditionFailed, "")
+	}
+
+	total := len(body)
+	begin, end := 0, total-1
+	if input.Range != nil {
+		fmt.Sscanf(*input.Range, "bytes=%d-%d", &begin, &end)
+		if end >= total {
+			end = total - 1
+		}
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(body[begin : end+1])),
+		ContentLength: aws.Int64(int64(end + 1 - begin)),
+		ContentRange:  aws.String(fmt.Sprintf("bytes %d-%d/%d", begin, end, total)),
+	}, nil
+}
+
+func (m *mockS3Client) HeadObjectWithContext(_ aws.Context, input *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	body, ok := m.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awserr.New("NotFound", "not found: "+*input.Key, nil)
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(body))),
+		ETag:          aws.String(m.etags[*input.Bucket+"/"+*input.Key]),
+		LastModified:  aws.Time(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)),
+	}, nil
+}
+
+func (m *mockS3Client) ListObjectsV2PagesWithContext(_ aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, _ ...request.Option) error {
+	prefix := *input.Bucket + "/" + *input.Prefix
+	page := &s3.ListObjectsV2Output{}
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			page.Contents = append(page.Contents, &s3.Object{
+				Key:  aws.String(strings.TrimPrefix(key, *input.Bucket+"/")),
+				ETag: aws.String(m.etags[key]),
+				Size: aws.Int64(int64(len(m.objects[key]))),
+			})
+		}
+	}
+	fn(page, true)
+	return nil
+}
+
+func genGzipObject(t testing.TB, records [][]string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	for _, record := range records {
+		_, err := w.Write([]byte(strings.Join(record, "\001") + "\n"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+// genCTASObjects returns the objects of a CTAS table split into numObjects,
+// and its records in the manifest order.
+func genCTASObjects(t *testing.T, queryID string, numObjects int) (map[string][]byte, [][]string) {
+	objects := make(map[string][]byte)
+	var manifest []string
+	var expected [][]string
+	for i := 0; i < numObjects; i++ {
+		key := fmt.Sprintf("tables/%s/%05d.gz", queryID, i)
+		var records [][]string
+		for j := 0; j < 3; j++ {
+			records = append(records, []string{fmt.Sprintf("%d", i), fmt.Sprintf("%d", j)})
+		}
+		objects["bucket/"+key] = genGzipObject(t, records)
+		manifest = append(manifest, "s3://bucket/"+key)
+		expected = append(expected, records...)
+	}
+	objects[fmt.Sprintf("bucket/tables/%s-manifest.csv", queryID)] = []byte(strings.Join(manifest, "\n"))
+	return objects, expected
+}
+
+// readAllShards reads all records from the stream of r.
+func readAllShards(r *rowsGzipDL) ([][]string, error) {
+	var ret [][]string
+	for {
+		records, err := r.stream.nextShard()
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, records.fields...)
+	}
+}
+
+func TestRowsGzipDL_downloadCompressedDataConcurrency(t *testing.T) {
+	objects, expected := genCTASObjects(t, "q", 20)
+	client := &mockS3Client{objects: objects, delay: 5 * time.Millisecond}
+
+	r := &rowsGzipDL{queryID: "q"}
+	err := r.downloadCompressedData(context.Background(), context.Background(), client, "s3://bucket/tables/q-manifest.csv", 3, 0, 0)
+	require.NoError(t, err)
+
+	got, err := readAllShards(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, got)
+	assert.LessOrEqual(t, client.maxInFlight, 3)
+	assert.Greater(t, client.maxInFlight, 1)
+}
+
+func TestRowsGzipDL_downloadCompressedDataError(t *testing.T) {
+	objects, _ := genCTASObjects(t, "q", 5)
+	delete(objects, "bucket/tables/q/00002.gz")
+	client := &mockS3Client{objects: objects}
+
+	r := &rowsGzipDL{queryID: "q"}
+	err := r.downloadCompressedData(context.Background(), context.Background(), client, "s3://bucket/tables/q-manifest.csv", 2, 0, 0)
+	require.NoError(t, err)
+
+	got, err := readAllShards(r)
+	assert.Error(t, err)
+	assert.Len(t, got, 6, "records of the objects before the missing one")
+}
+
+func TestRowsGzipDL_downloadCompressedDataErrorCancels(t *testing.T) {
+	objects, _ := genCTASObjects(t, "q", 5)
+	delete(objects, "bucket/tables/q/00001.gz")
+	client := &mockS3Client{objects: objects, delays: map[string]time.Duration{
+		"bucket/tables/q/00002.gz": time.Minute,
+		"bucket/tables/q/00003.gz": time.Minute,
+	}}
+
+	r := &rowsGzipDL{queryID: "q"}
+	err := r.downloadCompressedData(context.Background(), context.Background(), client, "s3://bucket/tables/q-manifest.csv", 4, 0, 0)
+	require.NoError(t, err)
+
+	got, err := readAllShards(r)
+	assert.Error(t, err)
+	assert.Len(t, got, 3, "records of the object before the missing one")
+	_, again := r.stream.nextShard()
+	assert.Equal(t, err, again)
+
+	// the downloads of the objects after the missing one are cancelled.
+	assert.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.inFlight == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestRowsGzipDL_downloadCompressedDataPrefetch(t *testing.T) {
+	objects, expected := genCTASObjects(t, "q", 10)
+	client := &mockS3Client{objects: objects}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &rowsGzipDL{queryID: "q"}
+	err := r.downloadCompressedData(ctx, ctx, client, "s3://bucket/tables/q-manifest.csv", 4, 2, 0)
+	require.NoError(t, err)
+
+	// nothing is read yet, so only the manifest and 2 objects are downloaded.
+	time.Sleep(20 * time.Millisecond)
+	client.mu.Lock()
+	assert.Equal(t, 3, client.requests)
+	client.mu.Unlock()
+
+	got, err := readAllShards(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, got)
+	assert.Equal(t, 11, client.requests)
+}
+
+func genTableColumn(name, columnType string) *athena.Column {
+	return &athena.Column{Name: aws.String(name), Type: aws.String(columnType)}
+}
+
+func TestRowsGzipDL_ColumnProjection(t *testing.T) {
+	r := &rowsGzipDL{
+		ctasTableColumns: []*athena.Column{
+			genTableColumn("id", "bigint"),
+			genTableColumn("name", "string"),
+			genTableColumn("score", "double"),
+		},
+		records:          ctasRecords{fields: [][]string{{"1", "foo", "not a double"}}},
+		projectedColumns: []string{"ID", "name"},
+	}
+	require.NoError(t, r.setProjection())
+
+	dest := make([]driver.Value, 3)
+	require.NoError(t, r.Next(dest))
+	assert.Equal(t, []driver.Value{int64(1), "foo", nil}, dest)
+
+	r.projectedColumns = []string{"unknown"}
+	assert.Error(t, r.setProjection())
+}
+
+func TestRowsGzipDL_ColumnTypePrecisionScale(t *testing.T) {
+	r := &rowsGzipDL{
+		ctasTableColumns: []*athena.Column{
+			genTableColumn("price", "decimal(38,10)"),
+			genTableColumn("score", "double"),
+		},
+	}
+
+	precision, scale, ok := r.ColumnTypePrecisionScale(0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(38), precision)
+	assert.Equal(t, int64(10), scale)
+
+	_, _, ok = r.ColumnTypePrecisionScale(1)
+	assert.False(t, ok)
+
+	assert.Equal(t, scanTypeFloat64, r.ColumnTypeScanType(0))
+	assert.Equal(t, scanTypeFloat64, r.ColumnTypeScanType(1))
+
+	_, ok = r.ColumnTypeNullable(0)
+	assert.False(t, ok)
+}
+
+func TestRowsGzipDL_ManifestOrder(t *testing.T) {
+	objects, expected := genCTASObjects(t, "q", 10)
+
+	// later objects in the manifest finish downloading earlier.
+	delays := make(map[string]time.Duration)
+	for i := 0; i < 10; i++ {
+		delays[fmt.Sprintf("bucket/tables/q/%05d.gz", i)] = time.Duration(10-i) * time.Millisecond
+	}
+
+	for _, concurrency := range []int{1, 3, 10} {
+		client := &mockS3Client{objects: objects, delays: delays}
+		r := &rowsGzipDL{
+			queryID: "q",
+			ctasTableColumns: []*athena.Column{
+				genTableColumn("shard", "string"),
+				genTableColumn("record", "string"),
+			},
+		}
+		require.NoError(t, r.downloadCompressedData(context.Background(), context.Background(), client, "s3://bucket/tables/q-manifest.csv", concurrency, 0, 0))
+
+		var got [][]string
+		for {
+			dest := make([]driver.Value, 2)
+			err := r.Next(dest)
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			got = append(got, []string{dest[0].(string), dest[1].(string)})
+		}
+		assert.Equal(t, expected, got, fmt.Sprintf("concurrency: %d", concurrency))
+	}
+}
+
+func TestRowsGzipDL_downloadCompressedDataValidateETag(t *testing.T) {
+	objects, expected := genCTASObjects(t, "q", 3)
+	etags := make(map[string]string)
+	for key := range objects {
+		etags[key] = "etag-" + key
+	}
+	client := &mockS3Client{objects: objects, etags: etags}
+
+	r := &rowsGzipDL{queryID: "q", validateETag: true}
+	require.NoError(t, r.downloadCompressedData(context.Background(), context.Background(), client, "s3://bucket/tables/q-manifest.csv", 1, 1, 0))
+	got, err := readAllShards(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, got)
+
+	// an object is rewritten after the manifest is read
+	r = &rowsGzipDL{queryID: "q", validateETag: true}
+	require.NoError(t, r.downloadCompressedData(context.Background(), context.Background(), client, "s3://bucket/tables/q-manifest.csv", 1, 1, 0))
+	client.mu.Lock()
+	etags["bucket/tables/q/00002.gz"] = "rewritten"
+	client.mu.Unlock()
+	_, err = readAllShards(r)
+	assert.True(t, errors.Is(err, ErrResultObjectChanged), err)
+}
+
+func TestRowsGzipDL_KeepCTASTableOnAbort(t *testing.T) {
+	dropped := 0
+	r := &rowsGzipDL{keepOnAbort: true, afterDownload: func() error { dropped++; return nil }}
+	require.NoError(t, r.Close())
+	assert.Equal(t, 0, dropped)
+
+	r = &rowsGzipDL{keepOnAbort: true, afterDownload: func() error { dropped++; return nil }}
+	require.NoError(t, r.finish(true))
+	require.NoError(t, r.Close())
+	assert.Equal(t, 1, dropped)
+
+	r = &rowsGzipDL{afterDownload: func() error { dropped++; return nil }}
+	require.NoError(t, r.Close())
+	assert.Equal(t, 2, dropped)
+}
+
+func Test_getRecordsFromGzipWideRow(t *testing.T) {
+	wide := strings.Repeat("x", 200*1024)
+	records, err := getRecordsFromGzip(strings.NewReader("a\001"+wide+"\nb\001c\n"), textfileFieldDelimiter)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"a", wide}, {"b", "c"}}, records)
+}
+
+func Test_getRecordsFromGzip(t *testing.T) {
+	records, err := getRecordsFromGzip(strings.NewReader("a\001b\n\001\n\nc\001\n\xff\001d\n"), textfileFieldDelimiter)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"a", "b"}, {"", ""}, {""}, {"c", ""}, {"\xff", "d"}}, records)
+
+	// CRLF and the last line without a line terminator.
+	records, err = getRecordsFromGzip(strings.NewReader("a\001b\r\nc\r"), textfileFieldDelimiter)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"a", "b"}, {"c"}}, records)
+
+	// field_delimiter of the CTAS table.
+	records, err = getRecordsFromGzip(strings.NewReader("a,b\001c\n,\n"), ",")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"a", "b\001c"}, {"", ""}}, records)
+	records, err = getRecordsFromGzip(strings.NewReader("a\tb,c\n\t\n"), "\t")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"a", "b,c"}, {"", ""}}, records)
+}
+
+func BenchmarkGetRecordsFromGzip(b *testing.B) {
+	for _, bench := range []struct {
+		name  string
+		rows  int
+		width int
+	}{
+		{name: "narrow", rows: 1000, width: 1000},
+		// rows wider than the buffer of the reader, 64KB.
+		{name: "wide", rows: 10, width: 200 * 1024},
+	} {
+		var buf bytes.Buffer
+		for i := 0; i < bench.rows; i++ {
+			fmt.Fprintf(&buf, "%d\001%s\001\n", i, strings.Repeat("x", bench.width))
+		}
+		data := buf.Bytes()
+
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				_, err := getRecordsFromGzip(bytes.NewReader(data), textfileFieldDelimiter)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+func TestRowsGzipDL_RecordAlignment(t *testing.T) {
+	r := &rowsGzipDL{
+		ctasTableColumns: []*athena.Column{
+			genTableColumn("id", "bigint"),
+			genTableColumn("name", "string"),
+		},
+		// a trailing empty field, a missing field, and an extra field.
+		records: ctasRecords{fields: [][]string{{"1", "foo", ""}, {"2"}, {"3", "bar", "baz"}}},
+	}
+
+	dest := make([]driver.Value, 2)
+	require.NoError(t, r.Next(dest))
+	assert.Equal(t, []driver.Value{int64(1), "foo"}, dest)
+	require.NoError(t, r.Next(dest))
+	assert.Equal(t, []driver.Value{int64(2), nil}, dest)
+	assert.Error(t, r.Next(dest))
+}
+
+func TestRowsGzipDL_Null(t *testing.T) {
+	r := &rowsGzipDL{
+		ctasTableColumns: []*athena.Column{
+			genTableColumn("id", "bigint"),
+			genTableColumn("score", "double"),
+			genTableColumn("name", "string"),
+		},
+		// NULL is `\N` in TEXTFILE, so an empty field is an empty string.
+		records: ctasRecords{fields: [][]string{{"1", "\\N", ""}, {"\\N", "0.5", "\\N"}}},
+	}
+
+	dest := make([]driver.Value, 3)
+	require.NoError(t, r.Next(dest))
+	assert.Equal(t, []driver.Value{int64(1), nil, ""}, dest)
+	require.NoError(t, r.Next(dest))
+	assert.Equal(t, []driver.Value{nil, 0.5, nil}, dest)
+}
+
+func TestRowsGzipDL_downloadCompressedDataCrossBucket(t *testing.T) {
+	objects := map[string][]byte{
+		"bucket/tables/q-manifest.csv": []byte("s3://bucket/tables/q/00000.gz\ns3://other/exports/q/00001.gz\n"),
+		"bucket/tables/q/00000.gz":     genGzipObject(t, [][]string{{"a"}}),
+		"other/exports/q/00001.gz":     genGzipObject(t, [][]string{{"b"}}),
+	}
+	etags := map[string]string{"bucket/tables/q/00000.gz": "e0", "other/exports/q/00001.gz": "e1"}
+
+	for _, validate := range []bool{false, true} {
+		r := &rowsGzipDL{
+			ctasTableColumns: []*athena.Column{genTableColumn("name", "string")},
+			validateETag:     validate,
+		}
+		client := &mockS3Client{objects: objects, etags: etags}
+		require.NoError(t, r.downloadCompressedData(context.Background(), context.Background(), client, "s3://bucket/tables/q-manifest.csv", 2, 0, 0))
+
+		var got []string
+		for {
+			dest := make([]driver.Value, 1)
+			err := r.Next(dest)
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			got = append(got, dest[0].(string))
+		}
+		assert.Equal(t, []string{"a", "b"}, got, "validate: %v", validate)
+	}
+}
+
+func TestRowsGzipDL_DownloadTimeout(t *testing.T) {
+	objects, _ := genCTASObjects(t, "q", 1)
+	newConfig := func(timeout, downloadTimeout time.Duration) rowsConfig {
+		return rowsConfig{
+			Athena:           &mockAthenaConnClient{tableColumns: []*athena.Column{genTableColumn("a", "string")}},
+			QueryID:          "q",
+			ResultMode:       ResultModeGzipDL,
+			S3:               &mockS3Client{objects: objects, delay: 50 * time.Millisecond},
+			ManifestLocation: "s3://bucket/tables/q-manifest.csv",
+			Timeout:          timeout,
+			DownloadTimeout:  downloadTimeout,
+		}
+	}
+
+	// the download is limited by DownloadTimeout instead of Timeout.
+	_, err := newRowsGzipDL(newConfig(10*time.Second, 10*time.Millisecond))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	r, err := newRowsGzipDL(newConfig(10*time.Millisecond, 10*time.Second))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	// it defaults to Timeout.
+	_, err = newRowsGzipDL(newConfig(10*time.Millisecond, 0))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestRowsGzipDL_DownloadTimeoutSlowReader(t *testing.T) {
+	objects, expected := genCTASObjects(t, "q", 3)
+	newConfig := func(s3Client *mockS3Client) rowsConfig {
+		return rowsConfig{
+			Athena:           &mockAthenaConnClient{tableColumns: []*athena.Column{genTableColumn("a", "string"), genTableColumn("b", "string")}},
+			QueryID:          "q",
+			ResultMode:       ResultModeGzipDL,
+			S3:               s3Client,
+			ManifestLocation: "s3://bucket/tables/q-manifest.csv",
+			Timeout:          10 * time.Second,
+			DownloadTimeout:  50 * time.Millisecond,
+			DownloadPrefetch: 1,
+		}
+	}
+
+	// reading the rows for longer than the timeout doesn't fail them.
+	r, err := newRowsGzipDL(newConfig(&mockS3Client{objects: objects}))
+	require.NoError(t, err)
+	var got [][]string
+	for {
+		dest := make([]driver.Value, 2)
+		err := r.Next(dest)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, []string{dest[0].(string), dest[1].(string)})
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, expected, got)
+	require.NoError(t, r.Close())
+
+	// each download of an object is still limited.
+	r, err = newRowsGzipDL(newConfig(&mockS3Client{objects: objects, delays: map[string]time.Duration{"bucket/tables/q/00001.gz": time.Second}}))
+	require.NoError(t, err)
+	dest := make([]driver.Value, 2)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Next(dest))
+	}
+	assert.Equal(t, context.DeadlineExceeded, r.Next(dest))
+	require.NoError(t, r.Close())
+}
+
+func TestRowsGzipDL_DownloadRetries(t *testing.T) {
+	objects, expected := genCTASObjects(t, "q", 4)
+	newRows := func(retries, failures int) (*rowsGzipDL, *mockS3Client, error) {
+		s3Client := &mockS3Client{
+			objects:  objects,
+			failures: map[string]int{"bucket/tables/q/00002.gz": failures},
+		}
+		r, err := newRowsGzipDL(rowsConfig{
+			Athena:              &mockAthenaConnClient{tableColumns: []*athena.Column{genTableColumn("a", "string"), genTableColumn("b", "string")}},
+			QueryID:             "q",
+			ResultMode:          ResultModeGzipDL,
+			S3:                  s3Client,
+			ManifestLocation:    "s3://bucket/tables/q-manifest.csv",
+			Timeout:             10 * time.Second,
+			DownloadConcurrency: 1,
+			DownloadRetries:     retries,
+		})
+		return r, s3Client, err
+	}
+
+	// only the failed object is downloaded again.
+	r, s3Client, err := newRows(2, 2)
+	require.NoError(t, err)
+	got, err := readAllShards(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, got)
+	assert.Equal(t, 1+4+2, s3Client.requests)
+	require.NoError(t, r.Close())
+
+	// the object fails after the retries, following the objects before it.
+	r, _, err = newRows(1, 2)
+	require.NoError(t, err)
+	got, err = readAllShards(r)
+	assert.Error(t, err)
+	assert.Equal(t, expected[:6], got)
+	require.NoError(t, r.Close())
+}
+
+func BenchmarkGzipShardStream(b *testing.B) {
+	objects := make(map[string][]byte)
+	var s3Objects []s3Object
+	for i := 0; i < 32; i++ {
+		var records [][]string
+		for j := 0; j < 1000; j++ {
+			records = append(records, []string{fmt.Sprintf("%d", i), fmt.Sprintf("%d", j), strings.Repeat("x", 100)})
+		}
+		key := fmt.Sprintf("tables/bench/%05d.gz", i)
+		objects["bucket/"+key] = genGzipObject(b, records)
+		s3Objects = append(s3Objects, s3Object{bucket: "bucket", key: key})
+	}
+	downloader := s3manager.NewDownloaderWithClient(&mockS3Client{objects: objects})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		stream := newGzipShardStream(context.Background(), downloader, s3Objects, nil, 4, 4, 0, 0, func(data []byte) (ctasRecords, error) {
+			fields, err := decodeGzipRecords(data, textfileFieldDelimiter)
+			return ctasRecords{fields: fields}, err
+		})
+		for {
+			_, err := stream.nextShard()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(b, err)
+		}
+	}
+}
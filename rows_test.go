@@ -1,16 +1,21 @@
 package athena
 
 import (
+	"context"
 	"database/sql/driver"
 	"errors"
 	"io"
 	"math/rand"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var dummyError = errors.New("dummy error")
@@ -22,6 +27,8 @@ var queryToResultsGenMap = map[string]genQueryResultsOutputByToken{
 	"select_zero":    dummySelectZeroQueryResponse,
 	"show":           dummyShowResponse,
 	"iteration_fail": dummyFailedIterationResponse,
+	"count":          dummyCountResponse,
+	"mixed":          dummyMixedResponse,
 }
 
 func genColumnInfo(column string) *athena.ColumnInfo {
@@ -162,6 +169,53 @@ func dummyShowResponse(_ string) (*athena.GetQueryResultsOutput, error) {
 	}, nil
 }
 
+func dummyCountResponse(_ string) (*athena.GetQueryResultsOutput, error) {
+	column := genColumnInfo("_col0")
+	column.Type = aws.String("bigint")
+	columns := []*athena.ColumnInfo{column}
+	return &athena.GetQueryResultsOutput{
+		ResultSet: &athena.ResultSet{
+			ResultSetMetadata: &athena.ResultSetMetadata{
+				ColumnInfo: columns,
+			},
+			Rows: []*athena.Row{
+				genRow(true, columns),
+				{Data: []*athena.Datum{{VarCharValue: aws.String("42")}}},
+			},
+		},
+	}, nil
+}
+
+func dummyMixedResponse(_ string) (*athena.GetQueryResultsOutput, error) {
+	var columns []*athena.ColumnInfo
+	for _, c := range [][2]string{
+		{"id", "bigint"}, {"score", "double"}, {"active", "boolean"},
+		{"created_at", "timestamp"}, {"name", "varchar"}, {"price", "decimal"},
+	} {
+		column := genColumnInfo(c[0])
+		column.Type = aws.String(c[1])
+		columns = append(columns, column)
+	}
+	columns[0].Nullable = aws.String(athena.ColumnNullableNotNull)
+	columns[4].Nullable = aws.String(athena.ColumnNullableNullable)
+	columns[5].Precision = aws.Int64(10)
+	columns[5].Scale = aws.Int64(2)
+	return &athena.GetQueryResultsOutput{
+		ResultSet: &athena.ResultSet{
+			ResultSetMetadata: &athena.ResultSetMetadata{
+				ColumnInfo: columns,
+			},
+			Rows: []*athena.Row{
+				genRow(true, columns),
+				{Data: []*athena.Datum{
+					{VarCharValue: aws.String("1")}, {VarCharValue: aws.String("0.5")}, {VarCharValue: aws.String("true")},
+					{VarCharValue: aws.String("2020-01-02 03:04:05.678")}, {VarCharValue: aws.String("foo")}, {VarCharValue: aws.String("1.50")},
+				}},
+			},
+		},
+	}, nil
+}
+
 func dummyFailedIterationResponse(token string) (*athena.GetQueryResultsOutput, error) {
 	switch token {
 	case "":
@@ -267,6 +321,78 @@ func TestRows_Next(t *testing.T) {
 	}
 }
 
+// mockPagingAthenaClient counts the pages fetched by GetQueryResults.
+type mockPagingAthenaClient struct {
+	mockAthenaClient
+	pages []string // NextToken of each GetQueryResults call
+}
+
+func (m *mockPagingAthenaClient) GetQueryResults(query *athena.GetQueryResultsInput) (*athena.GetQueryResultsOutput, error) {
+	m.pages = append(m.pages, aws.StringValue(query.NextToken))
+	return m.mockAthenaClient.GetQueryResults(query)
+}
+
+func TestRowsAPI_FetchPagesLazily(t *testing.T) {
+	client := &mockPagingAthenaClient{}
+	r, err := newRows(rowsConfig{Athena: client, QueryID: "select", SkipHeader: true})
+	assert.NoError(t, err)
+	// only the first page is fetched for the columns before the first Next.
+	assert.Equal(t, []string{""}, client.pages)
+
+	var firstName, lastName string
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, r.Next(castToValue(&firstName, &lastName)))
+	}
+	assert.Equal(t, []string{""}, client.pages, "the next page isn't fetched until the rows of the first one are read")
+
+	assert.NoError(t, r.Next(castToValue(&firstName, &lastName)))
+	assert.Equal(t, []string{"", "page_1"}, client.pages)
+}
+
+func TestRowsAPI_MaxPages(t *testing.T) {
+	tests := []struct {
+		maxPages int
+		truncate bool
+		rows     int
+		err      error
+	}{
+		{maxPages: 1, rows: 4, err: ErrMaxPagesExceeded},
+		{maxPages: 1, truncate: true, rows: 4, err: io.EOF},
+		{maxPages: 2, rows: 9, err: io.EOF},
+		{maxPages: 0, rows: 9, err: io.EOF},
+	}
+	for _, test := range tests {
+		r, err := newRows(rowsConfig{
+			Athena:             new(mockAthenaClient),
+			QueryID:            "select",
+			SkipHeader:         true,
+			MaxPages:           test.maxPages,
+			TruncateAtMaxPages: test.truncate,
+		})
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		var firstName, lastName string
+		cnt := 0
+		for {
+			if err = r.Next(castToValue(&firstName, &lastName)); err != nil {
+				break
+			}
+			cnt++
+		}
+		assert.Equal(t, test.err, err, "max pages: %d, truncate: %v", test.maxPages, test.truncate)
+		assert.Equal(t, test.rows, cnt, "max pages: %d, truncate: %v", test.maxPages, test.truncate)
+	}
+}
+
+func Test_getRecordsForDLWideRow(t *testing.T) {
+	wide := strings.Repeat("x", 200*1024)
+	records, err := getRecordsForDL(strings.NewReader("\"a\",\"" + wide + "\"\n\"b\",\"c\"\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]downloadField{{{val: "a"}, {val: wide}}, {{val: "b"}, {val: "c"}}}, records)
+}
+
 func Test_getRecordsForDL(t *testing.T) {
 
 	tests := []struct {
@@ -326,3 +452,137 @@ func Test_getRecordsForDL(t *testing.T) {
 		})
 	}
 }
+
+func Test_rowsConfig_downloadTimeout(t *testing.T) {
+	assert.Equal(t, 30*time.Minute, rowsConfig{}.downloadTimeout())
+	assert.Equal(t, time.Minute, rowsConfig{Timeout: time.Minute}.downloadTimeout())
+	assert.Equal(t, time.Hour, rowsConfig{Timeout: time.Minute, DownloadTimeout: time.Hour}.downloadTimeout())
+}
+
+func TestRowsDL_DownloadTimeout(t *testing.T) {
+	s3Client := &mockS3Client{
+		objects: map[string][]byte{"bucket/show.csv": []byte("a\n")},
+		delay:   time.Minute,
+	}
+	_, err := newRowsDL(rowsConfig{
+		Athena:          &mockAthenaConnClient{queryID: "show"},
+		S3:              s3Client,
+		QueryID:         "show",
+		OutputLocation:  "s3://bucket",
+		DownloadTimeout: 20 * time.Millisecond,
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the download is stopped, not left running.
+	assert.Eventually(t, func() bool {
+		s3Client.mu.Lock()
+		defer s3Client.mu.Unlock()
+		return s3Client.inFlight == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestRows_ColumnsBeforeNext(t *testing.T) {
+	for _, queryID := range []string{"select", "select_zero"} {
+		r, err := newRows(rowsConfig{
+			Athena:     new(mockAthenaClient),
+			QueryID:    queryID,
+			SkipHeader: true,
+		})
+		assert.NoError(t, err)
+
+		assert.Equal(t, []string{"first_name", "last_name"}, r.Columns(), queryID)
+		tn := r.(driver.RowsColumnTypeDatabaseTypeName)
+		assert.Equal(t, "varchar", tn.ColumnTypeDatabaseTypeName(0), queryID)
+	}
+}
+
+func TestRows_ColumnTypes(t *testing.T) {
+	db := openMockDB(t, &conn{athena: &mockAthenaConnClient{queryID: "mixed"}})
+	rows, err := db.Query("SELECT * FROM foo")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	require.NoError(t, err)
+	var scanTypes []reflect.Type
+	for _, ct := range types {
+		scanTypes = append(scanTypes, ct.ScanType())
+	}
+	assert.Equal(t, []reflect.Type{
+		reflect.TypeOf(int64(0)), reflect.TypeOf(float64(0)), reflect.TypeOf(false),
+		reflect.TypeOf(time.Time{}), reflect.TypeOf(""), reflect.TypeOf(float64(0)),
+	}, scanTypes)
+
+	precision, scale, ok := types[5].DecimalSize()
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), precision)
+	assert.Equal(t, int64(2), scale)
+	_, _, ok = types[4].DecimalSize()
+	assert.False(t, ok)
+
+	nullable, ok := types[0].Nullable()
+	assert.True(t, ok)
+	assert.False(t, nullable)
+	nullable, ok = types[4].Nullable()
+	assert.True(t, ok)
+	assert.True(t, nullable)
+	_, ok = types[1].Nullable()
+	assert.False(t, ok, "UNKNOWN is not reported")
+
+	// the values can be scanned into the scan types.
+	dest := make([]interface{}, len(types))
+	for i, st := range scanTypes {
+		dest[i] = reflect.New(st).Interface()
+	}
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(dest...))
+	assert.Equal(t, int64(1), *dest[0].(*int64))
+	assert.Equal(t, "foo", *dest[4].(*string))
+	assert.Equal(t, 1.5, *dest[5].(*float64))
+}
+
+func Test_parseDecimalType(t *testing.T) {
+	for _, tt := range []struct {
+		athenaType string
+		precision  int64
+		scale      int64
+		ok         bool
+	}{
+		{"decimal(38,10)", 38, 10, true},
+		{"decimal(10, 2)", 10, 2, true},
+		{"decimal(5)", 5, 0, true},
+		{"decimal", 0, 0, false},
+		{"double", 0, 0, false},
+		{"decimal(a,b)", 0, 0, false},
+	} {
+		precision, scale, ok := parseDecimalType(tt.athenaType)
+		assert.Equal(t, tt.ok, ok, tt.athenaType)
+		assert.Equal(t, tt.precision, precision, tt.athenaType)
+		assert.Equal(t, tt.scale, scale, tt.athenaType)
+	}
+}
+
+func Test_columnInfoPrecisionScale(t *testing.T) {
+	precision, scale, ok := columnInfoPrecisionScale(&athena.ColumnInfo{Type: aws.String("decimal"), Precision: aws.Int64(38), Scale: aws.Int64(10)})
+	assert.True(t, ok)
+	assert.Equal(t, int64(38), precision)
+	assert.Equal(t, int64(10), scale)
+
+	// the precision of the other types is their length.
+	_, _, ok = columnInfoPrecisionScale(&athena.ColumnInfo{Type: aws.String("varchar"), Precision: aws.Int64(2147483647)})
+	assert.False(t, ok)
+}
+
+func Test_columnNames(t *testing.T) {
+	columns := []*athena.ColumnInfo{
+		{Name: aws.String("id"), TableName: aws.String("users")},
+		{Name: aws.String("name")},
+		{Name: aws.String("id"), TableName: aws.String("orders")},
+		{Name: aws.String("id")},
+		{Name: aws.String("id_2")},
+	}
+
+	assert.Equal(t, []string{"id", "name", "id", "id", "id_2"}, columnNames(columns, DuplicateColumnModeKeep))
+	assert.Equal(t, []string{"id", "name", "id_2", "id_3", "id_2_2"}, columnNames(columns, DuplicateColumnModeSuffix))
+	assert.Equal(t, []string{"users.id", "name", "orders.id", "id", "id_2"}, columnNames(columns, DuplicateColumnModeQualify))
+}
@@ -0,0 +1,93 @@
+package athena
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// ResultObject is the metadata of the S3 object of a query result.
+type ResultObject struct {
+	// Location is the S3 URI of the object. It's the manifest of the CTAS table in GZIP DL mode.
+	Location      string
+	LastModified  time.Time
+	ContentLength int64
+	ETag          string
+}
+
+// parseS3URI splits an S3 URI "s3://bucket/key" into the bucket and the key.
+func parseS3URI(uri string) (string, string, error) {
+	const scheme = "s3://"
+	if !strings.HasPrefix(uri, scheme) {
+		return "", "", fmt.Errorf("invalid S3 URI: %s", uri)
+	}
+
+	path := uri[len(scheme):]
+	i := strings.Index(path, "/")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid S3 URI: %s", uri)
+	}
+	return path[:i], path[i+1:], nil
+}
+
+// headResultObject returns the metadata of the result object at location.
+func headResultObject(ctx context.Context, s3Client s3iface.S3API, location string) (*ResultObject, error) {
+	bucket, key, err := parseS3URI(location)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResultObject{
+		Location:      location,
+		LastModified:  aws.TimeValue(out.LastModified),
+		ContentLength: aws.Int64Value(out.ContentLength),
+		ETag:          aws.StringValue(out.ETag),
+	}, nil
+}
+
+// sumObjectSizes returns the total size of the objects under prefix.
+func sumObjectSizes(ctx context.Context, s3Client s3iface.S3API, bucket, prefix string) (int64, error) {
+	var size int64
+	err := s3Client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			size += aws.Int64Value(obj.Size)
+		}
+		return true
+	})
+	return size, err
+}
+
+// readManifest returns the S3 locations of the objects listed in the manifest at location.
+func readManifest(ctx context.Context, s3Client s3iface.S3API, location string) ([]string, error) {
+	bucket, key, err := parseS3URI(location)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	return getObjectKeysForGzip(out.Body)
+}
@@ -0,0 +1,43 @@
+package athena
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseS3URI(t *testing.T) {
+	bucket, key, err := parseS3URI("s3://bucket/prefix/q/tables/q-manifest.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket", bucket)
+	assert.Equal(t, "prefix/q/tables/q-manifest.csv", key)
+
+	for _, uri := range []string{"bucket/key", "s3://bucket", "s3:///key"} {
+		_, _, err := parseS3URI(uri)
+		assert.Error(t, err, uri)
+	}
+}
+
+func Test_resultLocations(t *testing.T) {
+	legacy := rowsConfig{QueryID: "q", OutputLocation: "s3://bucket/prefix/"}
+	assert.Equal(t, "s3://bucket/prefix/q.csv", csvLocation(legacy))
+	assert.Equal(t, "s3://bucket/prefix/tables/q-manifest.csv", gzipManifestLocation(legacy))
+
+	// workgroups may write results under subfolders of OutputLocation
+	reported := rowsConfig{
+		QueryID:        "q",
+		OutputLocation: "s3://bucket/prefix",
+		ResultLocation: "s3://bucket/prefix/2024/01/15/q.csv",
+	}
+	assert.Equal(t, "s3://bucket/prefix/2024/01/15/q.csv", csvLocation(reported))
+
+	ctas := rowsConfig{
+		QueryID:        "q",
+		OutputLocation: "s3://bucket/prefix",
+		ResultLocation: "s3://bucket/prefix/q/tables/q",
+	}
+	assert.Equal(t, "s3://bucket/prefix/q/tables/q-manifest.csv", gzipManifestLocation(ctas))
+
+	ctas.ManifestLocation = "s3://bucket/manifests/q-manifest.csv"
+	assert.Equal(t, "s3://bucket/manifests/q-manifest.csv", gzipManifestLocation(ctas))
+}
@@ -0,0 +1,97 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// QueryRows runs a query and scans every row into a T.
+//
+// When T is a struct, each column is scanned into the exported field tagged with
+// `athena:"<column>"`, or the field whose name matches the column case-insensitively.
+// Columns without a field are ignored, and fields tagged with `athena:"-"` are skipped.
+// Use pointer fields or sql.Null* types for nullable columns.
+//
+// Otherwise, e.g. for int64 or string, the query must return a single column.
+func QueryRows[T any](ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	var fields [][]int
+	if isScanStruct(typ) {
+		fields = structFieldsForColumns(typ, columns)
+	} else if len(columns) != 1 {
+		return nil, fmt.Errorf("cannot scan %d columns into %s", len(columns), typ)
+	}
+
+	ret := make([]T, 0)
+	dest := make([]interface{}, len(columns))
+	for rows.Next() {
+		var v T
+		if fields == nil {
+			dest[0] = &v
+		} else {
+			rv := reflect.ValueOf(&v).Elem()
+			for i, index := range fields {
+				if index == nil {
+					dest[i] = new(interface{})
+					continue
+				}
+				dest[i] = rv.FieldByIndex(index).Addr().Interface()
+			}
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, rows.Err()
+}
+
+// isScanStruct reports whether the columns are scanned into the fields of typ.
+// Structs which are scanned as a value, e.g. time.Time and sql.NullString, are excluded.
+func isScanStruct(typ reflect.Type) bool {
+	if typ.Kind() != reflect.Struct || typ == reflect.TypeOf(time.Time{}) {
+		return false
+	}
+	return !reflect.PtrTo(typ).Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem())
+}
+
+// structFieldsForColumns returns the index of the field of typ for each column, or nil if there's none.
+func structFieldsForColumns(typ reflect.Type, columns []string) [][]int {
+	byName := make(map[string][]int)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("athena"); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		byName[strings.ToLower(name)] = f.Index
+	}
+
+	fields := make([][]int, len(columns))
+	for i, column := range columns {
+		fields[i] = byName[strings.ToLower(column)]
+	}
+	return fields
+}
@@ -0,0 +1,60 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryRows_Struct(t *testing.T) {
+	db := openMockDB(t, &conn{athena: &mockAthenaConnClient{queryID: "select"}})
+
+	type name struct {
+		First   string  `athena:"first_name"`
+		Last    *string `athena:"last_name"`
+		Ignored string  `athena:"-"`
+	}
+	names, err := QueryRows[name](context.Background(), db, "SELECT first_name, last_name FROM foo")
+	require.NoError(t, err)
+	require.Len(t, names, 9)
+	for _, n := range names {
+		assert.NotEmpty(t, n.First)
+		require.NotNil(t, n.Last)
+		assert.NotEmpty(t, *n.Last)
+		assert.Empty(t, n.Ignored)
+	}
+}
+
+func TestQueryRows_Scalar(t *testing.T) {
+	db := openMockDB(t, &conn{athena: &mockAthenaConnClient{queryID: "count"}})
+
+	counts, err := QueryRows[int64](context.Background(), db, "SELECT COUNT(*) FROM foo")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{42}, counts)
+
+	nulls, err := QueryRows[sql.NullInt64](context.Background(), db, "SELECT COUNT(*) FROM foo")
+	require.NoError(t, err)
+	assert.Equal(t, []sql.NullInt64{{Int64: 42, Valid: true}}, nulls)
+}
+
+func TestQueryRows_ScalarMultipleColumns(t *testing.T) {
+	db := openMockDB(t, &conn{athena: &mockAthenaConnClient{queryID: "select"}})
+
+	_, err := QueryRows[string](context.Background(), db, "SELECT first_name, last_name FROM foo")
+	assert.Error(t, err)
+}
+
+func Test_structFieldsForColumns(t *testing.T) {
+	type row struct {
+		ID        int64
+		UserName  string `athena:"user_name"`
+		unused    string
+		CreatedAt string
+	}
+	fields := structFieldsForColumns(reflect.TypeOf(row{}), []string{"id", "user_name", "unused", "createdat"})
+	assert.Equal(t, [][]int{{0}, {1}, nil, {3}}, fields)
+}
@@ -0,0 +1,216 @@
+package athena
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"time"
+)
+
+// ShadowConfig runs every SELECT query also in another workgroup, e.g. of a new engine version,
+// and reports whether the results match, to validate queries before migrating them.
+// Shadow queries run asynchronously, so they don't slow down the queries, but they do scan data.
+type ShadowConfig struct {
+	// WorkGroup is the workgroup the shadow queries are run in.
+	WorkGroup string
+	// OutputLocation is the output location of the shadow queries.
+	// The output location of the connection is used if it's empty.
+	OutputLocation string
+	// Report receives the comparison of a query with its shadow after all rows of the query are read.
+	// Queries whose rows are closed before all rows are read aren't compared.
+	// It's called from a goroutine of the driver.
+	Report func(ctx context.Context, report ShadowReport)
+}
+
+// ShadowReport is the comparison of the result of a query with the one of its shadow query.
+// Checksums don't depend on the order of the rows.
+type ShadowReport struct {
+	Query         string
+	QueryID       string
+	ShadowQueryID string
+
+	RowCount       int64
+	ShadowRowCount int64
+	Checksum       uint64
+	ShadowChecksum uint64
+
+	// Err is the error of the shadow query, e.g. a syntax error of the new engine version.
+	Err error
+}
+
+// Matched is whether the shadow query succeeded with the same result.
+func (r ShadowReport) Matched() bool {
+	return r.Err == nil && r.RowCount == r.ShadowRowCount && r.Checksum == r.ShadowChecksum
+}
+
+// shadowResult is the row count and checksum of a result.
+type shadowResult struct {
+	queryID  string
+	rowCount int64
+	checksum uint64
+	err      error
+}
+
+// shadowRows starts the shadow query of a query in the background, and returns rows
+// reporting the comparison once all of the rows of the query are read.
+func (c *conn) shadowRows(ctx context.Context, query, queryID string, timeout time.Duration, rows driver.Rows) driver.Rows {
+	sc := *c
+	sc.workgroup = c.shadow.WorkGroup
+	if c.shadow.OutputLocation != "" {
+		sc.OutputLocation = c.shadow.OutputLocation
+	}
+	sc.catalog, sc.db = c.getQueryContext(ctx)
+	sc.timeout = timeout
+	// the query is already rewritten and limited.
+	sc.queryRewriter = nil
+	sc.autoLimit = 0
+	sc.cache = nil
+	sc.shadow = nil
+	sc.workGroupConfig = nil
+
+	// the shadow query outlives the context of the query, which can end once its rows are read.
+	shadowCtx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		shadowCtx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
+	shadowCtx = withExecutionParameters(shadowCtx, getExecutionParameters(ctx))
+	result := make(chan shadowResult, 1)
+	go func() {
+		defer cancel()
+		result <- sc.runShadowQuery(shadowCtx, query)
+	}()
+
+	return &rowsShadow{
+		Rows:    rows,
+		ctx:     ctx,
+		cancel:  cancel,
+		report:  c.shadow.Report,
+		query:   query,
+		queryID: queryID,
+		result:  result,
+	}
+}
+
+// runShadowQuery runs a shadow query, and reads all of its rows.
+func (c *conn) runShadowQuery(ctx context.Context, query string) shadowResult {
+	var stats QueryStats
+	rows, err := c.runQuery(SetQueryStatsReceiver(ctx, &stats), query)
+	if err != nil {
+		return shadowResult{queryID: stats.QueryID, err: err}
+	}
+	defer rows.Close()
+
+	res := shadowResult{queryID: stats.QueryID}
+	dest := make([]driver.Value, len(rows.Columns()))
+	for {
+		err := rows.Next(dest)
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			res.err = err
+			return res
+		}
+		res.rowCount++
+		res.checksum += shadowRowHash(dest)
+	}
+}
+
+// shadowRowHash is the hash of a row. The checksum of a result is the sum of the hashes of its rows,
+// which doesn't depend on their order.
+func shadowRowHash(row []driver.Value) uint64 {
+	h := fnv.New64a()
+	for _, v := range row {
+		fmt.Fprintf(h, "%T:%v\x00", v, v)
+	}
+	return h.Sum64()
+}
+
+// rowsShadow is driver.Rows computing the checksum of the wrapped rows to compare it with the shadow query.
+type rowsShadow struct {
+	driver.Rows
+	ctx     context.Context
+	cancel  context.CancelFunc
+	report  func(ctx context.Context, report ShadowReport)
+	query   string
+	queryID string
+	result  chan shadowResult
+
+	rowCount int64
+	checksum uint64
+	done     bool
+}
+
+func (r *rowsShadow) ColumnTypeDatabaseTypeName(index int) string {
+	if tn, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return tn.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *rowsShadow) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if ps, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return ps.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+func (r *rowsShadow) ColumnTypeScanType(index int) reflect.Type {
+	if st, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return st.ColumnTypeScanType(index)
+	}
+	return scanTypeInterface
+}
+
+func (r *rowsShadow) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if n, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return n.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *rowsShadow) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == io.EOF && !r.done {
+		r.done = true
+		go r.compare()
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	r.rowCount++
+	r.checksum += shadowRowHash(dest)
+	return nil
+}
+
+// compare waits for the shadow query and reports the comparison.
+func (r *rowsShadow) compare() {
+	res := <-r.result
+	if r.report == nil {
+		return
+	}
+	r.report(r.ctx, ShadowReport{
+		Query:          r.query,
+		QueryID:        r.queryID,
+		ShadowQueryID:  res.queryID,
+		RowCount:       r.rowCount,
+		ShadowRowCount: res.rowCount,
+		Checksum:       r.checksum,
+		ShadowChecksum: res.checksum,
+		Err:            res.err,
+	})
+}
+
+func (r *rowsShadow) Close() error {
+	if !r.done {
+		// the result can't be compared, so the shadow query is stopped.
+		r.done = true
+		r.cancel()
+	}
+	return r.Rows.Close()
+}
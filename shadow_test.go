@@ -0,0 +1,90 @@
+package athena
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockShadowAthenaClient starts the queries of the shadow workgroup as shadowQueryID.
+type mockShadowAthenaClient struct {
+	*mockAthenaConnClient
+	shadowQueryID string
+}
+
+func (m *mockShadowAthenaClient) StartQueryExecution(input *athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	if aws.StringValue(input.WorkGroup) == "v3" {
+		return &athena.StartQueryExecutionOutput{QueryExecutionId: aws.String(m.shadowQueryID)}, nil
+	}
+	return m.mockAthenaConnClient.StartQueryExecution(input)
+}
+
+func runShadowTest(t *testing.T, queryID, shadowQueryID string, readAll bool) (ShadowReport, bool) {
+	reports := make(chan ShadowReport, 1)
+	c := &conn{
+		athena: &mockShadowAthenaClient{
+			mockAthenaConnClient: &mockAthenaConnClient{queryID: queryID},
+			shadowQueryID:        shadowQueryID,
+		},
+		workgroup: "primary",
+		shadow: &ShadowConfig{
+			WorkGroup: "v3",
+			Report: func(_ context.Context, report ShadowReport) {
+				reports <- report
+			},
+		},
+	}
+
+	rows, err := c.runQuery(context.Background(), "SELECT COUNT(*) FROM foo")
+	require.NoError(t, err)
+	if readAll {
+		readAllRows(t, rows)
+	}
+	require.NoError(t, rows.Close())
+
+	select {
+	case report := <-reports:
+		return report, true
+	case <-time.After(100 * time.Millisecond):
+		return ShadowReport{}, false
+	}
+}
+
+func TestConn_Shadow(t *testing.T) {
+	report, ok := runShadowTest(t, "count", "count", true)
+	require.True(t, ok)
+	assert.True(t, report.Matched())
+	assert.Equal(t, "SELECT COUNT(*) FROM foo", report.Query)
+	assert.Equal(t, "count", report.QueryID)
+	assert.Equal(t, "count", report.ShadowQueryID)
+	assert.Equal(t, int64(1), report.RowCount)
+	assert.Equal(t, report.Checksum, report.ShadowChecksum)
+
+	// the rows of "select" are random strings.
+	report, ok = runShadowTest(t, "select", "select", true)
+	require.True(t, ok)
+	assert.False(t, report.Matched())
+	assert.Equal(t, int64(9), report.RowCount)
+	assert.Equal(t, int64(9), report.ShadowRowCount)
+	assert.NotEqual(t, report.Checksum, report.ShadowChecksum)
+
+	report, ok = runShadowTest(t, "count", "select_zero", true)
+	require.True(t, ok)
+	assert.False(t, report.Matched())
+	assert.Equal(t, int64(0), report.ShadowRowCount)
+
+	// queries whose rows aren't read to the end aren't compared.
+	_, ok = runShadowTest(t, "count", "count", false)
+	assert.False(t, ok)
+}
+
+func Test_shadowRowHash(t *testing.T) {
+	assert.Equal(t, shadowRowHash(castToValue(int64(1), "a")), shadowRowHash(castToValue(int64(1), "a")))
+	assert.NotEqual(t, shadowRowHash(castToValue(int64(1), "a")), shadowRowHash(castToValue("1", "a")))
+	assert.NotEqual(t, shadowRowHash(castToValue("a", "b")), shadowRowHash(castToValue("ab", "")))
+}
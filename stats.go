@@ -0,0 +1,55 @@
+package athena
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// QueryStats is the statistics of a query execution reported by Athena.
+type QueryStats struct {
+	QueryID string
+
+	DataScannedInBytes            int64
+	EngineExecutionTimeInMillis   int64
+	QueryPlanningTimeInMillis     int64
+	QueryQueueTimeInMillis        int64
+	ServiceProcessingTimeInMillis int64
+	TotalExecutionTimeInMillis    int64
+
+	// DataManifestLocation is the S3 location of the manifest file listing the files
+	// written by the query. It's set only for CTAS, INSERT INTO and UNLOAD queries.
+	DataManifestLocation string
+
+	// PollCount is the number of GetQueryExecution calls made while waiting for the query,
+	// and WaitTime is the wall-clock time spent waiting. They're measured by the driver
+	// and help to tune poll_frequency.
+	PollCount int
+	WaitTime  time.Duration
+
+	// SubmittedAt and CompletedAt are when Athena received the query and when it completed. The difference
+	// is the wall-clock time of the query in Athena, which the time in the queue and the engine is a part of.
+	SubmittedAt time.Time
+	CompletedAt time.Time
+}
+
+func (s *QueryStats) setQueryExecution(qe *athena.QueryExecution) {
+	s.QueryID = aws.StringValue(qe.QueryExecutionId)
+	if qe.Status != nil {
+		s.SubmittedAt = aws.TimeValue(qe.Status.SubmissionDateTime)
+		s.CompletedAt = aws.TimeValue(qe.Status.CompletionDateTime)
+	}
+
+	st := qe.Statistics
+	if st == nil {
+		return
+	}
+	s.DataScannedInBytes = aws.Int64Value(st.DataScannedInBytes)
+	s.EngineExecutionTimeInMillis = aws.Int64Value(st.EngineExecutionTimeInMillis)
+	s.QueryPlanningTimeInMillis = aws.Int64Value(st.QueryPlanningTimeInMillis)
+	s.QueryQueueTimeInMillis = aws.Int64Value(st.QueryQueueTimeInMillis)
+	s.ServiceProcessingTimeInMillis = aws.Int64Value(st.ServiceProcessingTimeInMillis)
+	s.TotalExecutionTimeInMillis = aws.Int64Value(st.TotalExecutionTimeInMillis)
+	s.DataManifestLocation = aws.StringValue(st.DataManifestLocation)
+}
@@ -0,0 +1,125 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// TailQueryOutput calls fn with the records of the objects written by a running query,
+// as the objects appear under location, until the query finishes. It's experimental.
+//
+// It's intended for long CTAS and UNLOAD queries, which write their objects while they run,
+// to give early feedback. location is the S3 prefix the objects are written under,
+// e.g. the TO location of UNLOAD. If it's empty, the location of the CTAS table created
+// without external_location, "<output location>/tables/<query id>/", is used.
+//
+// Limitations:
+//   - Only gzip compressed TEXTFILE objects (whose keys end with ".gz") are read, which is the
+//     format of GZIP DL mode. Fields are split by the field delimiter of the CTAS table of the connection,
+//     `ctas_field_delimiter`, which defaults to '\001', and aren't converted to Go values.
+//   - Objects are read in the order of their keys, not the order of the result.
+//   - Athena may write objects of retried tasks which aren't in the final result, so that
+//     records can be duplicated. The manifest of the finished query is authoritative.
+//
+// It returns nil when the query succeeds after all of its objects are read,
+// or the error of the query when it fails.
+func TailQueryOutput(ctx context.Context, db *sql.DB, queryID, location string, fn func(record []string) error) error {
+	return withConn(ctx, db, func(c *conn) error {
+		return c.tailQueryOutput(ctx, queryID, location, fn)
+	})
+}
+
+func (c *conn) tailQueryOutput(ctx context.Context, queryID, location string, fn func(record []string) error) error {
+	downloader := s3manager.NewDownloaderWithClient(c.s3)
+	read := make(map[string]bool)
+	delim := c.ctasFieldDelimiter
+	if delim == "" {
+		delim = textfileFieldDelimiter
+	}
+
+	for attempt := 1; ; attempt++ {
+		out, err := c.athena.GetQueryExecutionWithContext(ctx, &athena.GetQueryExecutionInput{
+			QueryExecutionId: aws.String(queryID),
+		})
+		if err != nil {
+			return err
+		}
+		qe := out.QueryExecution
+		state := aws.StringValue(qe.Status.State)
+
+		prefix := location
+		if prefix == "" {
+			prefix = resultLocation(qe) + "/"
+		}
+		bucket, keyPrefix, err := parseS3URI(prefix)
+		if err != nil {
+			return err
+		}
+
+		// objects listed after the query finished are all of its objects.
+		keys, err := listGzipObjectKeys(ctx, c.s3, bucket, keyPrefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if read[key] {
+				continue
+			}
+			records, err := downloadGzipObject(ctx, downloader, bucket, key, nil, delim)
+			if err != nil {
+				return err
+			}
+			for _, record := range records {
+				if err := fn(record); err != nil {
+					return err
+				}
+			}
+			read[key] = true
+		}
+
+		switch state {
+		case athena.QueryExecutionStateSucceeded:
+			return nil
+		case athena.QueryExecutionStateFailed:
+			return errors.New(aws.StringValue(qe.Status.StateChangeReason))
+		case athena.QueryExecutionStateCancelled:
+			return context.Canceled
+		}
+
+		timer := time.NewTimer(c.pollDelay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// listGzipObjectKeys returns the keys of the gzip objects under prefix in lexical order.
+func listGzipObjectKeys(ctx context.Context, s3Client s3iface.S3API, bucket, prefix string) ([]string, error) {
+	var keys []string
+	err := s3Client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			if key := aws.StringValue(obj.Key); strings.HasSuffix(key, ".gz") {
+				keys = append(keys, key)
+			}
+		}
+		return true
+	})
+	sort.Strings(keys)
+	return keys, err
+}
@@ -0,0 +1,54 @@
+package athena
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailQueryOutput(t *testing.T) {
+	client := &mockAthenaConnClient{location: "s3://bucket/tables/q", pending: 1}
+	s3Client := &mockS3Client{objects: map[string][]byte{
+		"bucket/tables/q-manifest.csv": []byte("s3://bucket/tables/q/00000.gz\ns3://bucket/tables/q/00001.gz\n"),
+		"bucket/tables/q/00000.gz":     genGzipObject(t, [][]string{{"a", "1"}, {"b", "2"}}),
+		"bucket/tables/q/00001.gz":     genGzipObject(t, [][]string{{"c", "3"}}),
+	}}
+	db := openMockDB(t, &conn{athena: client, s3: s3Client, pollFrequency: time.Millisecond})
+
+	var got [][]string
+	err := TailQueryOutput(context.Background(), db, "q", "", func(record []string) error {
+		got = append(got, record)
+		return nil
+	})
+	require.NoError(t, err)
+	// the objects are read once even though they're listed on every poll.
+	assert.Equal(t, [][]string{{"a", "1"}, {"b", "2"}, {"c", "3"}}, got)
+}
+
+func TestTailQueryOutput_FieldDelimiter(t *testing.T) {
+	client := &mockAthenaConnClient{location: "s3://bucket/tables/q"}
+	s3Client := &mockS3Client{objects: map[string][]byte{
+		"bucket/tables/q/00000.gz": genGzipObject(t, [][]string{{"a|1"}, {"b\0012|"}}),
+	}}
+	db := openMockDB(t, &conn{athena: client, s3: s3Client, pollFrequency: time.Millisecond, ctasFieldDelimiter: "|"})
+
+	var got [][]string
+	err := TailQueryOutput(context.Background(), db, "q", "", func(record []string) error {
+		got = append(got, record)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"a", "1"}, {"b\0012", ""}}, got)
+}
+
+func TestTailQueryOutputFailed(t *testing.T) {
+	client := &mockAthenaConnClient{state: athena.QueryExecutionStateFailed, reason: "syntax error"}
+	db := openMockDB(t, &conn{athena: client, s3: &mockS3Client{}})
+
+	err := TailQueryOutput(context.Background(), db, "q", "s3://bucket/unload/", func([]string) error { return nil })
+	assert.EqualError(t, err, "syntax error")
+}
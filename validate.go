@@ -0,0 +1,48 @@
+package athena
+
+import (
+	"crypto/sha256"
+	"sync"
+)
+
+// maxValidQueries is the maximum number of the queries remembered by validQueries,
+// which forgets all of them once it's reached, so that memory stays bounded.
+const maxValidQueries = 10000
+
+// validQueries is the set of the hashes of the queries found valid by ValidateQuery,
+// shared by the connections of a connector. A nil set remembers nothing.
+type validQueries struct {
+	mu     sync.Mutex
+	hashes map[[sha256.Size]byte]struct{}
+}
+
+func newValidQueries() *validQueries {
+	return &validQueries{hashes: make(map[[sha256.Size]byte]struct{})}
+}
+
+// validQueryHash is the hash of a query, which is valid only in its catalog and database.
+func validQueryHash(catalog, database, query string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(catalog + "\x00" + database + "\x00" + query))
+}
+
+func (v *validQueries) contains(hash [sha256.Size]byte) bool {
+	if v == nil {
+		return false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	_, ok := v.hashes[hash]
+	return ok
+}
+
+func (v *validQueries) add(hash [sha256.Size]byte) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.hashes) >= maxValidQueries {
+		v.hashes = make(map[[sha256.Size]byte]struct{})
+	}
+	v.hashes[hash] = struct{}{}
+}
@@ -2,11 +2,18 @@ package athena
 
 import (
 	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/athena"
+	"golang.org/x/text/encoding"
 )
 
 const (
@@ -14,13 +21,275 @@ const (
 	TimestampLayout             = "2006-01-02 15:04:05.999"
 	TimestampWithTimeZoneLayout = "2006-01-02 15:04:05.999 MST"
 	DateLayout                  = "2006-01-02"
+	// TimeLayout is the Go time layout string for an Athena `time`. Values are on January 1, year 0.
+	TimeLayout = "15:04:05.999"
 )
 
 const nullStringResultModeGzipDL string = "\\N"
 
-func convertRow(columns []*athena.ColumnInfo, in []*athena.Datum, ret []driver.Value) error {
+// UnconvertibleValueMode is the behavior for a value which cannot be converted
+// to the Go type of its column.
+type UnconvertibleValueMode int
+
+const (
+	// UnconvertibleValueModeError returns an error from Next (default).
+	UnconvertibleValueModeError UnconvertibleValueMode = 0
+
+	// UnconvertibleValueModeRawString returns the value as the raw string Athena returned.
+	UnconvertibleValueModeRawString UnconvertibleValueMode = 1
+
+	// UnconvertibleValueModeNil returns nil.
+	UnconvertibleValueModeNil UnconvertibleValueMode = 2
+)
+
+// ValueConverter converts a raw value of a query result of an Athena type before the driver does,
+// e.g. for custom types or domain-specific encodings. The type is as reported by Athena, which may have
+// parameters in GZIP DL mode, e.g. `decimal(10,2)`. The value is converted by the driver unless handled is true.
+// It's called in every result mode, but not for NULL. Errors are handled by UnconvertibleValueMode.
+type ValueConverter func(columnType string, raw string) (v driver.Value, handled bool, err error)
+
+// converter converts the raw values of query results to Go values.
+type converter struct {
+	unconvertibleValueMode UnconvertibleValueMode
+
+	// tinyintAsBool converts all `tinyint` columns to bool, and tinyintAsBoolColumns
+	// converts only the columns in it, keyed by lower case names.
+	tinyintAsBool        bool
+	tinyintAsBoolColumns map[string]bool
+
+	// resultEncoding transcodes string values from UTF-8 unless it's nil.
+	resultEncoding encoding.Encoding
+
+	// trimFields trims the surrounding whitespace of string values.
+	trimFields bool
+
+	// decimalAsString returns decimal values as the raw strings, which are exact.
+	decimalAsString bool
+
+	// parseComplexTypes parses the arrays, maps and rows rendered by Athena in API and DL mode,
+	// which are returned as the raw strings otherwise.
+	parseComplexTypes bool
+
+	// truncatedValueLength is the length of the values of API mode treated as truncated if it's positive.
+	truncatedValueLength int
+	// onTruncatedValue is called with the column and the length of every value treated as truncated unless it's nil.
+	onTruncatedValue func(column string, length int)
+
+	// nullString is NULL of the fields of CTAS TEXTFILE instead of `\N`,
+	// and of the CSV of DL mode in addition to empty unquoted fields, unless it's empty.
+	nullString string
+
+	// valueConverter converts values before the driver unless it's nil.
+	valueConverter ValueConverter
+}
+
+func newConverter(cfg *Config) converter {
+	c := converter{
+		unconvertibleValueMode: cfg.UnconvertibleValueMode,
+		tinyintAsBool:          cfg.TinyintAsBool,
+		resultEncoding:         cfg.ResultEncoding,
+		trimFields:             cfg.TrimFields,
+		decimalAsString:        cfg.DecimalAsString,
+		parseComplexTypes:      cfg.ParseComplexTypes,
+		truncatedValueLength:   cfg.TruncatedValueLength,
+		nullString:             cfg.NullString,
+		valueConverter:         cfg.ValueConverter,
+	}
+	if len(cfg.TinyintAsBoolColumns) > 0 {
+		c.tinyintAsBoolColumns = make(map[string]bool)
+		for _, column := range cfg.TinyintAsBoolColumns {
+			c.tinyintAsBoolColumns[strings.ToLower(column)] = true
+		}
+	}
+	return c
+}
+
+// textfileNull returns NULL of the fields of CTAS TEXTFILE.
+func (c converter) textfileNull() string {
+	if c.nullString == "" {
+		return nullStringResultModeGzipDL
+	}
+	return c.nullString
+}
+
+// convertColumn converts a value of the column.
+func (c converter) convertColumn(column, athenaType string, rawValue *string) (interface{}, error) {
+	if c.valueConverter != nil && rawValue != nil {
+		val, handled, err := c.valueConverter(athenaType, *rawValue)
+		if handled || err != nil {
+			return c.handleError(val, err, rawValue)
+		}
+	}
+	if athenaType == "tinyint" && (c.tinyintAsBool || c.tinyintAsBoolColumns[strings.ToLower(column)]) {
+		val, err := convertTinyintToBool(rawValue)
+		return c.handleError(val, err, rawValue)
+	}
+	if c.decimalAsString && strings.HasPrefix(athenaType, "decimal") {
+		return convertDecimalToString(rawValue), nil
+	}
+	var val interface{}
+	var err error
+	if !c.parseComplexTypes && isRenderedComplexType(athenaType) {
+		// kept as rendered by Athena, e.g. "[1, 2]", since its items can't be told apart reliably.
+		if rawValue != nil && *rawValue != "" {
+			val = *rawValue
+		}
+	} else {
+		val, err = c.convertValue(athenaType, rawValue)
+	}
+	if str, ok := val.(string); ok && err == nil && c.trimFields && isStringType(athenaType) {
+		val = strings.TrimSpace(str)
+	}
+	if str, ok := val.(string); ok && err == nil && c.resultEncoding != nil {
+		// the encoder isn't safe for concurrent use, so it's created for each value.
+		val, err = c.resultEncoding.NewEncoder().String(str)
+		return c.handleError(val, err, rawValue)
+	}
+	return val, err
+}
+
+// convertParquetValue converts a value of the column decoded from PARQUET, which is already in its type.
+// The value converter and the unconvertible values get the value in the text of CTAS TEXTFILE.
+func (c converter) convertParquetValue(column, athenaType string, v driver.Value) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw := formatParquetValue(athenaType, v)
+	if c.valueConverter != nil {
+		return c.convertColumn(column, athenaType, &raw)
+	}
+	if athenaType == "tinyint" && (c.tinyintAsBool || c.tinyintAsBoolColumns[strings.ToLower(column)]) {
+		val, err := convertTinyintToBool(&raw)
+		return c.handleError(val, err, &raw)
+	}
+
+	switch val := v.(type) {
+	case parquetDecimal:
+		if c.decimalAsString {
+			return string(val), nil
+		}
+		f, err := strconv.ParseFloat(string(val), 64)
+		return c.handleError(f, err, &raw)
+	case string:
+		if c.trimFields && isStringType(athenaType) {
+			val = strings.TrimSpace(val)
+		}
+		if c.resultEncoding != nil {
+			encoded, err := c.resultEncoding.NewEncoder().String(val)
+			return c.handleError(encoded, err, &raw)
+		}
+		return val, nil
+	}
+	return v, nil
+}
+
+func (c converter) convertValue(athenaType string, rawValue *string) (interface{}, error) {
+	if rawValue != nil && isTextfileComplexType(athenaType) {
+		// the items of CTAS TEXTFILE are NULL by the same string as the fields.
+		val, err := parseTextfileComplex(athenaType, *rawValue, textfileCollectionDelimiter, c.textfileNull())
+		return c.handleError(val, err, rawValue)
+	}
+	val, err := convertValue(athenaType, rawValue)
+	return c.handleError(val, err, rawValue)
+}
+
+var (
+	scanTypeInt64     = reflect.TypeOf(int64(0))
+	scanTypeFloat64   = reflect.TypeOf(float64(0))
+	scanTypeBool      = reflect.TypeOf(false)
+	scanTypeString    = reflect.TypeOf("")
+	scanTypeBytes     = reflect.TypeOf([]byte(nil))
+	scanTypeTime      = reflect.TypeOf(time.Time{})
+	scanTypeDuration  = reflect.TypeOf(time.Duration(0))
+	scanTypeSlice     = reflect.TypeOf([]interface{}(nil))
+	scanTypeMap       = reflect.TypeOf(map[string]interface{}(nil))
+	scanTypeInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// scanType returns the Go type the values of the column are converted to, except NULL.
+// It's interface{} for unknown types, and when the values may be of another type,
+// i.e. with the value converter or the raw strings of unconvertible values.
+func (c converter) scanType(column, athenaType string) reflect.Type {
+	if c.valueConverter != nil || c.unconvertibleValueMode == UnconvertibleValueModeRawString {
+		return scanTypeInterface
+	}
+	if athenaType == "tinyint" && (c.tinyintAsBool || c.tinyintAsBoolColumns[strings.ToLower(column)]) {
+		return scanTypeBool
+	}
+	if strings.HasPrefix(athenaType, "decimal") {
+		if c.decimalAsString {
+			return scanTypeString
+		}
+		return scanTypeFloat64
+	}
+
+	if isStringType(athenaType) || !c.parseComplexTypes && isRenderedComplexType(athenaType) {
+		return scanTypeString
+	}
+	switch {
+	case strings.HasPrefix(athenaType, "array<"):
+		return scanTypeSlice
+	case strings.HasPrefix(athenaType, "map<"), strings.HasPrefix(athenaType, "struct<"):
+		return scanTypeMap
+	}
+
+	switch athenaType {
+	case "tinyint", "smallint", "integer", "int", "bigint":
+		return scanTypeInt64
+	case "float", "double":
+		return scanTypeFloat64
+	case "boolean":
+		return scanTypeBool
+	case "varchar", "string", "geometry", "time with time zone", "interval year to month":
+		return scanTypeString
+	case "varbinary", "binary":
+		return scanTypeBytes
+	case "timestamp", "timestamp with time zone", "date", "time":
+		return scanTypeTime
+	case "interval day to second":
+		return scanTypeDuration
+	case "array":
+		return scanTypeSlice
+	case "map", "row":
+		return scanTypeMap
+	default:
+		return scanTypeInterface
+	}
+}
+
+// handleError handles an error of conversion by unconvertibleValueMode.
+func (c converter) handleError(val interface{}, err error, rawValue *string) (interface{}, error) {
+	if err == nil {
+		return val, nil
+	}
+
+	switch c.unconvertibleValueMode {
+	case UnconvertibleValueModeRawString:
+		return *rawValue, nil
+	case UnconvertibleValueModeNil:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+func (c converter) convertRow(columns []*athena.ColumnInfo, in []*athena.Datum, ret []driver.Value) error {
 	for i, val := range in {
-		coerced, err := convertValue(*columns[i].Type, val.VarCharValue)
+		name := aws.StringValue(columns[i].Name)
+		if c.truncatedValueLength > 0 && val.VarCharValue != nil && len(*val.VarCharValue) >= c.truncatedValueLength {
+			if c.onTruncatedValue != nil {
+				c.onTruncatedValue(name, len(*val.VarCharValue))
+			}
+			err := fmt.Errorf("%w: column %s has %d bytes", ErrTruncatedValue, name, len(*val.VarCharValue))
+			coerced, err := c.handleError(nil, err, val.VarCharValue)
+			if err != nil {
+				return err
+			}
+			ret[i] = coerced
+			continue
+		}
+
+		coerced, err := c.convertColumn(name, *columns[i].Type, val.VarCharValue)
 		if err != nil {
 			return err
 		}
@@ -31,16 +300,51 @@ func convertRow(columns []*athena.ColumnInfo, in []*athena.Datum, ret []driver.V
 	return nil
 }
 
-func convertRowFromTableInfo(columns []*athena.Column, in []string, ret []driver.Value) error {
+// convertRowFromTableInfo converts the columns of in whose projection is true.
+// The other columns are set to nil. All columns are converted if projection is nil.
+func (c converter) convertRowFromTableInfo(columns []*athena.Column, in []string, ret []driver.Value, projection []bool) error {
+	null := c.textfileNull()
+	in, err := alignRecord(in, len(columns), null)
+	if err != nil {
+		return err
+	}
 	for i, val := range in {
+		if projection != nil && !projection[i] {
+			ret[i] = nil
+			continue
+		}
+
 		var coerced interface{}
 		var err error
-		if val == nullStringResultModeGzipDL {
+		if val == null {
 			var nullVal *string
-			coerced, err = convertValue(*columns[i].Type, nullVal)
+			coerced, err = c.convertColumn(aws.StringValue(columns[i].Name), *columns[i].Type, nullVal)
 		} else {
-			coerced, err = convertValue(*columns[i].Type, &val)
+			coerced, err = c.convertColumn(aws.StringValue(columns[i].Name), *columns[i].Type, &val)
+		}
+		if err != nil {
+			return err
+		}
+
+		ret[i] = coerced
+	}
+
+	return nil
+}
+
+// convertRowFromParquet converts the values of a record of PARQUET whose projection is true.
+// The other columns are set to nil. All columns are converted if projection is nil.
+func (c converter) convertRowFromParquet(columns []*athena.Column, in []driver.Value, ret []driver.Value, projection []bool) error {
+	if len(in) != len(columns) {
+		return fmt.Errorf("record has %d values for %d columns", len(in), len(columns))
+	}
+	for i, val := range in {
+		if projection != nil && !projection[i] {
+			ret[i] = nil
+			continue
 		}
+
+		coerced, err := c.convertParquetValue(aws.StringValue(columns[i].Name), *columns[i].Type, val)
 		if err != nil {
 			return err
 		}
@@ -51,15 +355,15 @@ func convertRowFromTableInfo(columns []*athena.Column, in []string, ret []driver
 	return nil
 }
 
-func convertRowFromCsv(columns []*athena.ColumnInfo, in []downloadField, ret []driver.Value) error {
+func (c converter) convertRowFromCsv(columns []*athena.ColumnInfo, in []downloadField, ret []driver.Value) error {
 	for i, df := range in {
 		var coerced interface{}
 		var err error
-		if df.isNil {
+		if df.isNil || (c.nullString != "" && df.val == c.nullString) {
 			var nullVal *string
-			coerced, err = convertValue(*columns[i].Type, nullVal)
+			coerced, err = c.convertColumn(aws.StringValue(columns[i].Name), *columns[i].Type, nullVal)
 		} else {
-			coerced, err = convertValue(*columns[i].Type, &df.val)
+			coerced, err = c.convertColumn(aws.StringValue(columns[i].Name), *columns[i].Type, &df.val)
 		}
 		if err != nil {
 			return err
@@ -79,9 +383,25 @@ func convertValue(athenaType string, rawValue *string) (interface{}, error) {
 	if len(athenaType) > 7 && athenaType[:7] == "decimal" {
 		athenaType = "decimal"
 	}
+	if isStringType(athenaType) {
+		athenaType = "varchar"
+	}
+
+	// complex types of CTAS TEXTFILE, whose types are reported by Glue as e.g. "array<string>".
+	if isTextfileComplexType(athenaType) {
+		return parseTextfileComplex(athenaType, *rawValue, textfileCollectionDelimiter, nullStringResultModeGzipDL)
+	}
 
 	val := *rawValue
+	// NULL can be an empty field instead of nil, e.g. in the API result of some queries
+	// and in the CSV of DL mode, so it's nil for the types which can't be empty.
+	if val == "" && !emptyValueTypes[athenaType] {
+		return nil, nil
+	}
+
 	switch athenaType {
+	case "tinyint":
+		return strconv.ParseInt(val, 10, 8)
 	case "smallint":
 		return strconv.ParseInt(val, 10, 16)
 	case "integer", "int":
@@ -102,13 +422,375 @@ func convertValue(athenaType string, rawValue *string) (interface{}, error) {
 		return strconv.ParseFloat(val, 64)
 	case "varchar", "string":
 		return val, nil
+	case "geometry":
+		// geometry is returned as WKT (well-known text) by Athena.
+		return val, nil
+	case "varbinary":
+		// varbinary is returned as space separated hex, e.g. "68 65 6c 6c 6f".
+		return hex.DecodeString(strings.ReplaceAll(val, " ", ""))
+	case "binary":
+		// binary of CTAS TEXTFILE is base64 encoded.
+		return base64.StdEncoding.DecodeString(val)
 	case "timestamp":
 		return time.Parse(TimestampLayout, val)
 	case "timestamp with time zone":
 		return time.Parse(TimestampWithTimeZoneLayout, val)
 	case "date":
 		return time.Parse(DateLayout, val)
+	case "time":
+		return time.Parse(TimeLayout, val)
+	case "interval day to second":
+		return parseIntervalDayToSecond(val)
+	case "array":
+		// arrays and maps of API and DL mode, whose types have no type of the items, e.g. "[1, 2, 3]".
+		return parseArray(val)
+	case "map", "row":
+		// rows are rendered as maps of their fields, e.g. "{a=1, b=foo}".
+		return parseMap(val)
+	case "time with time zone", "interval year to month":
+		// kept as string, e.g. "01:02:03.456+09:00" and "1-2",
+		// since they have no Go counterpart.
+		return val, nil
 	default:
-		panic(fmt.Errorf("unknown type `%s` with value %s", athenaType, val))
+		return nil, fmt.Errorf("unknown type `%s` with value %s", athenaType, val)
+	}
+}
+
+// alignRecord aligns the fields of a record of CTAS TEXTFILE to n columns.
+// Missing trailing fields are null as Hive reads them, and extra trailing fields are dropped
+// as long as they're empty, which is written by a line ending with the delimiter.
+func alignRecord(record []string, n int, null string) ([]string, error) {
+	if len(record) > n {
+		for _, field := range record[n:] {
+			if field != "" {
+				return nil, fmt.Errorf("record has %d fields for %d columns", len(record), n)
+			}
+		}
+		return record[:n], nil
+	}
+	for len(record) < n {
+		record = append(record, null)
+	}
+	return record, nil
+}
+
+// emptyValueTypes are the types for which an empty string is a valid value.
+var emptyValueTypes = map[string]bool{
+	"varchar":   true,
+	"string":    true,
+	"geometry":  true,
+	"varbinary": true,
+	"binary":    true,
+
+	"time with time zone":    true,
+	"interval year to month": true,
+}
+
+// parseIntervalDayToSecond parses an `interval day to second` as "<days> <hh>:<mm>:<ss>.<fff>",
+// e.g. "-2 03:04:05.678", into time.Duration.
+func parseIntervalDayToSecond(val string) (time.Duration, error) {
+	invalid := fmt.Errorf("cannot parse '%s' as interval day to second", val)
+
+	sign := ""
+	s := val
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	parts := strings.Split(s, " ")
+	if len(parts) != 2 {
+		return 0, invalid
+	}
+	days, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, invalid
+	}
+	hms := strings.Split(parts[1], ":")
+	if len(hms) != 3 {
+		return 0, invalid
+	}
+
+	d, err := time.ParseDuration(fmt.Sprintf("%s%dh%sh%sm%ss", sign, days*24, hms[0], hms[1], hms[2]))
+	if err != nil {
+		return 0, invalid
+	}
+	return d, nil
+}
+
+// convertTinyintToBool converts a `tinyint` encoding a boolean as 0 or 1.
+func convertTinyintToBool(rawValue *string) (interface{}, error) {
+	if rawValue == nil || *rawValue == "" {
+		return nil, nil
+	}
+	switch *rawValue {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return nil, fmt.Errorf("cannot parse '%s' as boolean", *rawValue)
+}
+
+// convertDecimalToString returns a decimal value as it's rendered, keeping the precision and the trailing zeros.
+func convertDecimalToString(rawValue *string) interface{} {
+	if rawValue == nil || *rawValue == "" {
+		return nil
+	}
+	return *rawValue
+}
+
+// textfileCollectionDelimiter is the delimiter of the items of top-level arrays and maps
+// in CTAS TEXTFILE. Every nested level uses the next control character, and map keys are
+// delimited from their values by the next one of their items, e.g. '\002' and '\003'.
+const textfileCollectionDelimiter byte = '\002'
+
+// isStringType returns whether the type is of strings, i.e. `char`, `varchar` and `string`,
+// including the ones with the length, e.g. `char(10)` of the columns of CTAS tables reported by Glue.
+func isStringType(athenaType string) bool {
+	if i := strings.IndexByte(athenaType, '('); i >= 0 {
+		athenaType = athenaType[:i]
+	}
+	switch athenaType {
+	case "char", "varchar", "string":
+		return true
+	}
+	return false
+}
+
+// isTextfileComplexType is whether a type of a column of CTAS TEXTFILE is an array, a map or a struct.
+func isTextfileComplexType(athenaType string) bool {
+	return strings.HasPrefix(athenaType, "array<") || strings.HasPrefix(athenaType, "map<") || strings.HasPrefix(athenaType, "struct<")
+}
+
+// isRenderedComplexType returns whether the type is of arrays, maps or rows of API and DL mode,
+// which Athena reports without the types of their items.
+func isRenderedComplexType(athenaType string) bool {
+	return athenaType == "array" || athenaType == "map" || athenaType == "row"
+}
+
+// parseTextfileComplex parses a value of array, map or struct of CTAS TEXTFILE, whose items are
+// delimited by delim. Arrays are converted to []interface{}, maps to map[string]interface{}
+// keyed by the raw keys, and structs to map[string]interface{} keyed by the names of their fields,
+// which are written in the order of the type without the names. The items are converted by their types,
+// and the ones equal to null are nil.
+func parseTextfileComplex(athenaType, val string, delim byte, null string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(athenaType, "array<") && strings.HasSuffix(athenaType, ">"):
+		elemType := athenaType[len("array<") : len(athenaType)-1]
+		ret := make([]interface{}, 0)
+		if val == "" {
+			return ret, nil
+		}
+		for _, item := range strings.Split(val, string(delim)) {
+			v, err := convertTextfileItem(elemType, item, delim+1, null)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+	case strings.HasPrefix(athenaType, "map<") && strings.HasSuffix(athenaType, ">"):
+		keyType, valueType, ok := splitMapType(athenaType[len("map<") : len(athenaType)-1])
+		if !ok {
+			break
+		}
+		ret := make(map[string]interface{})
+		if val == "" {
+			return ret, nil
+		}
+		for _, entry := range strings.Split(val, string(delim)) {
+			kv := strings.SplitN(entry, string(delim+1), 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("cannot parse '%s' as %s", val, athenaType)
+			}
+			if _, err := convertTextfileItem(keyType, kv[0], delim+2, null); err != nil {
+				return nil, err
+			}
+			v, err := convertTextfileItem(valueType, kv[1], delim+2, null)
+			if err != nil {
+				return nil, err
+			}
+			ret[kv[0]] = v
+		}
+		return ret, nil
+	case strings.HasPrefix(athenaType, "struct<") && strings.HasSuffix(athenaType, ">"):
+		fields, ok := splitStructType(athenaType[len("struct<") : len(athenaType)-1])
+		if !ok {
+			break
+		}
+		items := strings.Split(val, string(delim))
+		if len(items) > len(fields) {
+			return nil, fmt.Errorf("cannot parse '%s' as %s", val, athenaType)
+		}
+		ret := make(map[string]interface{}, len(fields))
+		for i, field := range fields {
+			if i >= len(items) {
+				// missing trailing fields are null as Hive reads them.
+				ret[field.name] = nil
+				continue
+			}
+			v, err := convertTextfileItem(field.athenaType, items[i], delim+1, null)
+			if err != nil {
+				return nil, err
+			}
+			ret[field.name] = v
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("unknown type `%s` with value %s", athenaType, val)
+}
+
+// structField is a field of a struct type, e.g. "a:int" of "struct<a:int,b:string>".
+type structField struct {
+	name       string
+	athenaType string
+}
+
+// splitStructType splits the "<name>:<type>,..." of a struct type, e.g. "a:int,b:struct<c:array<string>>".
+func splitStructType(s string) ([]structField, bool) {
+	var fields []structField
+	for s != "" {
+		// the first top-level comma splits the field from the rest, as the key of a map.
+		field, rest, ok := splitMapType(s)
+		if !ok {
+			field, rest = s, ""
+		}
+		name, athenaType, ok := strings.Cut(field, ":")
+		if !ok || name == "" || athenaType == "" {
+			return nil, false
+		}
+		fields = append(fields, structField{name: strings.TrimSpace(name), athenaType: strings.TrimSpace(athenaType)})
+		s = rest
+	}
+	return fields, len(fields) > 0
+}
+
+// convertTextfileItem converts an item of an array or a map, whose nested items are delimited by delim.
+// The item is nil if it equals null.
+func convertTextfileItem(athenaType, val string, delim byte, null string) (interface{}, error) {
+	if val == null {
+		return nil, nil
+	}
+	if isTextfileComplexType(athenaType) {
+		return parseTextfileComplex(athenaType, val, delim, null)
+	}
+	return convertValue(athenaType, &val)
+}
+
+// parseArray parses an array rendered by Athena in API and DL mode, e.g. "[1, 2, 3]" or "[[a, b], []]".
+// The elements are strings or nested arrays and maps, since the type of the column doesn't tell the type of them.
+// The surrounding whitespace of the elements is trimmed, and "null" is nil. Athena doesn't quote strings,
+// so strings containing ", " or brackets are split, and a string "null" is nil too.
+func parseArray(val string) ([]interface{}, error) {
+	if !strings.HasPrefix(val, "[") || !strings.HasSuffix(val, "]") {
+		return nil, fmt.Errorf("cannot parse '%s' as array", val)
+	}
+	items, err := splitRendered(val)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		elem, err := parseRenderedElement(item)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, elem)
+	}
+	return ret, nil
+}
+
+// parseMap parses a map rendered by Athena in API and DL mode, e.g. "{a=1, b=2}", in the same way as parseArray.
+// Entries are split at the first "=" outside brackets, so keys containing "=" are split wrongly.
+func parseMap(val string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(val, "{") || !strings.HasSuffix(val, "}") {
+		return nil, fmt.Errorf("cannot parse '%s' as map", val)
+	}
+	items, err := splitRendered(val)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		parts, err := splitTopLevel(item, '=')
+		if err != nil || len(parts) < 2 {
+			return nil, fmt.Errorf("cannot parse '%s' as map", val)
+		}
+		// the value is the rest after the first "=", which can contain "=" too.
+		key := strings.TrimSpace(parts[0])
+		v, err := parseRenderedElement(strings.TrimSpace(item[len(parts[0])+1:]))
+		if err != nil {
+			return nil, err
+		}
+		ret[key] = v
+	}
+	return ret, nil
+}
+
+// splitRendered splits the items of an array or a map rendered by Athena, which is enclosed by brackets.
+func splitRendered(val string) ([]string, error) {
+	inner := val[1 : len(val)-1]
+	if strings.TrimSpace(inner) == "" {
+		return nil, nil
+	}
+	items, err := splitTopLevel(inner, ',')
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse '%s': %w", val, err)
+	}
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items, nil
+}
+
+// splitTopLevel splits s by sep outside nested arrays and maps.
+func splitTopLevel(s string, sep byte) ([]string, error) {
+	var ret []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == sep && depth == 0:
+			ret = append(ret, s[start:i])
+			start = i + 1
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced brackets")
+	}
+	return append(ret, s[start:]), nil
+}
+
+// parseRenderedElement parses an element of an array or a value of a map rendered by Athena.
+func parseRenderedElement(elem string) (interface{}, error) {
+	switch {
+	case elem == "null":
+		return nil, nil
+	case strings.HasPrefix(elem, "["):
+		return parseArray(elem)
+	case strings.HasPrefix(elem, "{"):
+		return parseMap(elem)
+	default:
+		return elem, nil
+	}
+}
+
+// splitMapType splits the "<key>,<value>" of a map type, e.g. "string,map<string,int>".
+func splitMapType(s string) (string, string, bool) {
+	depth := 0
+	for i, r := range s {
+		switch r {
+		case '<', '(':
+			depth++
+		case '>', ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				return s[:i], s[i+1:], true
+			}
+		}
 	}
+	return "", "", false
 }
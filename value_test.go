@@ -0,0 +1,508 @@
+package athena
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestConverter_UnconvertibleValueMode(t *testing.T) {
+	tests := []struct {
+		desc       string
+		mode       UnconvertibleValueMode
+		athenaType string
+		raw        string
+		expected   interface{}
+		wantErr    bool
+	}{
+		{
+			desc:       "error mode, invalid integer",
+			mode:       UnconvertibleValueModeError,
+			athenaType: "integer",
+			raw:        "abc",
+			wantErr:    true,
+		},
+		{
+			desc:       "error mode, unknown type",
+			mode:       UnconvertibleValueModeError,
+			athenaType: "unknown_type",
+			raw:        "abc",
+			wantErr:    true,
+		},
+		{
+			desc:       "raw string mode, invalid boolean",
+			mode:       UnconvertibleValueModeRawString,
+			athenaType: "boolean",
+			raw:        "yes",
+			expected:   "yes",
+		},
+		{
+			desc:       "nil mode, unknown type",
+			mode:       UnconvertibleValueModeNil,
+			athenaType: "unknown_type",
+			raw:        "abc",
+			expected:   nil,
+		},
+		{
+			desc:       "raw string mode, valid value",
+			mode:       UnconvertibleValueModeRawString,
+			athenaType: "bigint",
+			raw:        "10",
+			expected:   int64(10),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			c := converter{unconvertibleValueMode: test.mode}
+			got, err := c.convertValue(test.athenaType, aws.String(test.raw))
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+}
+
+func TestConverter_RawStringKeepsFloatText(t *testing.T) {
+	// unconvertible values are returned as the text Athena returned, never reformatted.
+	c := converter{unconvertibleValueMode: UnconvertibleValueModeRawString}
+	for _, raw := range []string{"0.1x", "1.0E-7x", "3.14159265358979323846x"} {
+		got, err := c.convertValue("double", aws.String(raw))
+		assert.NoError(t, err)
+		assert.Equal(t, raw, got)
+	}
+}
+
+func TestConverter_FloatTextRoundTrip(t *testing.T) {
+	// converted floats are formatted back by QueryToCSV and for the raw values of PARQUET results
+	// in the shortest text of their bits, so 0.1 of a float column isn't 0.10000000149011612.
+	c := converter{}
+	for _, athenaType := range []string{"float", "double"} {
+		v, err := c.convertValue(athenaType, aws.String("0.1"))
+		require.NoError(t, err)
+
+		field, err := csvField(athenaType, v)
+		require.NoError(t, err)
+		assert.Equal(t, "0.1", field, athenaType)
+		assert.Equal(t, "0.1", formatParquetValue(athenaType, v), athenaType)
+	}
+}
+
+func Test_convertValue_Binary(t *testing.T) {
+	tests := []struct {
+		athenaType string
+		raw        string
+		expected   interface{}
+	}{
+		{"varbinary", "68 65 6c 6c 6f", []byte("hello")},
+		{"varbinary", "", []byte{}},
+		{"binary", "aGVsbG8=", []byte("hello")},
+		{"geometry", "POINT (1 2)", "POINT (1 2)"},
+	}
+	for _, test := range tests {
+		got, err := convertValue(test.athenaType, aws.String(test.raw))
+		assert.NoError(t, err, test.athenaType)
+		assert.Equal(t, test.expected, got, test.athenaType)
+	}
+}
+
+func Test_convertValue_Temporal(t *testing.T) {
+	tests := []struct {
+		athenaType string
+		raw        string
+		expected   interface{}
+	}{
+		{"interval day to second", "2 03:04:05.678", 51*time.Hour + 4*time.Minute + 5678*time.Millisecond},
+		{"interval day to second", "-0 00:00:01.500", -1500 * time.Millisecond},
+		{"interval year to month", "1-2", "1-2"},
+		{"time", "12:34:56.789", time.Date(0, 1, 1, 12, 34, 56, 789000000, time.UTC)},
+		{"time with time zone", "12:34:56.789+09:00", "12:34:56.789+09:00"},
+	}
+	for _, test := range tests {
+		got, err := convertValue(test.athenaType, aws.String(test.raw))
+		assert.NoError(t, err, test.raw)
+		assert.Equal(t, test.expected, got, test.raw)
+	}
+
+	_, err := convertValue("interval day to second", aws.String("03:04:05"))
+	assert.Error(t, err)
+}
+
+func TestConverter_TinyintAsBool(t *testing.T) {
+	columns := []*athena.ColumnInfo{
+		{Name: aws.String("is_active"), Type: aws.String("tinyint")},
+		{Name: aws.String("level"), Type: aws.String("tinyint")},
+	}
+	in := []*athena.Datum{{VarCharValue: aws.String("1")}, {VarCharValue: aws.String("0")}}
+
+	ret := make([]driver.Value, 2)
+	require.NoError(t, newConverter(&Config{}).convertRow(columns, in, ret))
+	assert.Equal(t, []driver.Value{int64(1), int64(0)}, ret)
+
+	require.NoError(t, newConverter(&Config{TinyintAsBoolColumns: []string{"IS_ACTIVE"}}).convertRow(columns, in, ret))
+	assert.Equal(t, []driver.Value{true, int64(0)}, ret)
+
+	require.NoError(t, newConverter(&Config{TinyintAsBool: true}).convertRow(columns, in, ret))
+	assert.Equal(t, []driver.Value{true, false}, ret)
+
+	in[1] = &athena.Datum{VarCharValue: aws.String("2")}
+	assert.Error(t, newConverter(&Config{TinyintAsBool: true}).convertRow(columns, in, ret))
+	require.NoError(t, newConverter(&Config{UnconvertibleValueMode: UnconvertibleValueModeNil, TinyintAsBool: true}).convertRow(columns, in, ret))
+	assert.Equal(t, []driver.Value{true, nil}, ret)
+}
+
+func Test_convertValue_EmptyField(t *testing.T) {
+	for _, athenaType := range []string{"tinyint", "smallint", "integer", "bigint", "float", "double", "decimal(10,2)", "boolean", "date", "timestamp"} {
+		t.Run(athenaType, func(t *testing.T) {
+			got, err := convertValue(athenaType, aws.String(""))
+			require.NoError(t, err)
+			assert.Nil(t, got)
+		})
+	}
+
+	got, err := convertValue("varchar", aws.String(""))
+	require.NoError(t, err)
+	assert.Equal(t, "", got)
+}
+
+func TestConverter_EmptyNumericField(t *testing.T) {
+	c := converter{}
+	ret := make([]driver.Value, 2)
+
+	csvColumns := []*athena.ColumnInfo{
+		{Name: aws.String("id"), Type: aws.String("bigint")},
+		{Name: aws.String("name"), Type: aws.String("varchar")},
+	}
+	require.NoError(t, c.convertRowFromCsv(csvColumns, []downloadField{{val: ""}, {val: "foo"}}, ret))
+	assert.Equal(t, []driver.Value{nil, "foo"}, ret)
+
+	tableColumns := []*athena.Column{
+		{Name: aws.String("id"), Type: aws.String("bigint")},
+		{Name: aws.String("name"), Type: aws.String("varchar")},
+	}
+	require.NoError(t, c.convertRowFromTableInfo(tableColumns, []string{"", "foo"}, ret, nil))
+	assert.Equal(t, []driver.Value{nil, "foo"}, ret)
+}
+
+func TestConverter_ResultEncoding(t *testing.T) {
+	c := newConverter(&Config{ResultEncoding: japanese.ShiftJIS})
+	got, err := c.convertColumn("name", "varchar", aws.String("日本"))
+	require.NoError(t, err)
+	assert.Equal(t, "\x93\xfa\x96\x7b", got)
+
+	// non string values aren't transcoded.
+	got, err = c.convertColumn("id", "bigint", aws.String("1"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), got)
+
+	_, err = c.convertColumn("name", "varchar", aws.String("🍣"))
+	assert.Error(t, err)
+}
+
+func Test_convertValue_Array(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected interface{}
+	}{
+		{"[1, 2, 3]", []interface{}{"1", "2", "3"}},
+		{"[]", []interface{}{}},
+		{"[ ]", []interface{}{}},
+		{"[a,  b , null]", []interface{}{"a", "b", nil}},
+		// strings aren't quoted, so a string "a, b" can't be told from two strings.
+		{"[a, b, c]", []interface{}{"a", "b", "c"}},
+		{`["a", b]`, []interface{}{`"a"`, "b"}},
+		{"[[1, 2], [], [3]]", []interface{}{[]interface{}{"1", "2"}, []interface{}{}, []interface{}{"3"}}},
+	}
+	for _, test := range tests {
+		got, err := convertValue("array", aws.String(test.raw))
+		require.NoError(t, err, test.raw)
+		assert.Equal(t, test.expected, got, test.raw)
+	}
+
+	for _, raw := range []string{"1, 2", "[[1, 2]", "[a]]"} {
+		_, err := convertValue("array", aws.String(raw))
+		assert.Error(t, err, raw)
+	}
+}
+
+func Test_convertValue_Map(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected interface{}
+	}{
+		{"{a=1, b=2}", map[string]interface{}{"a": "1", "b": "2"}},
+		{"{}", map[string]interface{}{}},
+		{"{a=null, b=x=y}", map[string]interface{}{"a": nil, "b": "x=y"}},
+		{"{e=[1, 2], f={g=h}}", map[string]interface{}{"e": []interface{}{"1", "2"}, "f": map[string]interface{}{"g": "h"}}},
+	}
+	for _, test := range tests {
+		got, err := convertValue("map", aws.String(test.raw))
+		require.NoError(t, err, test.raw)
+		assert.Equal(t, test.expected, got, test.raw)
+	}
+
+	// a value "c, d" is split, since strings aren't quoted.
+	for _, raw := range []string{"a=1", "{a}", "{a={b=1}", "{a=c, d}"} {
+		_, err := convertValue("map", aws.String(raw))
+		assert.Error(t, err, raw)
+	}
+}
+
+func Test_convertValue_Row(t *testing.T) {
+	got, err := convertValue("row", aws.String("{a=1, b={c=x, d=[1, 2]}}"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "1", "b": map[string]interface{}{"c": "x", "d": []interface{}{"1", "2"}}}, got)
+}
+
+func Test_splitStructType(t *testing.T) {
+	fields, ok := splitStructType("a:int, b:struct<c:map<string,int>,d:decimal(10,2)>")
+	require.True(t, ok)
+	assert.Equal(t, []structField{{"a", "int"}, {"b", "struct<c:map<string,int>,d:decimal(10,2)>"}}, fields)
+
+	_, ok = splitStructType("a,b:int")
+	assert.False(t, ok)
+}
+
+func Test_convertValue_TextfileComplex(t *testing.T) {
+	tests := []struct {
+		athenaType string
+		raw        string
+		expected   interface{}
+	}{
+		{"array<string>", "a\002b\002\\N", []interface{}{"a", "b", nil}},
+		{"array<int>", "1\0022", []interface{}{int64(1), int64(2)}},
+		{"array<bigint>", "1\002\\N", []interface{}{int64(1), nil}},
+		{"array<varchar>", " a \002", []interface{}{" a ", ""}},
+		{"array<string>", "", []interface{}{}},
+		{"array<array<int>>", "1\0032\0023", []interface{}{[]interface{}{int64(1), int64(2)}, []interface{}{int64(3)}}},
+		{"map<string,bigint>", "a\0031\002b\0032", map[string]interface{}{"a": int64(1), "b": int64(2)}},
+		{"map<string,decimal(10,2)>", "a\0031.5", map[string]interface{}{"a": 1.5}},
+		{"map<string,array<string>>", "a\003x\004y", map[string]interface{}{"a": []interface{}{"x", "y"}}},
+		{"struct<a:int,b:string>", "1\002foo", map[string]interface{}{"a": int64(1), "b": "foo"}},
+		{"struct<a:int,b:string>", "\\N", map[string]interface{}{"a": nil, "b": nil}},
+		{"struct<a:int,b:string>", "1", map[string]interface{}{"a": int64(1), "b": nil}},
+		{"struct<a:int,b:struct<c:string,d:array<bigint>>>", "1\002x\0031\0042", map[string]interface{}{
+			"a": int64(1), "b": map[string]interface{}{"c": "x", "d": []interface{}{int64(1), int64(2)}},
+		}},
+		{"array<struct<a:int>>", "1\0022", []interface{}{map[string]interface{}{"a": int64(1)}, map[string]interface{}{"a": int64(2)}}},
+	}
+	for _, test := range tests {
+		t.Run(test.athenaType, func(t *testing.T) {
+			got, err := convertValue(test.athenaType, aws.String(test.raw))
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+
+	_, err := convertValue("map<string,int>", aws.String("a"))
+	assert.Error(t, err)
+}
+
+func TestConverter_TrimFields(t *testing.T) {
+	c := newConverter(&Config{TrimFields: true})
+	got, err := c.convertColumn("name", "varchar", aws.String("  foo \t"))
+	require.NoError(t, err)
+	assert.Equal(t, "foo", got)
+
+	got, err = c.convertColumn("name", "string", aws.String(" bar "))
+	require.NoError(t, err)
+	assert.Equal(t, "bar", got)
+
+	// char is right-padded with spaces to its length.
+	for _, athenaType := range []string{"char", "char(6)", "varchar(10)"} {
+		got, err = c.convertColumn("code", athenaType, aws.String("abc   "))
+		require.NoError(t, err, athenaType)
+		assert.Equal(t, "abc", got, athenaType)
+	}
+	got, err = newConverter(&Config{}).convertColumn("code", "char(6)", aws.String("abc   "))
+	require.NoError(t, err)
+	assert.Equal(t, "abc   ", got)
+
+	got, err = newConverter(&Config{}).convertColumn("name", "varchar", aws.String(" foo "))
+	require.NoError(t, err)
+	assert.Equal(t, " foo ", got)
+}
+
+func TestConverter_DecimalAsString(t *testing.T) {
+	c := newConverter(&Config{DecimalAsString: true})
+	for _, tt := range []struct {
+		athenaType string
+		raw        string
+	}{
+		{"decimal", "1234567890123456789012345678.0123456789"},
+		{"decimal(38,10)", "-0.0000000001"},
+		{"decimal(10,2)", "1.50"},
+		{"decimal(10,2)", "-12.00"},
+	} {
+		got, err := c.convertColumn("price", tt.athenaType, aws.String(tt.raw))
+		require.NoError(t, err)
+		assert.Equal(t, tt.raw, got, tt.athenaType)
+	}
+
+	got, err := c.convertColumn("price", "decimal(10,2)", nil)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = c.convertColumn("price", "decimal", aws.String(""))
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	got, err = newConverter(&Config{}).convertColumn("price", "decimal(10,2)", aws.String("1.50"))
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, got)
+}
+
+func TestConverter_ParseComplexTypes(t *testing.T) {
+	columns := []*athena.ColumnInfo{
+		{Name: aws.String("tags"), Type: aws.String("array")},
+		{Name: aws.String("attrs"), Type: aws.String("map")},
+		{Name: aws.String("empty"), Type: aws.String("array")},
+	}
+	in := []*athena.Datum{{VarCharValue: aws.String("[a, b]")}, {VarCharValue: aws.String("{k=v}")}, {}}
+	ret := make([]driver.Value, 3)
+
+	// they're the strings rendered by Athena by default.
+	require.NoError(t, newConverter(&Config{}).convertRow(columns, in, ret))
+	assert.Equal(t, []driver.Value{"[a, b]", "{k=v}", nil}, ret)
+
+	require.NoError(t, newConverter(&Config{ParseComplexTypes: true}).convertRow(columns, in, ret))
+	assert.Equal(t, []driver.Value{[]interface{}{"a", "b"}, map[string]interface{}{"k": "v"}, nil}, ret)
+}
+
+func TestConverter_TruncatedValueLength(t *testing.T) {
+	columns := []*athena.ColumnInfo{
+		{Name: aws.String("id"), Type: aws.String("bigint")},
+		{Name: aws.String("body"), Type: aws.String("varchar")},
+	}
+	in := []*athena.Datum{{VarCharValue: aws.String("1")}, {VarCharValue: aws.String("abcde")}}
+	ret := make([]driver.Value, 2)
+
+	err := newConverter(&Config{TruncatedValueLength: 5}).convertRow(columns, in, ret)
+	assert.True(t, errors.Is(err, ErrTruncatedValue))
+
+	require.NoError(t, newConverter(&Config{TruncatedValueLength: 6}).convertRow(columns, in, ret))
+	assert.Equal(t, []driver.Value{int64(1), "abcde"}, ret)
+
+	require.NoError(t, newConverter(&Config{TruncatedValueLength: 5, UnconvertibleValueMode: UnconvertibleValueModeNil}).convertRow(columns, in, ret))
+	assert.Equal(t, []driver.Value{int64(1), nil}, ret)
+
+	require.NoError(t, newConverter(&Config{}).convertRow(columns, in, ret))
+	assert.Equal(t, []driver.Value{int64(1), "abcde"}, ret)
+}
+
+func TestConverter_scanType(t *testing.T) {
+	c := newConverter(&Config{})
+	for athenaType, want := range map[string]interface{}{
+		"tinyint":                  int64(0),
+		"integer":                  int64(0),
+		"float":                    float64(0),
+		"decimal(10,2)":            float64(0),
+		"boolean":                  false,
+		"varchar":                  "",
+		"char(10)":                 "",
+		"interval year to month":   "",
+		"varbinary":                []byte(nil),
+		"date":                     time.Time{},
+		"timestamp with time zone": time.Time{},
+		"interval day to second":   time.Duration(0),
+		"array":                    "",
+		"array<bigint>":            []interface{}(nil),
+		"row":                      "",
+		"struct<a:int>":            map[string]interface{}(nil),
+	} {
+		assert.Equal(t, reflect.TypeOf(want), c.scanType("col", athenaType), athenaType)
+	}
+	assert.Equal(t, scanTypeInterface, c.scanType("col", "unknown"))
+
+	assert.Equal(t, scanTypeBool, newConverter(&Config{TinyintAsBoolColumns: []string{"Flag"}}).scanType("flag", "tinyint"))
+	assert.Equal(t, scanTypeString, newConverter(&Config{DecimalAsString: true}).scanType("col", "decimal(38,10)"))
+	assert.Equal(t, scanTypeSlice, newConverter(&Config{ParseComplexTypes: true}).scanType("col", "array"))
+	assert.Equal(t, scanTypeMap, newConverter(&Config{ParseComplexTypes: true}).scanType("col", "map"))
+	assert.Equal(t, scanTypeInterface, newConverter(&Config{UnconvertibleValueMode: UnconvertibleValueModeRawString}).scanType("col", "bigint"))
+}
+
+func TestConverter_NullString(t *testing.T) {
+	tableColumns := []*athena.Column{
+		{Name: aws.String("id"), Type: aws.String("bigint")},
+		{Name: aws.String("name"), Type: aws.String("varchar")},
+		{Name: aws.String("note"), Type: aws.String("varchar")},
+	}
+	csvColumns := []*athena.ColumnInfo{
+		{Name: aws.String("name"), Type: aws.String("varchar")},
+		{Name: aws.String("note"), Type: aws.String("varchar")},
+	}
+	ret := make([]driver.Value, 3)
+
+	// `\N` by default, and only empty unquoted fields are NULL in DL mode.
+	c := newConverter(&Config{})
+	require.NoError(t, c.convertRowFromTableInfo(tableColumns, []string{"\\N", "NULL", "\\N"}, ret, nil))
+	assert.Equal(t, []driver.Value{nil, "NULL", nil}, ret)
+	require.NoError(t, c.convertRowFromCsv(csvColumns, []downloadField{{val: "NULL"}, {isNil: true}}, ret))
+	assert.Equal(t, []driver.Value{"NULL", nil}, ret[:2])
+
+	c = newConverter(&Config{NullString: "NULL"})
+	require.NoError(t, c.convertRowFromTableInfo(tableColumns, []string{"NULL", "\\N"}, ret, nil))
+	assert.Equal(t, []driver.Value{nil, "\\N", nil}, ret)
+	require.NoError(t, c.convertRowFromCsv(csvColumns, []downloadField{{val: "NULL"}, {isNil: true}}, ret))
+	assert.Equal(t, []driver.Value{nil, nil}, ret[:2])
+
+	// the items of arrays and maps are NULL by it too.
+	complexColumns := []*athena.Column{
+		{Name: aws.String("tags"), Type: aws.String("array<string>")},
+		{Name: aws.String("attrs"), Type: aws.String("map<string,array<bigint>>")},
+	}
+	require.NoError(t, c.convertRowFromTableInfo(complexColumns, []string{"a\002NULL\002\\N", "k\0031\004NULL"}, ret[:2], nil))
+	assert.Equal(t, []driver.Value{
+		[]interface{}{"a", nil, "\\N"},
+		map[string]interface{}{"k": []interface{}{int64(1), nil}},
+	}, ret[:2])
+}
+
+func TestConverter_ValueConverter(t *testing.T) {
+	c := newConverter(&Config{ValueConverter: func(columnType, raw string) (driver.Value, bool, error) {
+		switch columnType {
+		case "uuid":
+			return strings.ToUpper(raw), true, nil
+		case "varchar":
+			if strings.HasPrefix(raw, "!") {
+				return nil, false, errors.New("invalid value")
+			}
+		}
+		return nil, false, nil
+	}})
+	columns := []*athena.ColumnInfo{
+		{Name: aws.String("id"), Type: aws.String("uuid")},
+		{Name: aws.String("n"), Type: aws.String("bigint")},
+		{Name: aws.String("name"), Type: aws.String("varchar")},
+	}
+	tableColumns := []*athena.Column{
+		{Name: aws.String("id"), Type: aws.String("uuid")},
+		{Name: aws.String("n"), Type: aws.String("bigint")},
+		{Name: aws.String("name"), Type: aws.String("varchar")},
+	}
+	ret := make([]driver.Value, 3)
+
+	// the values which aren't handled are converted by the driver in every mode.
+	require.NoError(t, c.convertRow(columns, []*athena.Datum{{VarCharValue: aws.String("ab-c")}, {VarCharValue: aws.String("1")}, {}}, ret))
+	assert.Equal(t, []driver.Value{"AB-C", int64(1), nil}, ret)
+	require.NoError(t, c.convertRowFromCsv(columns, []downloadField{{val: "ab-c"}, {val: "1"}, {val: "x"}}, ret))
+	assert.Equal(t, []driver.Value{"AB-C", int64(1), "x"}, ret)
+	require.NoError(t, c.convertRowFromTableInfo(tableColumns, []string{"ab-c", "1", "\\N"}, ret, nil))
+	assert.Equal(t, []driver.Value{"AB-C", int64(1), nil}, ret)
+
+	// errors are handled by UnconvertibleValueMode.
+	assert.Error(t, c.convertRowFromCsv(columns, []downloadField{{val: "ab-c"}, {val: "1"}, {val: "!x"}}, ret))
+	c.unconvertibleValueMode = UnconvertibleValueModeRawString
+	require.NoError(t, c.convertRowFromCsv(columns, []downloadField{{val: "ab-c"}, {val: "1"}, {val: "!x"}}, ret))
+	assert.Equal(t, []driver.Value{"AB-C", int64(1), "!x"}, ret)
+}
@@ -0,0 +1,79 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// WorkGroupConfig is the effective configuration of the workgroup queries are run in.
+type WorkGroupConfig struct {
+	Name  string
+	State string
+
+	// Enforced is whether the configuration of the workgroup overrides the one of each query,
+	// e.g. the output location.
+	Enforced       bool
+	OutputLocation string
+	// EncryptionOption is the encryption of the results, e.g. SSE_S3 or SSE_KMS. It's empty if they aren't encrypted.
+	EncryptionOption string
+	KMSKey           string
+	// BytesScannedCutoffPerQuery is the limit of the data scanned by each query. It's zero if it's unlimited.
+	BytesScannedCutoffPerQuery int64
+	EngineVersion              string
+}
+
+// GetWorkGroupConfig returns the configuration of the workgroup of db.
+// It's fetched once per connection, so that changes of the workgroup are not reflected
+// until the connection is reopened.
+func GetWorkGroupConfig(ctx context.Context, db *sql.DB) (*WorkGroupConfig, error) {
+	var ret *WorkGroupConfig
+	err := withConn(ctx, db, func(c *conn) error {
+		var err error
+		ret, err = c.getWorkGroupConfig(ctx)
+		return err
+	})
+	return ret, err
+}
+
+func (c *conn) getWorkGroupConfig(ctx context.Context) (*WorkGroupConfig, error) {
+	if c.workGroupConfig != nil {
+		return c.workGroupConfig, nil
+	}
+
+	output, err := c.athena.GetWorkGroupWithContext(ctx, &athena.GetWorkGroupInput{
+		WorkGroup: aws.String(c.workgroup),
+	})
+	if err != nil {
+		return nil, err
+	}
+	wg := output.WorkGroup
+	if wg == nil {
+		return nil, errors.New("workgroup not found")
+	}
+
+	cfg := &WorkGroupConfig{
+		Name:  aws.StringValue(wg.Name),
+		State: aws.StringValue(wg.State),
+	}
+	if wc := wg.Configuration; wc != nil {
+		cfg.Enforced = aws.BoolValue(wc.EnforceWorkGroupConfiguration)
+		cfg.BytesScannedCutoffPerQuery = aws.Int64Value(wc.BytesScannedCutoffPerQuery)
+		if rc := wc.ResultConfiguration; rc != nil {
+			cfg.OutputLocation = aws.StringValue(rc.OutputLocation)
+			if ec := rc.EncryptionConfiguration; ec != nil {
+				cfg.EncryptionOption = aws.StringValue(ec.EncryptionOption)
+				cfg.KMSKey = aws.StringValue(ec.KmsKey)
+			}
+		}
+		if ev := wc.EngineVersion; ev != nil {
+			cfg.EngineVersion = aws.StringValue(ev.EffectiveEngineVersion)
+		}
+	}
+
+	c.workGroupConfig = cfg
+	return cfg, nil
+}
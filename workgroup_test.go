@@ -0,0 +1,60 @@
+package athena
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingWorkGroupClient struct {
+	mockAthenaConnClient
+	calls int
+}
+
+func (m *countingWorkGroupClient) GetWorkGroupWithContext(ctx aws.Context, input *athena.GetWorkGroupInput, opts ...request.Option) (*athena.GetWorkGroupOutput, error) {
+	m.calls++
+	return m.mockAthenaConnClient.GetWorkGroupWithContext(ctx, input, opts...)
+}
+
+func TestGetWorkGroupConfig(t *testing.T) {
+	client := &countingWorkGroupClient{mockAthenaConnClient: mockAthenaConnClient{
+		workGroup: &athena.WorkGroup{
+			Name:  aws.String("analytics"),
+			State: aws.String(athena.WorkGroupStateEnabled),
+			Configuration: &athena.WorkGroupConfiguration{
+				EnforceWorkGroupConfiguration: aws.Bool(true),
+				BytesScannedCutoffPerQuery:    aws.Int64(1 << 30),
+				ResultConfiguration: &athena.ResultConfiguration{
+					OutputLocation: aws.String("s3://results/"),
+					EncryptionConfiguration: &athena.EncryptionConfiguration{
+						EncryptionOption: aws.String(athena.EncryptionOptionSseKms),
+						KmsKey:           aws.String("key"),
+					},
+				},
+				EngineVersion: &athena.EngineVersion{EffectiveEngineVersion: aws.String("Athena engine version 3")},
+			},
+		},
+	}}
+	db := openMockDB(t, &conn{athena: client, workgroup: "analytics"})
+
+	for i := 0; i < 2; i++ {
+		cfg, err := GetWorkGroupConfig(context.Background(), db)
+		require.NoError(t, err)
+		assert.Equal(t, &WorkGroupConfig{
+			Name:                       "analytics",
+			State:                      athena.WorkGroupStateEnabled,
+			Enforced:                   true,
+			OutputLocation:             "s3://results/",
+			EncryptionOption:           athena.EncryptionOptionSseKms,
+			KMSKey:                     "key",
+			BytesScannedCutoffPerQuery: 1 << 30,
+			EngineVersion:              "Athena engine version 3",
+		}, cfg)
+	}
+	assert.Equal(t, 1, client.calls, "the configuration should be cached")
+}